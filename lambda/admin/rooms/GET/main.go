@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+)
+
+type RoomData struct {
+	RoomID       string   `dynamodbav:"room_id"`
+	Name         string   `dynamodbav:"name"`
+	Status       string   `dynamodbav:"status"`
+	HostID       string   `dynamodbav:"host_id"`
+	Participants []string `dynamodbav:"participants"`
+	Settings     struct {
+		Rounds int `dynamodbav:"rounds"`
+	} `dynamodbav:"settings"`
+	Round          int    `dynamodbav:"round"`
+	PublicLanguage string `dynamodbav:"public_language"`
+	PasscodeHash   string `dynamodbav:"passcode_hash"`
+	CreatedAt      int64  `dynamodbav:"created_at"`
+	LobbyDeadline  int64  `dynamodbav:"lobby_deadline"`
+	VotingDeadline int64  `dynamodbav:"voting_deadline"`
+	TTL            int64  `dynamodbav:"TTL"`
+}
+
+const (
+	roomStatusLobby    = "lobby"
+	roomStatusPlaying  = "playing"
+	roomStatusFinished = "finished"
+)
+
+type adminRoomSummary struct {
+	RoomID         string `json:"room_id"`
+	Name           string `json:"name,omitempty"`
+	Status         string `json:"status"`
+	Round          int    `json:"round"`
+	Rounds         int    `json:"rounds"`
+	PlayerCount    int    `json:"player_count"`
+	HostID         string `json:"host_id,omitempty"`
+	Public         bool   `json:"public"`
+	Private        bool   `json:"private"`
+	CreatedAt      int64  `json:"created_at"`
+	AgeSeconds     int64  `json:"age_seconds"`
+	LobbyDeadline  int64  `json:"lobby_deadline,omitempty"`
+	VotingDeadline int64  `json:"voting_deadline,omitempty"`
+	ExpiresAt      int64  `json:"expires_at"`
+}
+
+type adminRoomsResponse struct {
+	Rooms []adminRoomSummary `json:"rooms"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// 既定では終わっていないルームだけを返す
+	status := event.QueryStringParameters["status"]
+	switch status {
+	case "", roomStatusLobby, roomStatusPlaying, roomStatusFinished, "all":
+	default:
+		return createErrorResponseWithStatus(http.StatusBadRequest, "status must be one of lobby, playing, finished or all")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeRoomsAdmin); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
+	rooms, err := scanRooms(ctx, svc, status)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB scan error")
+	}
+
+	now := time.Now()
+	resp := adminRoomsResponse{Rooms: []adminRoomSummary{}}
+	for _, room := range rooms {
+		resp.Rooms = append(resp.Rooms, summarizeRoom(room, now))
+	}
+	// 古いルームほど止まっている可能性が高いので先に並べる
+	sort.SliceStable(resp.Rooms, func(i, j int) bool {
+		return resp.Rooms[i].CreatedAt < resp.Rooms[j].CreatedAt
+	})
+
+	jsonResponse, err := json.Marshal(resp)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func scanRooms(ctx context.Context, svc *dynamodb.Client, status string) ([]RoomData, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		// 問題の一覧などは大きいので読まない
+		ProjectionExpression: aws.String("room_id, #name, #status, host_id, participants, settings.rounds, #round, " +
+			"public_language, passcode_hash, created_at, lobby_deadline, voting_deadline, #ttl"),
+		ExpressionAttributeNames: map[string]string{
+			"#name":   "name",
+			"#status": "status",
+			"#round":  "round",
+			"#ttl":    "TTL",
+		},
+	}
+	switch status {
+	case "all":
+	case "":
+		input.FilterExpression = aws.String("attribute_not_exists(#status) OR #status <> :finished")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":finished": &types.AttributeValueMemberS{Value: roomStatusFinished},
+		}
+	case roomStatusLobby:
+		// status を持たない古いルームはロビーとして扱う
+		input.FilterExpression = aws.String("attribute_not_exists(#status) OR #status = :status")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: status},
+		}
+	default:
+		input.FilterExpression = aws.String("#status = :status")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: status},
+		}
+	}
+
+	var rooms []RoomData
+	paginator := dynamodb.NewScanPaginator(svc, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []RoomData
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, items...)
+	}
+	return rooms, nil
+}
+
+func summarizeRoom(room RoomData, now time.Time) adminRoomSummary {
+	summary := adminRoomSummary{
+		RoomID:         room.RoomID,
+		Name:           room.Name,
+		Status:         room.Status,
+		Round:          max(room.Round, 1),
+		Rounds:         max(room.Settings.Rounds, 1),
+		PlayerCount:    len(room.Participants),
+		HostID:         room.HostID,
+		Public:         room.PublicLanguage != "",
+		Private:        room.PasscodeHash != "",
+		CreatedAt:      room.CreatedAt,
+		LobbyDeadline:  room.LobbyDeadline,
+		VotingDeadline: room.VotingDeadline,
+		ExpiresAt:      room.TTL,
+	}
+	if summary.Status == "" {
+		summary.Status = roomStatusLobby
+	}
+	// created_at を持たない古いルームは TTL から作成日時を見積もる
+	if summary.CreatedAt == 0 && room.TTL != 0 {
+		summary.CreatedAt = room.TTL - int64(appCfg.RoomTTL/time.Second)
+	}
+	if summary.CreatedAt != 0 {
+		summary.AgeSeconds = max(now.Unix()-summary.CreatedAt, 0)
+	}
+	return summary
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
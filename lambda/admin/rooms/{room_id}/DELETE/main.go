@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+)
+
+type RoomData struct {
+	RoomID       string   `dynamodbav:"room_id"`
+	Participants []string `dynamodbav:"participants"`
+}
+
+// BatchWriteItem が1回で受け付ける件数
+const batchWriteLimit = 25
+
+// 処理されなかった削除を再送する回数
+const maxBatchAttempts = 5
+
+type adminRoomDeleteResponse struct {
+	RoomID       string `json:"room_id"`
+	DeletedUsers int    `json:"deleted_users"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeRoomsAdmin); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
+	// 先にルームを消す。参加はルームがあることを条件にしているので、消した後に増えるユーザーはいない
+	result, err := svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB delete error")
+	}
+	if result.Attributes == nil {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	var room RoomData
+	if err = attributevalue.UnmarshalMap(result.Attributes, &room); err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB delete error")
+	}
+
+	if err = deleteUsers(ctx, svc, room.Participants); err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB delete error")
+	}
+	fmt.Printf("INFO:admin deleted room %s with %d users\n", room.RoomID, len(room.Participants))
+
+	jsonResponse, err := json.Marshal(adminRoomDeleteResponse{RoomID: room.RoomID, DeletedUsers: len(room.Participants)})
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func deleteUsers(ctx context.Context, svc *dynamodb.Client, userIDs []string) error {
+	for start := 0; start < len(userIDs); start += batchWriteLimit {
+		var requests []types.WriteRequest
+		for _, userID := range userIDs[start:min(start+batchWriteLimit, len(userIDs))] {
+			requests = append(requests, types.WriteRequest{
+				DeleteRequest: &types.DeleteRequest{
+					Key: map[string]types.AttributeValue{
+						"user_id": &types.AttributeValueMemberS{Value: userID},
+					},
+				},
+			})
+		}
+		pending := map[string][]types.WriteRequest{appCfg.UserTableName: requests}
+		for attempt := 1; len(pending) > 0; attempt++ {
+			if attempt > maxBatchAttempts {
+				return fmt.Errorf("%d user deletions were not processed", len(pending[appCfg.UserTableName]))
+			}
+			if attempt > 1 {
+				// スロットリングされたので間を空ける
+				time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+			}
+			out, err := svc.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
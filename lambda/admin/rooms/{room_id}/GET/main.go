@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+)
+
+// 運営でも見る必要のない秘密の属性。ハッシュでも総当たりの手がかりになるので返さない
+var (
+	secretRoomAttributes = []string{"passcode_hash", "devices"}
+	secretUserAttributes = []string{"session_token_hash", "device_hash"}
+)
+
+// DynamoDB の項目をそのまま返す。ルームの形が変わってもダンプに手を入れなくて済む
+type adminRoomDump struct {
+	Room    map[string]any   `json:"room"`
+	Players []map[string]any `json:"players"`
+	// 参加者一覧にあるのにユーザーの項目がないプレイヤー
+	MissingPlayers []string `json:"missing_players"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeRoomsAdmin); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
+	room, err := getItem(ctx, svc, appCfg.RoomTableName, "room_id", roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if room == nil {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	for _, name := range secretRoomAttributes {
+		delete(room, name)
+	}
+	if spectators, ok := room["spectators"].(map[string]any); ok {
+		for _, spectator := range spectators {
+			if s, ok := spectator.(map[string]any); ok {
+				delete(s, "token_hash")
+			}
+		}
+	}
+
+	dump := adminRoomDump{Room: room, Players: []map[string]any{}, MissingPlayers: []string{}}
+	participants, _ := room["participants"].([]any)
+	for _, participant := range participants {
+		userID, _ := participant.(string)
+		user, err := getItem(ctx, svc, appCfg.UserTableName, "user_id", userID)
+		if err != nil {
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+		}
+		if user == nil {
+			dump.MissingPlayers = append(dump.MissingPlayers, userID)
+			continue
+		}
+		for _, name := range secretUserAttributes {
+			delete(user, name)
+		}
+		dump.Players = append(dump.Players, user)
+	}
+
+	jsonResponse, err := json.Marshal(dump)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// 項目がなければ nil を返す
+func getItem(ctx context.Context, svc *dynamodb.Client, tableName, keyName, key string) (map[string]any, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			keyName: &types.AttributeValueMemberS{Value: key},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+	var item map[string]any
+	err = attributevalue.UnmarshalMap(result.Item, &item)
+	return item, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
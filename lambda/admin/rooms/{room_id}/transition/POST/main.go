@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+	"shared/game"
+)
+
+type RoomData struct {
+	game.Room
+	LobbyDeadline int64 `json:"lobby_deadline" dynamodbav:"lobby_deadline"`
+}
+
+// 運営が指定できるフェーズの操作
+const (
+	// ロビーを準備完了の人数に関係なく始める
+	actionStart = "start"
+	// 未投票のプレイヤーを棄権にして今のラウンドを締める
+	actionCloseVoting = "close_voting"
+	// 残りのラウンドを遊ばずにゲームを終える
+	actionFinish = "finish"
+)
+
+type transitionRequest struct {
+	Action string `json:"action"`
+}
+
+type transitionResponse struct {
+	RoomID string `json:"room_id"`
+	Status string `json:"status"`
+	Round  int    `json:"round"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	var req transitionRequest
+	if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect request body")
+	}
+	switch req.Action {
+	case actionStart, actionCloseVoting, actionFinish:
+	default:
+		return createErrorResponseWithStatus(http.StatusBadRequest, "action must be one of start, close_voting or finish")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeRoomsAdmin); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	if room.Status == "" {
+		room.Status = game.StatusLobby
+	}
+
+	switch {
+	case req.Action == actionStart && room.Status == game.StatusLobby:
+		if len(room.Participants) == 0 {
+			return createErrorResponseWithStatus(http.StatusConflict, "room has no players")
+		}
+		room, err = startRoom(ctx, svc, room)
+	case req.Action == actionCloseVoting && room.Status == game.StatusPlaying:
+		room.Room, err = game.CloseVoting(ctx, svc, appCfg, room.Room)
+	case req.Action == actionFinish && room.Status == game.StatusLobby:
+		room, err = finishLobby(ctx, svc, room)
+	case req.Action == actionFinish && room.Status == game.StatusPlaying:
+		// 今のラウンドを最後のラウンドとして締めれば game.AdvanceRound がゲームを終える
+		room.Settings.Rounds = room.CurrentRound()
+		room.Room, err = game.CloseVoting(ctx, svc, appCfg, room.Room)
+	default:
+		return createErrorResponseWithStatus(http.StatusConflict, fmt.Sprintf("cannot %s a room in %s", req.Action, room.Status))
+	}
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) || errors.Is(err, game.ErrRoundChanged) || errors.Is(err, game.ErrAlreadyStarted) || errors.Is(err, game.ErrGameOver) {
+		return createErrorResponseWithStatus(http.StatusConflict, "room changed during the transition; retry")
+	}
+	if errors.Is(err, game.ErrNoCandidates) {
+		return createErrorResponseWithStatus(http.StatusConflict, "the players' answers do not allow choosing a santa and question yet")
+	}
+	if errors.Is(err, game.ErrUnansweredPlayers) {
+		return createErrorResponseWithStatus(http.StatusConflict, "some players have not answered the questions yet")
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+	fmt.Printf("INFO:admin %s room %s, now %s round %d\n", req.Action, room.RoomID, room.Status, room.CurrentRound())
+
+	jsonResponse, err := json.Marshal(transitionResponse{RoomID: room.RoomID, Status: room.Status, Round: room.CurrentRound()})
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// finalizer のロビー締め切りと同じ書き込みで、準備完了の人数だけを確かめない
+// 手動の開始と同じくサンタ・質問・投票の締め切りを固定して始める
+func startRoom(ctx context.Context, svc *dynamodb.Client, room RoomData) (RoomData, error) {
+	locked, err := game.StartGame(ctx, svc, appCfg, room.RoomID, time.Now())
+	if err != nil {
+		return room, err
+	}
+	room.Room = locked.Room
+	return room, nil
+}
+
+// 遊ばれなかったルームを終わらせる。ラウンドの結果は残らない
+func finishLobby(ctx context.Context, svc *dynamodb.Client, room RoomData) (RoomData, error) {
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: room.RoomID},
+		},
+		UpdateExpression:    aws.String("SET #status = :finished REMOVE deadline"),
+		ConditionExpression: aws.String("attribute_not_exists(#status) OR #status = :lobby"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":finished": &types.AttributeValueMemberS{Value: game.StatusFinished},
+			":lobby":    &types.AttributeValueMemberS{Value: game.StatusLobby},
+		},
+	})
+	if err != nil {
+		return room, err
+	}
+	room.Status = game.StatusFinished
+	return room, nil
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.QuestionTable, appconfig.PackTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
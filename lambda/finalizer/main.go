@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+	"shared/game"
+)
+
+type UserData struct {
+	game.Player
+	Ready bool `json:"ready" dynamodbav:"ready"`
+	// 最後にハートビートを受け取った時刻 (UNIX 秒)
+	LastSeen int64 `json:"last_seen" dynamodbav:"last_seen"`
+}
+
+type RoomData struct {
+	game.Room
+	Deadline int64 `json:"deadline" dynamodbav:"deadline"`
+}
+
+// finalizer が使う DynamoDB の操作。テストでは差し替えられる
+type dynamoAPI interface {
+	game.DB
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// 締め切りを過ぎたルームを次のフェーズへ進める
+type finalizer struct {
+	db  dynamoAPI
+	now func() time.Time
+}
+
+type finalizeResult struct {
+	Started []string `json:"started"`
+	// 投票を締め切ってラウンドを進めたルーム
+	Advanced []string `json:"advanced"`
+	Finished []string `json:"finished"`
+	// ハートビートが途絶えてロビーから退室させたプレイヤーと、投票を棄権扱いにしたプレイヤー
+	Removed   []string `json:"removed"`
+	Abstained []string `json:"abstained"`
+}
+
+func handler(ctx context.Context, event events.CloudWatchEvent) (finalizeResult, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return finalizeResult{}, err
+	}
+	f := finalizer{db: dynamodb.NewFromConfig(cfg), now: time.Now}
+	result, err := f.run(ctx)
+	fmt.Printf("INFO:started %v, advanced %v, finished %v, removed %v, abstained %v\n",
+		result.Started, result.Advanced, result.Finished, result.Removed, result.Abstained)
+	return result, err
+}
+
+func (f finalizer) run(ctx context.Context) (finalizeResult, error) {
+	result := finalizeResult{Started: []string{}, Advanced: []string{}, Finished: []string{}, Removed: []string{}, Abstained: []string{}}
+	now := f.now()
+
+	var errs []error
+	// 離脱扱いは PLAYER_IDLE_TIMEOUT を設定したときだけ
+	if appCfg.PlayerIdleTimeout > 0 {
+		rooms, err := f.activeRooms(ctx)
+		if err != nil {
+			return result, err
+		}
+		for _, room := range rooms {
+			if err := f.sweepIdlePlayers(ctx, room, now, &result); err != nil {
+				errs = append(errs, fmt.Errorf("room %s: %w", room.RoomID, err))
+			}
+		}
+	}
+
+	rooms, err := f.overdueRooms(ctx, now)
+	if err != nil {
+		return result, errors.Join(append(errs, err)...)
+	}
+	for _, room := range rooms {
+		switch room.Status {
+		case "", game.StatusLobby:
+			started, err := f.startLobby(ctx, room, now)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("room %s: %w", room.RoomID, err))
+			} else if started {
+				result.Started = append(result.Started, room.RoomID)
+			}
+		case game.StatusPlaying:
+			room, advanced, err := f.closeVoting(ctx, room)
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Errorf("room %s: %w", room.RoomID, err))
+			case !advanced:
+			case room.Status == game.StatusFinished:
+				result.Finished = append(result.Finished, room.RoomID)
+			default:
+				result.Advanced = append(result.Advanced, room.RoomID)
+			}
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// deadline を持つルームだけが入る疎な GSI。パーティションキーが status、ソートキーが deadline。
+// ロビーでは lobby_deadline、ラウンドが始まると voting_deadline と同じ値が入り、
+// ラウンドを進めるかゲームが終わると消えるので、誰も始めないルームは締め切りを過ぎても拾わない
+const deadlineIndex = "DeadlineIndex"
+
+// ロビーか投票の締め切りを過ぎたルーム
+func (f finalizer) overdueRooms(ctx context.Context, now time.Time) ([]RoomData, error) {
+	var rooms []RoomData
+	for _, status := range []string{game.StatusLobby, game.StatusPlaying} {
+		items, err := f.queryRooms(ctx, status, now.Unix())
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, items...)
+	}
+	return rooms, nil
+}
+
+// ロビーか、投票中のラウンドがあるルーム
+func (f finalizer) activeRooms(ctx context.Context) ([]RoomData, error) {
+	var rooms []RoomData
+	for _, status := range []string{game.StatusLobby, game.StatusPlaying} {
+		items, err := f.queryRooms(ctx, status, math.MaxInt64)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, items...)
+	}
+	return rooms, nil
+}
+
+// status のルームのうち deadline が before (UNIX 秒) より前のもの
+func (f finalizer) queryRooms(ctx context.Context, status string, before int64) ([]RoomData, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(appCfg.RoomTableName),
+		IndexName:              aws.String(deadlineIndex),
+		KeyConditionExpression: aws.String("#status = :status AND deadline < :before"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: status},
+			":before": &types.AttributeValueMemberN{Value: strconv.FormatInt(before, 10)},
+		},
+	}
+
+	var rooms []RoomData
+	paginator := dynamodb.NewQueryPaginator(f.db, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []RoomData
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, items...)
+	}
+	return rooms, nil
+}
+
+// last_seen を持たない古いプレイヤーは時間切れにしない
+func isIdle(user UserData, now time.Time) bool {
+	return user.LastSeen > 0 && now.Sub(time.Unix(user.LastSeen, 0)) > appCfg.PlayerIdleTimeout
+}
+
+// ロビーでは時間切れのプレイヤーを退室させ、投票中はまだ火を灯されていなければ棄権扱いにする
+func (f finalizer) sweepIdlePlayers(ctx context.Context, room RoomData, now time.Time, result *finalizeResult) error {
+	for _, participant := range room.Participants {
+		user, found, err := f.getUser(ctx, participant)
+		if err != nil {
+			return err
+		}
+		if !found || !isIdle(user, now) {
+			continue
+		}
+
+		switch room.Status {
+		case "", game.StatusLobby:
+			if err = game.RemovePlayer(ctx, f.db, appCfg, room.RoomID, participant); err != nil {
+				return err
+			}
+			result.Removed = append(result.Removed, participant)
+		case game.StatusPlaying:
+			round := room.CurrentRound()
+			if user.FiredIn(round) || user.AbstainedIn(round) {
+				continue
+			}
+			abstained, err := game.MarkAbstained(ctx, f.db, appCfg, participant, round)
+			if err != nil {
+				return err
+			}
+			if abstained {
+				result.Abstained = append(result.Abstained, participant)
+			}
+		}
+	}
+	return nil
+}
+
+// 準備完了のプレイヤーが足りていれば、手動の開始と同じくサンタ・質問・投票の締め切りを固定して始める
+func (f finalizer) startLobby(ctx context.Context, room RoomData, now time.Time) (bool, error) {
+	minPlayers := room.Settings.MinPlayers
+	if minPlayers == 0 {
+		minPlayers = appCfg.Game.MinPlayers
+	}
+	ready := 0
+	for _, participant := range room.Participants {
+		user, found, err := f.getUser(ctx, participant)
+		if err != nil {
+			return false, err
+		}
+		if found && user.Ready {
+			ready++
+		}
+	}
+	if ready < minPlayers {
+		// 足りなければ次の実行で再確認する。揃わないルームは TTL で消える
+		return false, nil
+	}
+
+	_, err := game.StartGame(ctx, f.db, appCfg, room.RoomID, now)
+	if errors.Is(err, game.ErrAlreadyStarted) || errors.Is(err, game.ErrGameOver) {
+		// 実行中にプレイヤーが始めた
+		return false, nil
+	}
+	if errors.Is(err, game.ErrNoCandidates) || errors.Is(err, game.ErrUnansweredPlayers) {
+		// 回答が揃うまでは始められない。次の実行で再確認する
+		fmt.Printf("WARN:room %s: %v\n", room.RoomID, err)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// まだ火を灯されていないプレイヤーを棄権扱いにして投票を締め切り、ラウンドを集計して進める
+func (f finalizer) closeVoting(ctx context.Context, room RoomData) (RoomData, bool, error) {
+	advanced, err := game.CloseVoting(ctx, f.db, appCfg, room.Room)
+	if errors.Is(err, game.ErrRoundChanged) {
+		// 実行中にホストが進めた
+		return room, false, nil
+	}
+	if err != nil {
+		return room, false, err
+	}
+	room.Room = advanced
+	return room, true, nil
+}
+
+func (f finalizer) getUser(ctx context.Context, userID string) (UserData, bool, error) {
+	result, err := f.db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
+	}
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.QuestionTable, appconfig.PackTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(handler)
+}
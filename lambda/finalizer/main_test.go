@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+	"shared/game"
+)
+
+type item = map[string]types.AttributeValue
+
+// テーブルの中身を持ち、更新式の SET と REMOVE を反映する DynamoDB の代わり。条件式は評価しない
+type fakeDB struct {
+	tables  map[string]map[string]item
+	queries []*dynamodb.QueryInput
+	// Query の後に呼ばれる。finalizer の実行中に他の Lambda がルームを変えたことにする
+	afterQuery func(db *fakeDB)
+	// ルームへの条件付き書き込みを条件失敗にする
+	roomChanged bool
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{tables: map[string]map[string]item{
+		appCfg.RoomTableName: {},
+		appCfg.UserTableName: {},
+	}}
+}
+
+func (f *fakeDB) put(t *testing.T, table string, v any) {
+	t.Helper()
+	it, err := attributevalue.MarshalMap(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 実際のルームは決まっていないサンタや締め切りを持たない
+	for _, attr := range []string{"santa_id", "question_id", "voting_deadline", "deadline"} {
+		switch v := it[attr].(type) {
+		case *types.AttributeValueMemberS:
+			if v.Value == "" {
+				delete(it, attr)
+			}
+		case *types.AttributeValueMemberN:
+			if v.Value == "0" {
+				delete(it, attr)
+			}
+		}
+	}
+	f.tables[table][keyOf(it)] = it
+}
+
+func (f *fakeDB) room(t *testing.T, roomID string) RoomData {
+	t.Helper()
+	var room RoomData
+	if err := attributevalue.UnmarshalMap(f.tables[appCfg.RoomTableName][roomID], &room); err != nil {
+		t.Fatal(err)
+	}
+	return room
+}
+
+func keyOf(key item) string {
+	for _, name := range []string{"room_id", "user_id"} {
+		if s, ok := key[name].(*types.AttributeValueMemberS); ok {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+func (f *fakeDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: f.tables[aws.ToString(params.TableName)][keyOf(params.Key)]}, nil
+}
+
+func (f *fakeDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	table := aws.ToString(params.TableName)
+	if table == appCfg.RoomTableName && f.roomChanged && params.ConditionExpression != nil {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	updated, err := f.apply(table, params.Key, aws.ToString(params.UpdateExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: updated}, nil
+}
+
+func (f *fakeDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	for _, it := range params.TransactItems {
+		if it.Update != nil && aws.ToString(it.Update.TableName) == appCfg.RoomTableName && f.roomChanged {
+			return nil, &types.TransactionCanceledException{
+				CancellationReasons: []types.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}},
+			}
+		}
+	}
+	for _, it := range params.TransactItems {
+		switch {
+		case it.Update != nil:
+			u := it.Update
+			if _, err := f.apply(aws.ToString(u.TableName), u.Key, aws.ToString(u.UpdateExpression), u.ExpressionAttributeNames, u.ExpressionAttributeValues); err != nil {
+				return nil, err
+			}
+		case it.Delete != nil:
+			delete(f.tables[aws.ToString(it.Delete.TableName)], keyOf(it.Delete.Key))
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	return &dynamodb.BatchGetItemOutput{}, nil
+}
+
+// DeadlineIndex と同じく status と deadline を持つルームだけを返す
+func (f *fakeDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.queries = append(f.queries, params)
+	status := params.ExpressionAttributeValues[":status"].(*types.AttributeValueMemberS).Value
+	before, _ := strconv.ParseInt(params.ExpressionAttributeValues[":before"].(*types.AttributeValueMemberN).Value, 10, 64)
+	var items []item
+	for _, it := range f.tables[appCfg.RoomTableName] {
+		s, ok := it["status"].(*types.AttributeValueMemberS)
+		d, hasDeadline := it["deadline"].(*types.AttributeValueMemberN)
+		if !ok || !hasDeadline || s.Value != status {
+			continue
+		}
+		if deadline, _ := strconv.ParseInt(d.Value, 10, 64); deadline < before {
+			copied := item{}
+			for k, v := range it {
+				copied[k] = v
+			}
+			items = append(items, copied)
+		}
+	}
+	if f.afterQuery != nil {
+		f.afterQuery(f)
+	}
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+// 更新前の項目を見て SET と REMOVE を反映し、更新後の項目を返す
+func (f *fakeDB) apply(table string, key item, expression string, names map[string]string, values item) (item, error) {
+	old := f.tables[table][keyOf(key)]
+	updated := item{}
+	for k, v := range key {
+		updated[k] = v
+	}
+	for k, v := range old {
+		updated[k] = v
+	}
+	name := func(n string) string {
+		if resolved, ok := names[n]; ok {
+			return resolved
+		}
+		return n
+	}
+	operand := func(s string) types.AttributeValue {
+		if strings.HasPrefix(s, ":") {
+			return values[s]
+		}
+		return old[name(s)]
+	}
+
+	set, remove, _ := strings.Cut(strings.TrimPrefix(expression, "SET "), "REMOVE ")
+	if strings.HasPrefix(expression, "REMOVE ") {
+		set, remove = "", strings.TrimPrefix(expression, "REMOVE ")
+	}
+	for _, clause := range splitTopLevel(set) {
+		lhs, rhs, ok := strings.Cut(clause, " = ")
+		if !ok {
+			return nil, fmt.Errorf("unsupported SET clause %q", clause)
+		}
+		var v types.AttributeValue
+		switch {
+		case strings.HasPrefix(rhs, "if_not_exists("):
+			args := splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(rhs, "if_not_exists("), ")"))
+			if v = old[name(args[0])]; v == nil {
+				v = operand(args[1])
+			}
+		case strings.HasPrefix(rhs, "list_append("):
+			args := splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(rhs, "list_append("), ")"))
+			var list []types.AttributeValue
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "if_not_exists(") {
+					inner := splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(arg, "if_not_exists("), ")"))
+					if arg = inner[0]; old[name(arg)] == nil {
+						arg = inner[1]
+					}
+				}
+				if l, ok := operand(arg).(*types.AttributeValueMemberL); ok {
+					list = append(list, l.Value...)
+				}
+			}
+			v = &types.AttributeValueMemberL{Value: list}
+		default:
+			v = operand(rhs)
+		}
+		updated[name(lhs)] = v
+	}
+	for _, attr := range splitTopLevel(remove) {
+		delete(updated, name(attr))
+	}
+	f.tables[table][keyOf(key)] = updated
+	return updated, nil
+}
+
+// 括弧の外のカンマで区切る
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" {
+		parts = append(parts, rest)
+	}
+	return parts
+}
+
+type testAnswer struct {
+	QuestionID string `dynamodbav:"question_id"`
+	Answer     bool   `dynamodbav:"answer"`
+}
+
+type testUser struct {
+	UserID  string       `dynamodbav:"user_id"`
+	Ready   bool         `dynamodbav:"ready"`
+	Answers []testAnswer `dynamodbav:"answers"`
+}
+
+type testRoom struct {
+	RoomData
+	Questions []game.Question `dynamodbav:"questions"`
+}
+
+func setTestConfig(t *testing.T) {
+	t.Helper()
+	saved := appCfg
+	appCfg = appconfig.Config{
+		RoomTableName: "rooms",
+		UserTableName: "users",
+		VotingTimeout: 5 * time.Minute,
+		Game:          appconfig.GameRules{MinPlayers: 3, MinTrueAnswers: 2},
+	}
+	t.Cleanup(func() { appCfg = saved })
+}
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func lobbyRoom(deadline time.Time) testRoom {
+	return testRoom{
+		RoomData: RoomData{
+			Room: game.Room{
+				RoomID:       "r",
+				Status:       game.StatusLobby,
+				Participants: []string{"a", "b", "c"},
+				Settings:     game.Settings{Rounds: 2},
+				Round:        1,
+			},
+			Deadline: deadline.Unix(),
+		},
+		Questions: []game.Question{{QuestionID: 1, Statement: "one"}},
+	}
+}
+
+func playingRoom(round int, deadline time.Time) testRoom {
+	room := lobbyRoom(deadline)
+	room.Status = game.StatusPlaying
+	room.Round = round
+	room.SantaID = "a"
+	room.QuestionID = 1
+	room.VotingDeadline = deadline.Unix()
+	return room
+}
+
+func players(ready bool) []testUser {
+	// a だけが false と答えたので a がサンタになる
+	return []testUser{
+		{UserID: "a", Ready: ready, Answers: []testAnswer{{QuestionID: "1", Answer: false}}},
+		{UserID: "b", Ready: ready, Answers: []testAnswer{{QuestionID: "1", Answer: true}}},
+		{UserID: "c", Ready: ready, Answers: []testAnswer{{QuestionID: "1", Answer: true}}},
+	}
+}
+
+func TestRun(t *testing.T) {
+	setTestConfig(t)
+	tests := []struct {
+		name       string
+		room       testRoom
+		users      []testUser
+		afterQuery func(db *fakeDB)
+		want       finalizeResult
+		check      func(t *testing.T, room RoomData)
+	}{
+		{
+			name:  "lobby past its deadline starts",
+			room:  lobbyRoom(testNow.Add(-time.Second)),
+			users: players(true),
+			want:  finalizeResult{Started: []string{"r"}},
+			check: func(t *testing.T, room RoomData) {
+				if room.Status != game.StatusPlaying || room.SantaID != "a" || room.QuestionID != 1 {
+					t.Errorf("room = %+v", room.Room)
+				}
+				// 開始と同時に finalizer の時計から投票の締め切りが決まり、DeadlineIndex にも載る
+				if room.VotingDeadline != testNow.Add(appCfg.VotingTimeout).Unix() || room.Deadline != room.VotingDeadline {
+					t.Errorf("voting_deadline = %d, deadline = %d", room.VotingDeadline, room.Deadline)
+				}
+			},
+		},
+		{
+			name:  "lobby before its deadline is left alone",
+			room:  lobbyRoom(testNow.Add(time.Minute)),
+			users: players(true),
+			check: func(t *testing.T, room RoomData) {
+				if room.Status != game.StatusLobby {
+					t.Errorf("status = %q", room.Status)
+				}
+			},
+		},
+		{
+			name:  "lobby without enough ready players waits",
+			room:  lobbyRoom(testNow.Add(-time.Second)),
+			users: players(false),
+			check: func(t *testing.T, room RoomData) {
+				if room.Status != game.StatusLobby {
+					t.Errorf("status = %q", room.Status)
+				}
+			},
+		},
+		{
+			name:  "lobby with a quick-joined player who has not answered waits",
+			room:  lobbyRoom(testNow.Add(-time.Second)),
+			users: append(players(true)[:2], testUser{UserID: "c", Ready: true}),
+			check: func(t *testing.T, room RoomData) {
+				if room.Status != game.StatusLobby {
+					t.Errorf("status = %q", room.Status)
+				}
+			},
+		},
+		{
+			name:  "lobby started by a player meanwhile",
+			room:  lobbyRoom(testNow.Add(-time.Second)),
+			users: players(true),
+			afterQuery: func(db *fakeDB) {
+				started := db.tables[appCfg.RoomTableName]["r"]
+				started["status"] = &types.AttributeValueMemberS{Value: game.StatusPlaying}
+				started["deadline"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(testNow.Add(time.Minute).Unix(), 10)}
+			},
+		},
+		{
+			name:  "voting past its deadline advances the round",
+			room:  playingRoom(1, testNow.Add(-time.Second)),
+			users: players(true),
+			want:  finalizeResult{Advanced: []string{"r"}},
+			check: func(t *testing.T, room RoomData) {
+				if room.Status != game.StatusPlaying || room.Round != 2 || len(room.RoundResults) != 1 {
+					t.Errorf("room = %+v", room.Room)
+				}
+				// 次のラウンドを誰かが始めるまで締め切りは無い
+				if room.SantaID != "" || room.VotingDeadline != 0 || room.Deadline != 0 {
+					t.Errorf("the next round is already locked: %+v", room)
+				}
+			},
+		},
+		{
+			name:  "voting before its deadline is left alone",
+			room:  playingRoom(1, testNow.Add(time.Minute)),
+			users: players(true),
+			check: func(t *testing.T, room RoomData) {
+				if room.Round != 1 || len(room.RoundResults) != 0 {
+					t.Errorf("room = %+v", room.Room)
+				}
+			},
+		},
+		{
+			name:  "round advanced by the host meanwhile",
+			room:  playingRoom(1, testNow.Add(-time.Second)),
+			users: players(true),
+			afterQuery: func(db *fakeDB) {
+				db.roomChanged = true
+			},
+			check: func(t *testing.T, room RoomData) {
+				if room.Round != 1 || len(room.RoundResults) != 0 {
+					t.Errorf("room = %+v", room.Room)
+				}
+			},
+		},
+		{
+			name:  "last round finishes the game",
+			room:  playingRoom(2, testNow.Add(-time.Second)),
+			users: players(true),
+			want:  finalizeResult{Finished: []string{"r"}},
+			check: func(t *testing.T, room RoomData) {
+				if room.Status != game.StatusFinished || room.Deadline != 0 {
+					t.Errorf("room = %+v", room)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB()
+			db.put(t, appCfg.RoomTableName, tt.room)
+			for _, u := range tt.users {
+				db.put(t, appCfg.UserTableName, u)
+			}
+			db.afterQuery = tt.afterQuery
+			f := finalizer{db: db, now: func() time.Time { return testNow }}
+
+			got, err := f.run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got.Started, got.Advanced, got.Finished) != fmt.Sprint(nonNil(tt.want.Started), nonNil(tt.want.Advanced), nonNil(tt.want.Finished)) {
+				t.Errorf("result = %+v, want %+v", got, tt.want)
+			}
+			for _, q := range db.queries {
+				if aws.ToString(q.IndexName) != deadlineIndex {
+					t.Errorf("query on %q, want %q", aws.ToString(q.IndexName), deadlineIndex)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, db.room(t, "r"))
+			}
+		})
+	}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// 誰も次のラウンドを始めないルームは、締め切りをいくら過ぎても進めない
+func TestRunDoesNotBurnUntouchedRooms(t *testing.T) {
+	setTestConfig(t)
+	db := newFakeDB()
+	room := playingRoom(1, testNow.Add(-time.Second))
+	room.Settings.Rounds = 5
+	db.put(t, appCfg.RoomTableName, room)
+	for _, u := range players(true) {
+		db.put(t, appCfg.UserTableName, u)
+	}
+
+	now := testNow
+	f := finalizer{db: db, now: func() time.Time { return now }}
+	if got, err := f.run(context.Background()); err != nil || len(got.Advanced) != 1 {
+		t.Fatalf("first run = %+v, %v", got, err)
+	}
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Hour)
+		got, err := f.run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Advanced) != 0 || len(got.Finished) != 0 {
+			t.Fatalf("run %d advanced an untouched room: %+v", i, got)
+		}
+	}
+	if r := db.room(t, "r"); r.Round != 2 || r.Status != game.StatusPlaying {
+		t.Errorf("room = %+v", r.Room)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+type RoomData struct {
+	RoomID       string            `json:"room_id" dynamodbav:"room_id"`
+	Name         string            `json:"name" dynamodbav:"name"`
+	Status       string            `json:"status" dynamodbav:"status"`
+	Participants []string          `json:"participants" dynamodbav:"participants"`
+	PasscodeHash string            `json:"-" dynamodbav:"passcode_hash"`
+	Invites      map[string]Invite `json:"invites" dynamodbav:"invites"`
+}
+
+// 招待リンクを開いたときに表示するルームの概要
+type invitePreview struct {
+	RoomID      string `json:"room_id"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	PlayerCount int    `json:"player_count"`
+	Private     bool   `json:"private"`
+	ExpiresAt   int64  `json:"expires_at"`
+	// 残りの利用回数。制限がなければ返さない
+	RemainingUses *int `json:"remaining_uses,omitempty"`
+}
+
+const roomStatusLobby = "lobby"
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	inviteToken, err := url.PathUnescape(event.PathParameters["invite_token"])
+	if err != nil || inviteToken == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	roomID, secretHash, ok := parseInviteToken(inviteToken)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusNotFound, "invite not found")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	invite, ok := room.Invites[secretHash]
+	if !found || !ok {
+		return createErrorResponseWithStatus(http.StatusNotFound, "invite not found")
+	}
+	if !invite.usable(time.Now()) {
+		return createErrorResponseWithStatus(http.StatusGone, "invite has expired or has been used up")
+	}
+
+	preview := invitePreview{
+		RoomID:      room.RoomID,
+		Name:        room.Name,
+		Status:      room.Status,
+		PlayerCount: len(room.Participants),
+		Private:     room.PasscodeHash != "",
+		ExpiresAt:   invite.ExpiresAt,
+	}
+	if preview.Name == "" {
+		preview.Name = room.RoomID
+	}
+	if preview.Status == "" {
+		preview.Status = roomStatusLobby
+	}
+	if invite.MaxUses > 0 {
+		remaining := invite.MaxUses - invite.Uses
+		preview.RemainingUses = &remaining
+	}
+
+	jsonResponse, err := json.Marshal(preview)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// ルームに保存する招待。キーはトークンの秘密部分のハッシュ
+type Invite struct {
+	ExpiresAt int64 `json:"expires_at" dynamodbav:"expires_at"`
+	// 0 なら回数の制限なし
+	MaxUses   int    `json:"max_uses" dynamodbav:"max_uses"`
+	Uses      int    `json:"uses" dynamodbav:"uses"`
+	CreatedBy string `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt int64  `json:"created_at" dynamodbav:"created_at"`
+}
+
+func (i Invite) usable(now time.Time) bool {
+	return now.Unix() < i.ExpiresAt && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// 招待トークンは "<base64url(room_id)>.<秘密>"。ルームはトークンだけから引ける
+func parseInviteToken(token string) (roomID, secretHash string, ok bool) {
+	encoded, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(b) == 0 {
+		return "", "", false
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return string(b), hex.EncodeToString(sum[:]), true
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+	"shared/nickname"
+	"shared/question"
+)
+
+// 公開ルームの一覧と、新しく作った公開ルームの項目
+type RoomData struct {
+	game.RoomItem
+}
+
+type UserData struct {
+	UserID           string `json:"user_id"`
+	NickName         string `json:"nickname"`
+	RoomID           string `json:"room_id"`
+	SessionToken     string `json:"session_token"`
+	SessionTokenHash string `json:"-"`
+	DeviceHash       string `json:"-"`
+}
+
+type requestBody struct {
+	NickName string `json:"nickname"`
+	// 省略すると DEFAULT_LOCALE
+	Language string `json:"language"`
+	DeviceID string `json:"device_id"`
+}
+
+type quickJoinQuestion struct {
+	QuestionID int    `json:"question_id"`
+	Statement  string `json:"statement"`
+}
+
+type quickJoinResponse struct {
+	UserData
+	// 新しく作ったルームに入ったか
+	Created bool `json:"created"`
+	// 回答は PUT /room/{room_id}/players/{user_id}/answers で送る
+	Questions []quickJoinQuestion `json:"questions"`
+}
+
+const (
+	roomStatusLobby    = "lobby"
+	roomStatusFinished = "finished"
+	// 満員や同時参加で入れなかったときに試す公開ルームの数
+	maxQuickJoinAttempts = 5
+)
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var req requestBody
+	if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "JSON parse error")
+	}
+	name, err := nickname.Normalize(req.NickName, appCfg.Game.NickNameMaxLength)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusUnprocessableEntity, err.Error())
+	}
+	if nickname.ContainsBlockedWord(name, appCfg.Game.NickNameBlocklist) {
+		return createErrorResponseWithStatus(http.StatusUnprocessableEntity, "nickname contains a blocked word")
+	}
+	language := strings.ToLower(req.Language)
+	if language == "" {
+		language = appCfg.DefaultLocale
+	}
+	if !validLanguage(language) {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "invalid language")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	user := UserData{UserID: uuid.New().String(), NickName: name}
+	user.SessionToken, user.SessionTokenHash, err = apigw.NewSessionToken()
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "could not issue session token")
+	}
+	if req.DeviceID != "" {
+		user.DeviceHash = apigw.HashSecret(req.DeviceID)
+	}
+
+	rooms, err := getPublicRooms(ctx, svc, language)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if room, ok := joinedRoom(rooms, user.DeviceHash); ok {
+		return createErrorResponseWithStatus(http.StatusConflict, "already joined room "+room.RoomID+"; use POST /room/{room_id}/rejoin")
+	}
+
+	// 人の多いロビーから順に入れるか試す
+	candidates := openRooms(rooms, name)
+	if len(candidates) > maxQuickJoinAttempts {
+		candidates = candidates[:maxQuickJoinAttempts]
+	}
+	var joined *RoomData
+	for i := range candidates {
+		err = joinRoom(ctx, svc, candidates[i], user)
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			// 満員になったか、同じニックネームの人が先に入った
+			continue
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+		}
+		joined = &candidates[i]
+		break
+	}
+
+	created := false
+	if joined == nil {
+		room, err := createPublicRoom(ctx, svc, language)
+		if err != nil {
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "could not create a room")
+		}
+		if err = joinRoom(ctx, svc, room, user); err != nil {
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+		}
+		joined, created = &room, true
+	}
+
+	user.RoomID = joined.RoomID
+	resp := quickJoinResponse{UserData: user, Created: created, Questions: []quickJoinQuestion{}}
+	for _, q := range joined.Questions {
+		statement := q.Statements[language]
+		if statement == "" {
+			statement = q.Statement
+		}
+		resp.Questions = append(resp.Questions, quickJoinQuestion{QuestionID: q.QuestionID, Statement: statement})
+	}
+	jsonResponse, err := json.Marshal(resp)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// "ja" や "pt-br" のような言語タグ
+func validLanguage(lang string) bool {
+	if len(lang) < 2 || len(lang) > 16 {
+		return false
+	}
+	for _, r := range lang {
+		if (r < 'a' || r > 'z') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// 空きがあり、同じニックネームのいないロビーを人の多い順に並べる
+func openRooms(rooms []RoomData, name string) []RoomData {
+	var open []RoomData
+	for _, room := range rooms {
+		if room.Status != "" && room.Status != roomStatusLobby {
+			continue
+		}
+		if len(room.Participants) >= room.capacity() {
+			continue
+		}
+		if _, taken := room.NickNames[nickname.Key(name)]; taken {
+			continue
+		}
+		open = append(open, room)
+	}
+	sort.SliceStable(open, func(i, j int) bool { return len(open[i].Participants) > len(open[j].Participants) })
+	return open
+}
+
+// 既定の設定で公開ルームを作る
+func createPublicRoom(ctx context.Context, svc *dynamodb.Client, language string) (RoomData, error) {
+	questions, err := question.Draw(ctx, svc, appCfg, "", appCfg.Game.QuestionCount)
+	if err != nil {
+		return RoomData{}, err
+	}
+	roomID, err := game.NewRoomID()
+	if err != nil {
+		return RoomData{}, err
+	}
+	settings := game.RoomSettings{
+		QuestionCount: appCfg.Game.QuestionCount,
+		MinPlayers:    appCfg.Game.MinPlayers,
+		Rounds:        appCfg.Game.Rounds,
+		MaxPlayers:    appCfg.Game.MaxPlayers,
+		Public:        true,
+		Language:      language,
+	}
+	room := RoomData{game.NewRoomItem(appCfg, roomID, settings, questions, time.Now())}
+	return room, game.CreateRoom(ctx, svc, appCfg, room.RoomItem)
+}
+
+// 回答のないプレイヤーとして参加させる。回答はゲームを始めるまでに PUT /room/{room_id}/players/{user_id}/answers で送らせる
+func joinRoom(ctx context.Context, svc *dynamodb.Client, room RoomData, user UserData) error {
+	return game.JoinRoom(ctx, svc, appCfg, room.RoomID, game.Joiner{
+		UserID:           user.UserID,
+		NickName:         user.NickName,
+		NickNameKey:      nickname.Key(user.NickName),
+		SessionTokenHash: user.SessionTokenHash,
+		DeviceHash:       user.DeviceHash,
+	}, game.JoinOptions{Capacity: room.capacity()}, time.Now())
+}
+
+// 公開ルームだけが public_language を持つ疎なインデックス
+const publicRoomIndex = "PublicRoomIndex"
+
+// 言語を指定すればその言語の公開ルームを、しなければすべての公開ルームを返す。終わったルームは除く
+// 端末がまだ参加者として残っているルーム。退室やキックで参加者一覧から外れた端末は数えない
+func joinedRoom(rooms []RoomData, deviceHash string) (RoomData, bool) {
+	if deviceHash == "" {
+		return RoomData{}, false
+	}
+	for _, room := range rooms {
+		if userID, ok := room.Devices[deviceHash]; ok && slices.Contains(room.Participants, userID) {
+			return room, true
+		}
+	}
+	return RoomData{}, false
+}
+
+func getPublicRooms(ctx context.Context, svc *dynamodb.Client, language string) ([]RoomData, error) {
+	var rooms []RoomData
+	names := map[string]string{"#status": "status"}
+	values := map[string]types.AttributeValue{
+		":finished": &types.AttributeValueMemberS{Value: roomStatusFinished},
+	}
+	filter := aws.String("attribute_not_exists(#status) OR #status <> :finished")
+	var lastKey map[string]types.AttributeValue
+	for {
+		var items []map[string]types.AttributeValue
+		if language != "" {
+			values[":language"] = &types.AttributeValueMemberS{Value: language}
+			out, err := svc.Query(ctx, &dynamodb.QueryInput{
+				TableName:                 aws.String(appCfg.RoomTableName),
+				IndexName:                 aws.String(publicRoomIndex),
+				KeyConditionExpression:    aws.String("public_language = :language"),
+				FilterExpression:          filter,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				ExclusiveStartKey:         lastKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			items, lastKey = out.Items, out.LastEvaluatedKey
+		} else {
+			out, err := svc.Scan(ctx, &dynamodb.ScanInput{
+				TableName:                 aws.String(appCfg.RoomTableName),
+				IndexName:                 aws.String(publicRoomIndex),
+				FilterExpression:          filter,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				ExclusiveStartKey:         lastKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			items, lastKey = out.Items, out.LastEvaluatedKey
+		}
+		var page []RoomData
+		if err := attributevalue.UnmarshalListOfMaps(items, &page); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, page...)
+		if lastKey == nil {
+			return rooms, nil
+		}
+	}
+}
+
+// 定員の設定がない古いルームは既定値を使う
+func (r RoomData) capacity() int {
+	if r.Settings.MaxPlayers > 0 {
+		return r.Settings.MaxPlayers
+	}
+	return appCfg.Game.MaxPlayers
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.QuestionTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
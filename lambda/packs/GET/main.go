@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+type QuestionPack struct {
+	PackID      string `json:"pack_id" dynamodbav:"pack_id"`
+	Name        string `json:"name" dynamodbav:"name"`
+	QuestionIDs []int  `json:"question_ids" dynamodbav:"question_ids"`
+}
+
+type response struct {
+	Packs []QuestionPack `json:"packs"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return serverErrorResponse(err)
+	}
+
+	svc := dynamodb.NewFromConfig(cfg)
+
+	packs := make([]QuestionPack, 0)
+	paginator := dynamodb.NewScanPaginator(svc, &dynamodb.ScanInput{
+		TableName: aws.String(appCfg.PackTableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return serverErrorResponse(fmt.Errorf("error scanning DynamoDB table: %v", err))
+		}
+		var items []QuestionPack
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return serverErrorResponse(fmt.Errorf("error unmarshalling item: %v", err))
+		}
+		packs = append(packs, items...)
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].PackID < packs[j].PackID })
+
+	jsonResponse, err := json.Marshal(response{Packs: packs})
+	if err != nil {
+		return serverErrorResponse(fmt.Errorf("error marshalling items to JSON: %v", err))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func serverErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	fmt.Println(err.Error())
+	return events.APIGatewayProxyResponse{
+		StatusCode: 500,
+		Body:       `{"message": "Internal Server Error"}`,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.PackTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+)
+
+type QuestionPack struct {
+	PackID      string `json:"pack_id" dynamodbav:"pack_id"`
+	Name        string `json:"name" dynamodbav:"name"`
+	QuestionIDs []int  `json:"question_ids" dynamodbav:"question_ids"`
+}
+
+type requestBody struct {
+	Name        string `json:"name"`
+	QuestionIDs []int  `json:"question_ids"`
+}
+
+// パックに含める質問が全てテーブルに存在するかを確認し、無いものを返す。論理削除された質問も無いものとして扱う
+func findUnknownQuestionIDs(ctx context.Context, svc *dynamodb.Client, questionIDs []int) ([]int, error) {
+	known := make(map[int]bool)
+	for start := 0; start < len(questionIDs); start += 100 {
+		var keys []map[string]types.AttributeValue
+		for _, id := range questionIDs[start:min(start+100, len(questionIDs))] {
+			keys = append(keys, map[string]types.AttributeValue{
+				"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(id)},
+			})
+		}
+		requestItems := map[string]types.KeysAndAttributes{
+			appCfg.QuestionTableName: {Keys: keys, ProjectionExpression: aws.String("question_id, deleted_at")},
+		}
+		for len(requestItems) > 0 {
+			response, err := svc.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return nil, err
+			}
+			var found []struct {
+				QuestionID int   `dynamodbav:"question_id"`
+				DeletedAt  int64 `dynamodbav:"deleted_at"`
+			}
+			if err = attributevalue.UnmarshalListOfMaps(response.Responses[appCfg.QuestionTableName], &found); err != nil {
+				return nil, err
+			}
+			for _, q := range found {
+				known[q.QuestionID] = q.DeletedAt == 0
+			}
+			requestItems = response.UnprocessedKeys
+		}
+	}
+
+	var unknown []int
+	for _, id := range questionIDs {
+		if !known[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	return unknown, nil
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	packID, err := url.PathUnescape(event.PathParameters["pack_id"])
+	if err != nil || packID == "" {
+		return createErrorResponseWithStatus(400, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(500, "Internal server error")
+	}
+
+	svc := dynamodb.NewFromConfig(cfg)
+
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeQuestionsWrite); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
+	var req requestBody
+	if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
+		return createErrorResponseWithStatus(400, "JSON parse error")
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return createErrorResponseWithStatus(400, "name is required")
+	}
+	if len(req.QuestionIDs) == 0 {
+		return createErrorResponseWithStatus(400, "question_ids is required")
+	}
+	seen := make(map[int]bool)
+	for _, id := range req.QuestionIDs {
+		if seen[id] {
+			return createErrorResponseWithStatus(400, fmt.Sprintf("question_id %d is duplicated", id))
+		}
+		seen[id] = true
+	}
+
+	unknown, err := findUnknownQuestionIDs(ctx, svc, req.QuestionIDs)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(500, "DB get error")
+	}
+	if len(unknown) > 0 {
+		return createErrorResponseWithStatus(400, fmt.Sprintf("unknown question_ids: %v", unknown))
+	}
+
+	pack := QuestionPack{
+		PackID:      packID,
+		Name:        req.Name,
+		QuestionIDs: req.QuestionIDs,
+	}
+	item, err := attributevalue.MarshalMap(pack)
+	if err != nil {
+		return createErrorResponseWithStatus(500, err.Error())
+	}
+	_, err = svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(appCfg.PackTableName),
+		Item:      item,
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(500, "DB write error")
+	}
+
+	jsonResponse, err := json.Marshal(pack)
+	if err != nil {
+		return createErrorResponseWithStatus(500, err.Error())
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.QuestionTable, appconfig.PackTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -5,6 +5,9 @@ go 1.21
 require (
 	github.com/aws/aws-lambda-go v1.42.0
 	github.com/aws/aws-sdk-go v1.49.1
+	shared v0.0.0
 )
 
 require github.com/jmespath/go-jmespath v0.4.0 // indirect
+
+replace shared => ../../shared
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
+	"log"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -12,55 +17,207 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"shared/apigw"
+	"shared/appconfig"
 )
 
 type Question struct {
-	QuestionID int    `json:"question_id" dynamodbav:"question_id"`
-	Statement  string `json:"statement" dynamodbav:"statement"`
+	QuestionID     int               `json:"question_id" dynamodbav:"question_id"`
+	Statement      string            `json:"statement" dynamodbav:"statement"`
+	Statements     map[string]string `json:"-" dynamodbav:"statements"`
+	Locale         string            `json:"locale" dynamodbav:"-"`
+	Category       string            `json:"category" dynamodbav:"category"`
+	Tags           []string          `json:"tags" dynamodbav:"tags"`
+	Enabled        *bool             `json:"-" dynamodbav:"enabled"`
+	AudienceRating string            `json:"audience_rating" dynamodbav:"audience_rating"`
+	Version        int               `json:"version" dynamodbav:"version"`
+	DeletedAt      int64             `json:"-" dynamodbav:"deleted_at"`
+}
+
+// enabled が未設定の古い質問は有効として扱う。論理削除された質問は無効
+func (q Question) isEnabled() bool {
+	return (q.Enabled == nil || *q.Enabled) && q.DeletedAt == 0
+}
+
+func (q Question) hasTag(tag string) bool {
+	for _, t := range q.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// 無効な質問を除き、category/tag が指定されていれば絞り込む。文面は希望するロケールで返す
+func filterQuestions(questions []Question, category, tag string, locales []string) []Question {
+	filtered := make([]Question, 0, len(questions))
+	for _, q := range questions {
+		if !q.isEnabled() {
+			continue
+		}
+		if category != "" && q.Category != category {
+			continue
+		}
+		if tag != "" && !q.hasTag(tag) {
+			continue
+		}
+		if q.Tags == nil {
+			q.Tags = []string{}
+		}
+		q.Statement, q.Locale = apigw.Localize(q.Statement, q.Statements, locales, appCfg.DefaultLocale)
+		filtered = append(filtered, q)
+	}
+	return filtered
 }
 
 type Response struct {
 	Questions []Question `json:"questions"`
 }
 
+type cachedQuestions struct {
+	questions []Question
+	expiresAt time.Time
+}
+
+// ウォームなコンテナでは質問一覧を使い回す
+var questionsCache cachedQuestions
+
+// LastEvaluatedKey を辿ってテーブル全体を読む
+func scanQuestions(ctx context.Context, svc *dynamodb.DynamoDB, tableName string) ([]Question, error) {
+	if time.Now().Before(questionsCache.expiresAt) {
+		return questionsCache.questions, nil
+	}
+
+	questions := make([]Question, 0)
+	var unmarshalErr error
+	err := svc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, i := range page.Items {
+			question := Question{}
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(i, &question); unmarshalErr != nil {
+				return false
+			}
+			questions = append(questions, question)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning DynamoDB table: %v", err)
+	}
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("error unmarshalling item: %v", unmarshalErr)
+	}
+	sort.Slice(questions, func(i, j int) bool { return questions[i].QuestionID < questions[j].QuestionID })
+
+	questionsCache = cachedQuestions{questions: questions, expiresAt: time.Now().Add(appCfg.QuestionCacheTTL)}
+	return questions, nil
+}
+
+// ルーム作成時に固定された質問
+type RoomQuestion struct {
+	QuestionID int               `dynamodbav:"question_id"`
+	Statement  string            `dynamodbav:"statement"`
+	Statements map[string]string `dynamodbav:"statements"`
+}
+
+// ルームに固定された質問を返す。ルームが無ければ found は false
+func getRoomQuestions(ctx context.Context, svc *dynamodb.DynamoDB, roomID string) ([]RoomQuestion, bool, error) {
+	roomResult, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"room_id": {S: aws.String(roomID)},
+		},
+		ProjectionExpression: aws.String("questions"),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if roomResult.Item == nil {
+		return nil, false, nil
+	}
+	var room struct {
+		Questions []RoomQuestion `dynamodbav:"questions"`
+	}
+	if err := dynamodbattribute.UnmarshalMap(roomResult.Item, &room); err != nil {
+		return nil, true, err
+	}
+	return room.Questions, true, nil
+}
+
+// ルームに固定された質問を固定された順番と文面で返す。category や tags はテーブルの今の値を使う。
+// 固定後に無効化・削除された質問もゲームでは出るので残す
+func roomSnapshotQuestions(questions []Question, snapshot []RoomQuestion) []Question {
+	byID := make(map[int]Question, len(questions))
+	for _, q := range questions {
+		byID[q.QuestionID] = q
+	}
+	selected := make([]Question, 0, len(snapshot))
+	for _, rq := range snapshot {
+		q := byID[rq.QuestionID]
+		q.QuestionID = rq.QuestionID
+		q.Statement = rq.Statement
+		q.Statements = rq.Statements
+		q.Enabled = nil
+		q.DeletedAt = 0
+		selected = append(selected, q)
+	}
+	return selected
+}
+
 func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	fmt.Printf("questions\n")
 
-	tableName := os.Getenv("TABLE_NAME")
-	if tableName == "" {
-		return serverErrorResponse(fmt.Errorf("TABLE_NAME environment variable is not set"))
-	}
+	tableName := appCfg.QuestionTableName
 
 	sess := session.Must(session.NewSession())
 
 	svc := dynamodb.New(sess)
 
-	result, err := svc.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
+	questions, err := scanQuestions(ctx, svc, tableName)
 	if err != nil {
-		return serverErrorResponse(fmt.Errorf("error scanning DynamoDB table: %v", err))
+		return serverErrorResponse(err)
 	}
-
-	questions := make([]Question, 0)
-	for _, i := range result.Items {
-		question := Question{}
-		err := dynamodbattribute.UnmarshalMap(i, &question)
+	if roomID := event.QueryStringParameters["room_id"]; roomID != "" {
+		snapshot, found, err := getRoomQuestions(ctx, svc, roomID)
 		if err != nil {
-			return serverErrorResponse(fmt.Errorf("error unmarshalling item: %v", err))
+			return serverErrorResponse(err)
+		}
+		if !found {
+			return notFoundResponse(fmt.Errorf("room %v not found", roomID))
+		}
+		// 質問が固定される前に作られた古いルームはテーブルの質問をそのまま使う
+		if len(snapshot) > 0 {
+			questions = roomSnapshotQuestions(questions, snapshot)
 		}
-		questions = append(questions, question)
 	}
+	questions = filterQuestions(questions, event.QueryStringParameters["category"], event.QueryStringParameters["tag"], apigw.PreferredLocales(event, appCfg.DefaultLocale))
 
 	jsonResponse, err := json.Marshal(Response{Questions: questions})
 	if err != nil {
 		return serverErrorResponse(fmt.Errorf("error marshalling items to JSON: %v", err))
 	}
 
+	sum := sha256.Sum256(jsonResponse)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		"Cache-Control": fmt.Sprintf("public, max-age=%d", int(appCfg.QuestionCacheTTL.Seconds())),
+		"ETag":          etag,
+		"Vary":          "Accept-Language",
+	}
+	if strings.Contains(apigw.RequestHeader(event, "If-None-Match"), etag) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 304,
+			Headers:    headers,
+		}, nil
+	}
+
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
 		Body:       string(jsonResponse),
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    headers,
 	}, nil
 }
 
@@ -69,10 +226,26 @@ func serverErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
 	return events.APIGatewayProxyResponse{
 		StatusCode: 500,
 		Body:       `{"message": "Internal Server Error"}`,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
+func notFoundResponse(err error) (events.APIGatewayProxyResponse, error) {
+	fmt.Println(err.Error())
+	return events.APIGatewayProxyResponse{
+		StatusCode: 404,
+		Body:       `{"message": "Not Found"}`,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
 func main() {
-	lambda.Start(handler)
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.QuestionTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
 }
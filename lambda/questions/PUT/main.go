@@ -3,37 +3,247 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"log"
+	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+	"shared/question"
 )
 
 type Question struct {
-	QuestionID int    `json:"question_id" dynamodbav:"question_id"`
-	Statement  string `json:"statement" dynamodbav:"statement"`
+	question.Content
+	// 書き込むたびに1つ増える版。省略すると新規作成だけ、指定するとその版のときだけ置き換える
+	Version *int `json:"version,omitempty" dynamodbav:"version"`
+}
+
+// 既存の質問に1言語分の文面だけを追加・更新する
+type Translation struct {
+	QuestionID int    `json:"question_id"`
+	Locale     string `json:"locale"`
+	Statement  string `json:"statement"`
 }
 
 type requestBody struct {
-	Questions []Question `json:"questions"`
+	Questions    []Question    `json:"questions"`
+	Translations []Translation `json:"translations"`
 }
 
 type response struct {
-	Questions []Question `json:"questions"`
+	Questions    []Question    `json:"questions"`
+	Translations []Translation `json:"translations"`
 }
 
-func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	tableName := os.Getenv("TABLE_NAME")
-	fmt.Println(tableName)
-	if tableName == "" {
-		return serverErrorResponse(fmt.Errorf("TABLE_NAME environment variable is not set"))
+func normalizeTranslation(t *Translation) error {
+	if t.QuestionID <= 0 {
+		return fmt.Errorf("question_id must be positive: %d", t.QuestionID)
+	}
+	t.Locale = strings.ToLower(strings.TrimSpace(t.Locale))
+	if t.Locale == "" {
+		return fmt.Errorf("translation for question %d has empty locale", t.QuestionID)
+	}
+	if strings.TrimSpace(t.Statement) == "" {
+		return fmt.Errorf("translation for question %d has empty statement", t.QuestionID)
+	}
+	return nil
+}
+
+// 1回の TransactWriteItems に入れられる件数
+const maxTransactItems = 100
+
+// 質問1件への書き込み。失敗したときは理由を調べるために質問と期待した版を持っておく
+type write struct {
+	item       types.TransactWriteItem
+	questionID int
+	// 置き換える質問で期待した版。nil なら新規作成だけを受け付けた
+	version     *int
+	translation bool
+}
+
+// 質問を作成するか置き換えて版を進める。version がなければ新規作成だけ、あればその版のときだけ置き換える。
+// 論理削除された質問は置き換えず、復元したいときは作り直してもらう
+func questionWrite(tableName string, q Question) (write, error) {
+	statements, err := attributevalue.Marshal(q.Statements)
+	if err != nil {
+		return write{}, err
+	}
+	tags, err := attributevalue.Marshal(q.Tags)
+	if err != nil {
+		return write{}, err
+	}
+	update := &types.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(q.QuestionID)},
+		},
+		UpdateExpression: aws.String("SET #statement = :statement, #statements = :statements, #category = :category, #tags = :tags, " +
+			"#enabled = :enabled, #audience_rating = :audience_rating, #version = if_not_exists(#version, :zero) + :one"),
+		ExpressionAttributeNames: map[string]string{
+			"#statement":       "statement",
+			"#statements":      "statements",
+			"#category":        "category",
+			"#tags":            "tags",
+			"#enabled":         "enabled",
+			"#audience_rating": "audience_rating",
+			"#version":         "version",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":statement":       &types.AttributeValueMemberS{Value: q.Statement},
+			":statements":      statements,
+			":category":        &types.AttributeValueMemberS{Value: q.Category},
+			":tags":            tags,
+			":enabled":         &types.AttributeValueMemberBOOL{Value: *q.Enabled},
+			":audience_rating": &types.AttributeValueMemberS{Value: q.AudienceRating},
+			":zero":            &types.AttributeValueMemberN{Value: "0"},
+			":one":             &types.AttributeValueMemberN{Value: "1"},
+		},
+		// 失敗したときに、既にあったのか、削除済みか、版が違ったのかを見分ける
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	switch {
+	case q.Version == nil:
+		update.ConditionExpression = aws.String("attribute_not_exists(question_id)")
+	case *q.Version == 0:
+		// 版を持たない古い質問と新しい質問は版 0 として扱う
+		update.ConditionExpression = aws.String("attribute_not_exists(#version) AND attribute_not_exists(deleted_at)")
+	default:
+		update.ConditionExpression = aws.String("#version = :expected AND attribute_not_exists(deleted_at)")
+		update.ExpressionAttributeValues[":expected"] = &types.AttributeValueMemberN{Value: strconv.Itoa(*q.Version)}
+	}
+	return write{item: types.TransactWriteItem{Update: update}, questionID: q.QuestionID, version: q.Version}, nil
+}
+
+// 1つの質問への翻訳をまとめて反映する。既定のロケールなら statement も書き換える
+func translationWrite(tableName string, questionID int, translations []Translation) write {
+	updateExpression := "SET #version = if_not_exists(#version, :zero) + :one"
+	names := map[string]string{"#version": "version"}
+	values := map[string]types.AttributeValue{
+		":zero": &types.AttributeValueMemberN{Value: "0"},
+		":one":  &types.AttributeValueMemberN{Value: "1"},
+	}
+	for i, t := range translations {
+		n := strconv.Itoa(i)
+		updateExpression += ", statements.#locale" + n + " = :statement" + n
+		names["#locale"+n] = t.Locale
+		values[":statement"+n] = &types.AttributeValueMemberS{Value: t.Statement}
+		if t.Locale == appCfg.DefaultLocale {
+			updateExpression += ", statement = :statement" + n
+		}
+	}
+	return write{
+		item: types.TransactWriteItem{Update: &types.Update{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(questionID)},
+			},
+			UpdateExpression: aws.String(updateExpression),
+			// 論理削除された質問には翻訳を足さない
+			ConditionExpression:                 aws.String("attribute_exists(question_id) AND attribute_not_exists(deleted_at)"),
+			ExpressionAttributeNames:            names,
+			ExpressionAttributeValues:           values,
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		}},
+		questionID:  questionID,
+		translation: true,
+	}
+}
+
+// statements を持たない古い質問のために、翻訳の前に空のマップを用意する。既にあれば何も変わらない
+func ensureStatements(ctx context.Context, svc *dynamodb.Client, tableName string, questionID int) error {
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(questionID)},
+		},
+		UpdateExpression:    aws.String("SET statements = if_not_exists(statements, :empty)"),
+		ConditionExpression: aws.String("attribute_exists(question_id) AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	return err
+}
+
+// リクエスト全体を確かめてから書き込みを組み立てる。同じ質問への書き込みは1件にまとめる
+// (1つのトランザクションで同じ項目は2回扱えない)
+func planWrites(tableName string, req requestBody) ([]write, error) {
+	var writes []write
+	questionIDs := map[int]bool{}
+	for _, q := range req.Questions {
+		if questionIDs[q.QuestionID] {
+			return nil, fmt.Errorf("question %d appears more than once", q.QuestionID)
+		}
+		questionIDs[q.QuestionID] = true
+		w, err := questionWrite(tableName, q)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, w)
+	}
+
+	var order []int
+	grouped := map[int][]Translation{}
+	for _, t := range req.Translations {
+		if questionIDs[t.QuestionID] {
+			return nil, fmt.Errorf("question %d is replaced in the same request; put the translation in its statements", t.QuestionID)
+		}
+		for _, other := range grouped[t.QuestionID] {
+			if other.Locale == t.Locale {
+				return nil, fmt.Errorf("translation for question %d in %q appears more than once", t.QuestionID, t.Locale)
+			}
+		}
+		if grouped[t.QuestionID] == nil {
+			order = append(order, t.QuestionID)
+		}
+		grouped[t.QuestionID] = append(grouped[t.QuestionID], t)
+	}
+	for _, id := range order {
+		writes = append(writes, translationWrite(tableName, id, grouped[id]))
+	}
+	return writes, nil
+}
+
+// 条件に合わなかった書き込みを、その時点の質問からステータスとメッセージにする
+func describeFailure(w write, item map[string]types.AttributeValue) (int, string) {
+	var current struct {
+		Version   int   `dynamodbav:"version"`
+		DeletedAt int64 `dynamodbav:"deleted_at"`
+	}
+	if item != nil {
+		if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
 	}
+	switch {
+	case item == nil && w.translation:
+		return http.StatusBadRequest, fmt.Sprintf("question %d does not exist", w.questionID)
+	case item == nil:
+		return http.StatusPreconditionFailed, fmt.Sprintf("question %d does not exist yet; send version 0 or omit it to create it", w.questionID)
+	case current.DeletedAt != 0:
+		return http.StatusGone, fmt.Sprintf("question %d has been deleted", w.questionID)
+	case w.version == nil:
+		return http.StatusConflict, fmt.Sprintf("question %d already exists; send its version to replace it", w.questionID)
+	default:
+		return http.StatusPreconditionFailed, fmt.Sprintf("question %d is no longer at version %d (now %d)", w.questionID, *w.version, current.Version)
+	}
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	tableName := appCfg.QuestionTableName
 
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
@@ -42,28 +252,84 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 
 	svc := dynamodb.NewFromConfig(cfg)
 
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeQuestionsWrite); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
 	var req requestBody
 	if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
-		return serverErrorResponse(err)
+		return badRequestErrorResponse(err)
 	}
-	fmt.Println("request body: %v", req)
+	fmt.Printf("request body: %v\n", req)
 
-	for _, q := range req.Questions {
-		fmt.Println(strconv.Itoa(q.QuestionID), q.Statement)
-		_, err := svc.PutItem(ctx, &dynamodb.PutItemInput{
-			TableName: aws.String(tableName),
-			Item: map[string]types.AttributeValue{
-				"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(q.QuestionID)},
-				"statement":   &types.AttributeValueMemberS{Value: q.Statement},
-			},
-			//ConditionExpression: aws.String("attribute_not_exists(question_id)"),
-		})
+	for i := range req.Questions {
+		if err := req.Questions[i].Normalize(appCfg.DefaultLocale); err != nil {
+			return badRequestErrorResponse(err)
+		}
+	}
+	for i := range req.Translations {
+		if err := normalizeTranslation(&req.Translations[i]); err != nil {
+			return badRequestErrorResponse(err)
+		}
+	}
+
+	writes, err := planWrites(tableName, req)
+	if err != nil {
+		return badRequestErrorResponse(err)
+	}
+	for _, w := range writes {
+		if !w.translation {
+			continue
+		}
+		err := ensureStatements(ctx, svc, tableName, w.questionID)
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			status, message := describeFailure(w, conditionErr.Item)
+			return createWriteErrorResponse(status, message, w.questionID, nil)
+		}
+		if err != nil {
+			return serverErrorResponse(fmt.Errorf("error preparing translations: %v", err))
+		}
+	}
+
+	// チャンクごとにまとめて書き込む。チャンクの中は全部反映されるか何も反映されない
+	applied := []int{}
+	for start := 0; start < len(writes); start += maxTransactItems {
+		chunk := writes[start:min(start+maxTransactItems, len(writes))]
+		items := make([]types.TransactWriteItem, len(chunk))
+		for i, w := range chunk {
+			items[i] = w.item
+		}
+		_, err := svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			for i, reason := range canceled.CancellationReasons {
+				if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+					status, message := describeFailure(chunk[i], reason.Item)
+					return createWriteErrorResponse(status, message, chunk[i].questionID, applied)
+				}
+			}
+			// 同じ質問への書き込みが同時にあった
+			return createWriteErrorResponse(http.StatusConflict, "questions were modified concurrently; retry", 0, applied)
+		}
 		if err != nil {
-			return serverErrorResponse(fmt.Errorf("error putting questions to dynamodb: %v", err))
+			return serverErrorResponse(fmt.Errorf("error writing questions to dynamodb: %v", err))
+		}
+		for _, w := range chunk {
+			applied = append(applied, w.questionID)
+		}
+	}
+
+	// 書き込んだ版は条件から決まる
+	for i, q := range req.Questions {
+		next := 1
+		if q.Version != nil {
+			next = *q.Version + 1
 		}
+		req.Questions[i].Version = &next
 	}
 
-	jsonResponse, err := json.Marshal(response{Questions: req.Questions})
+	jsonResponse, err := json.Marshal(response{Questions: req.Questions, Translations: req.Translations})
 	if err != nil {
 		return serverErrorResponse(fmt.Errorf("error marshalling items to JSON: %v", err))
 	}
@@ -71,7 +337,7 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
 		Body:       string(jsonResponse),
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
@@ -80,10 +346,61 @@ func serverErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
 	return events.APIGatewayProxyResponse{
 		StatusCode: 500,
 		Body:       `{"message": "Internal Server Error"}`,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func badRequestErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	fmt.Println(err.Error())
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Body:       `{"message": "Bad Request"}`,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+// 書き込めなかったときの本文。チャンクに分けて書くので、それより前に反映された質問も返す
+type writeErrorBody struct {
+	Message            string `json:"message"`
+	QuestionID         int    `json:"question_id,omitempty"`
+	AppliedQuestionIDs []int  `json:"applied_question_ids"`
+}
+
+func createWriteErrorResponse(statusCode int, message string, questionID int, applied []int) (events.APIGatewayProxyResponse, error) {
+	if applied == nil {
+		applied = []int{}
+	}
+	json, _ := json.Marshal(writeErrorBody{Message: message, QuestionID: questionID, AppliedQuestionIDs: applied})
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
+var appCfg appconfig.Config
+
 func main() {
-	lambda.Start(handler)
+	var err error
+	appCfg, err = appconfig.Load(appconfig.QuestionTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
 }
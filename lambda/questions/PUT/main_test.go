@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+	"shared/question"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestPlanWrites(t *testing.T) {
+	appCfg = appconfig.Config{DefaultLocale: "ja"}
+	newQuestion := func(id int, version *int) Question {
+		return Question{Content: question.Content{QuestionID: id, Statement: "料理は好きですか？", Statements: map[string]string{"ja": "料理は好きですか？"}, Category: "general", Tags: []string{}, Enabled: boolPtr(true), AudienceRating: "all"}, Version: version}
+	}
+
+	tests := []struct {
+		name           string
+		req            requestBody
+		wantErr        bool
+		wantConditions []string
+	}{
+		{
+			name: "create, legacy and versioned replace",
+			req:  requestBody{Questions: []Question{newQuestion(1, nil), newQuestion(2, intPtr(0)), newQuestion(3, intPtr(4))}},
+			wantConditions: []string{
+				"attribute_not_exists(question_id)",
+				"attribute_not_exists(#version) AND attribute_not_exists(deleted_at)",
+				"#version = :expected AND attribute_not_exists(deleted_at)",
+			},
+		},
+		{
+			name: "translations for one question become one write",
+			req: requestBody{Translations: []Translation{
+				{QuestionID: 5, Locale: "en", Statement: "Do you like cooking?"},
+				{QuestionID: 5, Locale: "ja", Statement: "料理は好き？"},
+			}},
+			wantConditions: []string{"attribute_exists(question_id) AND attribute_not_exists(deleted_at)"},
+		},
+		{
+			name:    "repeated question",
+			req:     requestBody{Questions: []Question{newQuestion(1, nil), newQuestion(1, intPtr(1))}},
+			wantErr: true,
+		},
+		{
+			name: "repeated translation",
+			req: requestBody{Translations: []Translation{
+				{QuestionID: 5, Locale: "en", Statement: "a"},
+				{QuestionID: 5, Locale: "en", Statement: "b"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "translation for a replaced question",
+			req: requestBody{
+				Questions:    []Question{newQuestion(1, intPtr(2))},
+				Translations: []Translation{{QuestionID: 1, Locale: "en", Statement: "a"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writes, err := planWrites("questions", tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planWrites error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(writes) != len(tt.wantConditions) {
+				t.Fatalf("got %d writes, want %d", len(writes), len(tt.wantConditions))
+			}
+			for i, w := range writes {
+				if got := aws.ToString(w.item.Update.ConditionExpression); got != tt.wantConditions[i] {
+					t.Errorf("write %d condition = %q, want %q", i, got, tt.wantConditions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTranslationWriteSetsDefaultStatement(t *testing.T) {
+	appCfg = appconfig.Config{DefaultLocale: "ja"}
+	w := translationWrite("questions", 5, []Translation{
+		{QuestionID: 5, Locale: "en", Statement: "Do you like cooking?"},
+		{QuestionID: 5, Locale: "ja", Statement: "料理は好き？"},
+	})
+	update := aws.ToString(w.item.Update.UpdateExpression)
+	if !strings.Contains(update, "statements.#locale0 = :statement0") || !strings.Contains(update, "statements.#locale1 = :statement1") {
+		t.Errorf("update = %q, want both locales", update)
+	}
+	if !strings.Contains(update, "statement = :statement1") || strings.Contains(update, "statement = :statement0") {
+		t.Errorf("update = %q, want statement from the default locale only", update)
+	}
+}
+
+func TestDescribeFailure(t *testing.T) {
+	existing := map[string]types.AttributeValue{
+		"question_id": &types.AttributeValueMemberN{Value: "1"},
+		"version":     &types.AttributeValueMemberN{Value: "3"},
+	}
+	deleted := map[string]types.AttributeValue{
+		"question_id": &types.AttributeValueMemberN{Value: "1"},
+		"version":     &types.AttributeValueMemberN{Value: "4"},
+		"deleted_at":  &types.AttributeValueMemberN{Value: "1700000000"},
+	}
+	tests := []struct {
+		name       string
+		w          write
+		item       map[string]types.AttributeValue
+		wantStatus int
+	}{
+		{name: "create over an existing question", w: write{questionID: 1}, item: existing, wantStatus: http.StatusConflict},
+		{name: "replace at an old version", w: write{questionID: 1, version: intPtr(2)}, item: existing, wantStatus: http.StatusPreconditionFailed},
+		{name: "replace a missing question", w: write{questionID: 1, version: intPtr(2)}, wantStatus: http.StatusPreconditionFailed},
+		{name: "replace a deleted question", w: write{questionID: 1, version: intPtr(4)}, item: deleted, wantStatus: http.StatusGone},
+		{name: "create over a deleted question", w: write{questionID: 1}, item: deleted, wantStatus: http.StatusGone},
+		{name: "translate a missing question", w: write{questionID: 1, translation: true}, wantStatus: http.StatusBadRequest},
+		{name: "translate a deleted question", w: write{questionID: 1, translation: true}, item: deleted, wantStatus: http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, message := describeFailure(tt.w, tt.item); status != tt.wantStatus {
+				t.Errorf("status = %d (%s), want %d", status, message, tt.wantStatus)
+			}
+		})
+	}
+}
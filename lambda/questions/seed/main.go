@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
+	"log"
 
 	"github.com/aws/aws-lambda-go/cfn"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -11,46 +11,53 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"shared/appconfig"
 )
 
 type QuestionItem struct {
-	QuestionID int    `json:"question_id" dynamodbav:"question_id"`
-	Statement  string `json:"statement" dynamodbav:"statement"`
+	QuestionID     int               `json:"question_id" dynamodbav:"question_id"`
+	Statement      string            `json:"statement" dynamodbav:"statement"`
+	Statements     map[string]string `json:"statements" dynamodbav:"statements"`
+	Category       string            `json:"category" dynamodbav:"category"`
+	Tags           []string          `json:"tags" dynamodbav:"tags"`
+	Enabled        bool              `json:"enabled" dynamodbav:"enabled"`
+	AudienceRating string            `json:"audience_rating" dynamodbav:"audience_rating"`
+	Version        int               `json:"version" dynamodbav:"version"`
 }
 
 func InsertData(ctx context.Context, event cfn.Event) (string, map[string]interface{}, error) {
 	sess := session.Must(session.NewSession())
 	svc := dynamodb.New(sess)
 
-	tableName := os.Getenv("TABLE_NAME")
-	if tableName == "" {
-		return event.PhysicalResourceID, nil, fmt.Errorf("TABLE_NAME environment variable is not set")
-	}
+	tableName := appCfg.QuestionTableName
 
 	items := []QuestionItem{
-		{QuestionID: 1, Statement: "料理をすることは好きですか？"},
-		{QuestionID: 2, Statement: "読書は好きですか？"},
-		{QuestionID: 3, Statement: "映画鑑賞は好きですか？"},
-		{QuestionID: 4, Statement: "『ポケモン』は好きですか？"},
-		{QuestionID: 5, Statement: "ジョギングは好きですか？"},
-		{QuestionID: 6, Statement: "カラオケは好きですか？"},
-		{QuestionID: 7, Statement: "コンサートに行くことは好きですか？"},
-		{QuestionID: 8, Statement: "ボードゲームは好きですか？"},
-		{QuestionID: 9, Statement: "アニメを見ることは好きですか？"},
-		{QuestionID: 10, Statement: "筋トレは好きですか？"},
-		{QuestionID: 11, Statement: "手芸や工作は好きですか？"},
-		{QuestionID: 12, Statement: "キャンプは好きですか？"},
-		{QuestionID: 13, Statement: "海外旅行は好きですか？"},
-		{QuestionID: 14, Statement: "幼少期にスポーツチームに所属していましたか？"},
-		{QuestionID: 15, Statement: "歴史の授業が好きでしたか？"},
-		{QuestionID: 16, Statement: "学校の科学の授業が得意でしたか？"},
-		{QuestionID: 17, Statement: "スパイシーな食べ物が好きですか？"},
-		{QuestionID: 18, Statement: "過去に100冊以上の本を読んだことがありますか？"},
-		{QuestionID: 19, Statement: "ペットを飼ったことがありますか？"},
-		{QuestionID: 20, Statement: "世の中の動向を追っていますか"},
+		{QuestionID: 1, Statement: "料理をすることは好きですか？", Statements: map[string]string{"ja": "料理をすることは好きですか？", "en": "Do you like cooking?"}, Category: "hobby", Tags: []string{"indoor", "food"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 2, Statement: "読書は好きですか？", Statements: map[string]string{"ja": "読書は好きですか？", "en": "Do you like reading?"}, Category: "hobby", Tags: []string{"indoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 3, Statement: "映画鑑賞は好きですか？", Statements: map[string]string{"ja": "映画鑑賞は好きですか？", "en": "Do you like watching movies?"}, Category: "hobby", Tags: []string{"indoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 4, Statement: "『ポケモン』は好きですか？", Statements: map[string]string{"ja": "『ポケモン』は好きですか？", "en": "Do you like Pokémon?"}, Category: "hobby", Tags: []string{"game"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 5, Statement: "ジョギングは好きですか？", Statements: map[string]string{"ja": "ジョギングは好きですか？", "en": "Do you like jogging?"}, Category: "hobby", Tags: []string{"outdoor", "sports"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 6, Statement: "カラオケは好きですか？", Statements: map[string]string{"ja": "カラオケは好きですか？", "en": "Do you like karaoke?"}, Category: "hobby", Tags: []string{"music"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 7, Statement: "コンサートに行くことは好きですか？", Statements: map[string]string{"ja": "コンサートに行くことは好きですか？", "en": "Do you like going to concerts?"}, Category: "hobby", Tags: []string{"music", "outdoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 8, Statement: "ボードゲームは好きですか？", Statements: map[string]string{"ja": "ボードゲームは好きですか？", "en": "Do you like board games?"}, Category: "hobby", Tags: []string{"game", "indoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 9, Statement: "アニメを見ることは好きですか？", Statements: map[string]string{"ja": "アニメを見ることは好きですか？", "en": "Do you like watching anime?"}, Category: "hobby", Tags: []string{"indoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 10, Statement: "筋トレは好きですか？", Statements: map[string]string{"ja": "筋トレは好きですか？", "en": "Do you like working out?"}, Category: "hobby", Tags: []string{"sports"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 11, Statement: "手芸や工作は好きですか？", Statements: map[string]string{"ja": "手芸や工作は好きですか？", "en": "Do you like handicrafts or DIY?"}, Category: "hobby", Tags: []string{"indoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 12, Statement: "キャンプは好きですか？", Statements: map[string]string{"ja": "キャンプは好きですか？", "en": "Do you like camping?"}, Category: "hobby", Tags: []string{"outdoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 13, Statement: "海外旅行は好きですか？", Statements: map[string]string{"ja": "海外旅行は好きですか？", "en": "Do you like traveling abroad?"}, Category: "hobby", Tags: []string{"travel"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 14, Statement: "幼少期にスポーツチームに所属していましたか？", Statements: map[string]string{"ja": "幼少期にスポーツチームに所属していましたか？", "en": "Were you on a sports team as a child?"}, Category: "school", Tags: []string{"sports"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 15, Statement: "歴史の授業が好きでしたか？", Statements: map[string]string{"ja": "歴史の授業が好きでしたか？", "en": "Did you like history class?"}, Category: "school", Tags: []string{"study"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 16, Statement: "学校の科学の授業が得意でしたか？", Statements: map[string]string{"ja": "学校の科学の授業が得意でしたか？", "en": "Were you good at science in school?"}, Category: "school", Tags: []string{"study"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 17, Statement: "スパイシーな食べ物が好きですか？", Statements: map[string]string{"ja": "スパイシーな食べ物が好きですか？", "en": "Do you like spicy food?"}, Category: "food", Tags: []string{}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 18, Statement: "過去に100冊以上の本を読んだことがありますか？", Statements: map[string]string{"ja": "過去に100冊以上の本を読んだことがありますか？", "en": "Have you read more than 100 books?"}, Category: "hobby", Tags: []string{"indoor"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 19, Statement: "ペットを飼ったことがありますか？", Statements: map[string]string{"ja": "ペットを飼ったことがありますか？", "en": "Have you ever had a pet?"}, Category: "lifestyle", Tags: []string{"animal"}, Enabled: true, AudienceRating: "all"},
+		{QuestionID: 20, Statement: "世の中の動向を追っていますか", Statements: map[string]string{"ja": "世の中の動向を追っていますか", "en": "Do you keep up with current events?"}, Category: "lifestyle", Tags: []string{"news"}, Enabled: true, AudienceRating: "all"},
 	}
 
 	for _, item := range items {
+		// 編集の競合を検出するための版。編集や削除のたびに1つ増える
+		item.Version = 1
 		av, err := dynamodbattribute.MarshalMap(item)
 		if err != nil {
 			return event.PhysicalResourceID, nil, fmt.Errorf("failed to marshal item: %v", err)
@@ -71,6 +78,13 @@ func InsertData(ctx context.Context, event cfn.Event) (string, map[string]interf
 	return event.PhysicalResourceID, nil, nil
 }
 
+var appCfg appconfig.Config
+
 func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.QuestionTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
 	lambda.Start(cfn.LambdaWrap(InsertData))
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+	"shared/question"
+)
+
+// 質問を論理削除する。項目は残すので、削除前に固定された質問を参照するルームも壊れない
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	questionID, ok := questionIDParameter(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeQuestionsWrite); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
+	q, found, err := question.Get(ctx, svc, appCfg, questionID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "question not found")
+	}
+	if q.DeletedAt != 0 {
+		return createErrorResponseWithStatus(http.StatusGone, "question has been deleted")
+	}
+	// If-Match は任意。付いていれば見ていた版のときだけ削除する
+	expected := q.Version
+	if ifMatch := apigw.RequestHeader(event, "If-Match"); ifMatch != "" {
+		if expected, err = question.ParseIfMatch(ifMatch, q.Version); errors.Is(err, question.ErrWeakETag) {
+			return createErrorResponseWithStatus(http.StatusPreconditionFailed, err.Error())
+		} else if err != nil {
+			return createErrorResponseWithStatus(http.StatusBadRequest, err.Error())
+		}
+		if expected != q.Version {
+			return createPreconditionFailedResponse(q.Version)
+		}
+	}
+
+	values := map[string]types.AttributeValue{
+		":now":  &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		":next": &types.AttributeValueMemberN{Value: strconv.Itoa(expected + 1)},
+	}
+	if expected != 0 {
+		values[":expected"] = &types.AttributeValueMemberN{Value: strconv.Itoa(expected)}
+	}
+	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(appCfg.QuestionTableName),
+		Key: map[string]types.AttributeValue{
+			"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(questionID)},
+		},
+		UpdateExpression:                    aws.String("SET deleted_at = :now, #version = :next"),
+		ConditionExpression:                 aws.String("attribute_exists(question_id) AND attribute_not_exists(deleted_at) AND " + question.VersionCondition(expected)),
+		ExpressionAttributeNames:            map[string]string{"#version": "version"},
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return createConflictResponse(failed.Item)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+	fmt.Printf("INFO:question %d deleted\n", questionID)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+func questionIDParameter(event events.APIGatewayProxyRequest) (int, bool) {
+	id, err := strconv.Atoi(event.PathParameters["question_id"])
+	return id, err == nil && id > 0
+}
+
+// 書き込み直前に変わった質問の今の状態に応じて返す
+func createConflictResponse(item map[string]types.AttributeValue) (events.APIGatewayProxyResponse, error) {
+	if item == nil {
+		return createErrorResponseWithStatus(http.StatusNotFound, "question not found")
+	}
+	var current question.Versioned
+	if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	if current.DeletedAt != 0 {
+		return createErrorResponseWithStatus(http.StatusGone, "question has been deleted")
+	}
+	return createPreconditionFailedResponse(current.Version)
+}
+
+func createPreconditionFailedResponse(current int) (events.APIGatewayProxyResponse, error) {
+	resp, err := createErrorResponseWithStatus(http.StatusPreconditionFailed, "question has been modified; fetch it again and retry")
+	resp.Headers["ETag"] = question.ETag(current)
+	resp.Headers["Access-Control-Expose-Headers"] = "ETag"
+	return resp, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.QuestionTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/question"
+)
+
+// 質問を1件、全ての言語の文面と版つきで返す。無効な質問も編集のために返す
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	questionID, ok := questionIDParameter(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	q, found, err := question.Get(ctx, dynamodb.NewFromConfig(cfg), appCfg, questionID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "question not found")
+	}
+	if q.DeletedAt != 0 {
+		return createErrorResponseWithStatus(http.StatusGone, "question has been deleted")
+	}
+	return createQuestionResponse(http.StatusOK, q)
+}
+
+func questionIDParameter(event events.APIGatewayProxyRequest) (int, bool) {
+	id, err := strconv.Atoi(event.PathParameters["question_id"])
+	return id, err == nil && id > 0
+}
+
+func createQuestionResponse(statusCode int, q question.Versioned) (events.APIGatewayProxyResponse, error) {
+	if q.Tags == nil {
+		q.Tags = []string{}
+	}
+	jsonResponse, err := json.Marshal(q)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       string(jsonResponse),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"ETag":         question.ETag(q.Version),
+			// ブラウザから ETag を読めるようにする
+			"Access-Control-Expose-Headers": "ETag",
+		},
+	}, nil
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.QuestionTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/apikey"
+	"shared/appconfig"
+	"shared/question"
+)
+
+// 指定された項目だけを書き換える。statements は言語ごとに既存の文面へ重ねる
+type patchRequest struct {
+	Statement      *string           `json:"statement"`
+	Statements     map[string]string `json:"statements"`
+	Category       *string           `json:"category"`
+	Tags           *[]string         `json:"tags"`
+	Enabled        *bool             `json:"enabled"`
+	AudienceRating *string           `json:"audience_rating"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	questionID, ok := questionIDParameter(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	ifMatch := apigw.RequestHeader(event, "If-Match")
+	if ifMatch == "" {
+		return createErrorResponseWithStatus(http.StatusPreconditionRequired, "If-Match with the question's ETag is required")
+	}
+	var req patchRequest
+	decoder := json.NewDecoder(strings.NewReader(event.Body))
+	// question_id や version の書き換えを黙って無視しない
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect request body")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	if status, message := apikey.Check(ctx, svc, appCfg.APIKeyTableName, event, apikey.ScopeQuestionsWrite); status != http.StatusOK {
+		return createErrorResponseWithStatus(status, message)
+	}
+
+	q, found, err := question.Get(ctx, svc, appCfg, questionID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "question not found")
+	}
+	if q.DeletedAt != 0 {
+		return createErrorResponseWithStatus(http.StatusGone, "question has been deleted")
+	}
+	expected, err := question.ParseIfMatch(ifMatch, q.Version)
+	if errors.Is(err, question.ErrWeakETag) {
+		return createErrorResponseWithStatus(http.StatusPreconditionFailed, err.Error())
+	}
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusBadRequest, err.Error())
+	}
+	if expected != q.Version {
+		return createPreconditionFailedResponse(q.Version)
+	}
+
+	applyPatch(&q, req)
+	if err := q.Normalize(appCfg.DefaultLocale); err != nil {
+		return createErrorResponseWithStatus(http.StatusBadRequest, err.Error())
+	}
+	q.Version = expected + 1
+	item, err := attributevalue.MarshalMap(q)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	var values map[string]types.AttributeValue
+	if expected != 0 {
+		values = map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.Itoa(expected)},
+		}
+	}
+	// 読んでから書くまでに他の管理者が書き換えていれば失敗させる
+	_, err = svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                           aws.String(appCfg.QuestionTableName),
+		Item:                                item,
+		ConditionExpression:                 aws.String("attribute_exists(question_id) AND attribute_not_exists(deleted_at) AND " + question.VersionCondition(expected)),
+		ExpressionAttributeNames:            map[string]string{"#version": "version"},
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return createConflictResponse(failed.Item)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+	fmt.Printf("INFO:question %d updated to version %d\n", q.QuestionID, q.Version)
+	return createQuestionResponse(http.StatusOK, q)
+}
+
+func applyPatch(q *question.Versioned, req patchRequest) {
+	if req.Statement != nil {
+		q.Statement = *req.Statement
+	}
+	statements := make(map[string]string, len(q.Statements)+len(req.Statements))
+	for locale, statement := range q.Statements {
+		statements[locale] = statement
+	}
+	for locale, statement := range req.Statements {
+		locale = strings.ToLower(locale)
+		statements[locale] = statement
+		// 既定のロケールの文面は statement と揃える
+		if locale == appCfg.DefaultLocale && req.Statement == nil {
+			q.Statement = statement
+		}
+	}
+	q.Statements = statements
+	if req.Category != nil {
+		q.Category = *req.Category
+	}
+	if req.Tags != nil {
+		q.Tags = *req.Tags
+	}
+	if req.Enabled != nil {
+		q.Enabled = req.Enabled
+	}
+	if req.AudienceRating != nil {
+		q.AudienceRating = *req.AudienceRating
+	}
+}
+
+func questionIDParameter(event events.APIGatewayProxyRequest) (int, bool) {
+	id, err := strconv.Atoi(event.PathParameters["question_id"])
+	return id, err == nil && id > 0
+}
+
+func createQuestionResponse(statusCode int, q question.Versioned) (events.APIGatewayProxyResponse, error) {
+	if q.Tags == nil {
+		q.Tags = []string{}
+	}
+	jsonResponse, err := json.Marshal(q)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       string(jsonResponse),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+			"ETag":         question.ETag(q.Version),
+			// ブラウザから ETag を読めるようにする
+			"Access-Control-Expose-Headers": "ETag",
+		},
+	}, nil
+}
+
+// 書き込み直前に変わった質問の今の状態に応じて返す
+func createConflictResponse(item map[string]types.AttributeValue) (events.APIGatewayProxyResponse, error) {
+	if item == nil {
+		return createErrorResponseWithStatus(http.StatusNotFound, "question not found")
+	}
+	var current question.Versioned
+	if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	if current.DeletedAt != 0 {
+		return createErrorResponseWithStatus(http.StatusGone, "question has been deleted")
+	}
+	return createPreconditionFailedResponse(current.Version)
+}
+
+func createPreconditionFailedResponse(current int) (events.APIGatewayProxyResponse, error) {
+	resp, err := createErrorResponseWithStatus(http.StatusPreconditionFailed, "question has been modified; fetch it again and retry")
+	resp.Headers["ETag"] = question.ETag(current)
+	resp.Headers["Access-Control-Expose-Headers"] = "ETag"
+	return resp, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.QuestionTable, appconfig.APIKeyTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -3,21 +3,44 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+	"shared/passcode"
+	"shared/question"
 )
 
 type requestBody struct {
 	RoomId string `json:"room_id"`
+	// 招待のプレビューに表示する名前。省略すると room_id を使う
+	Name     string             `json:"name,omitempty"`
+	PackId   string             `json:"pack_id,omitempty"`
+	Settings *game.RoomSettings `json:"settings,omitempty"`
+	// 指定するとプライベートルームになり、参加と観戦に合言葉が要る
+	Passcode string `json:"passcode,omitempty"`
+}
+
+type responseBody struct {
+	RoomId      string            `json:"room_id"`
+	Name        string            `json:"name,omitempty"`
+	PackId      string            `json:"pack_id,omitempty"`
+	Settings    game.RoomSettings `json:"settings"`
+	QuestionIds []int             `json:"question_ids"`
+	Private     bool              `json:"private"`
 }
 
 func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -26,11 +49,6 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 	if err != nil {
 		return createEmptyResponseWithStatus(http.StatusInternalServerError), err
 	}
-	roomTableName := "CandleBackendRoomTable"
-	if t, exists := os.LookupEnv("ROOM_TABLE_NAME"); exists {
-		roomTableName = t
-	}
-
 	if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
 		return createEmptyResponseWithStatus(http.StatusInternalServerError), err
 	}
@@ -38,12 +56,106 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 		fmt.Println("INFO:room_id is empty")
 		return createEmptyResponseWithStatus(http.StatusBadRequest), nil
 	}
-	exists := createRoom(cfg, ctx, req.RoomId, roomTableName)
+	settings := game.RoomSettings{QuestionCount: appCfg.Game.QuestionCount, MinPlayers: appCfg.Game.MinPlayers, MaxPlayers: appCfg.Game.MaxPlayers, Rounds: appCfg.Game.Rounds, Language: appCfg.DefaultLocale}
+	if req.Settings != nil {
+		if req.Settings.Rounds < 0 || req.Settings.Rounds > maxRounds {
+			fmt.Printf("INFO:invalid rounds %v\n", req.Settings.Rounds)
+			return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+		}
+		if req.Settings.Rounds > 0 {
+			settings.Rounds = req.Settings.Rounds
+		}
+		// サンタ選びが成り立つ人数より少なくはできない
+		if req.Settings.MinPlayers < 0 || (req.Settings.MinPlayers > 0 && req.Settings.MinPlayers < appCfg.Game.MinPlayers) {
+			fmt.Printf("INFO:invalid min_players %v\n", req.Settings.MinPlayers)
+			return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+		}
+		if req.Settings.MinPlayers > 0 {
+			settings.MinPlayers = req.Settings.MinPlayers
+		}
+		settings.AutoStart = req.Settings.AutoStart
+		if req.Settings.QuestionCount < 0 || req.Settings.QuestionCount > maxQuestionCount {
+			fmt.Printf("INFO:invalid question_count %v\n", req.Settings.QuestionCount)
+			return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+		}
+		if req.Settings.QuestionCount > 0 {
+			settings.QuestionCount = req.Settings.QuestionCount
+		}
+		if req.Settings.MaxPlayers < 0 || req.Settings.MaxPlayers > appCfg.Game.MaxPlayers {
+			fmt.Printf("INFO:invalid max_players %v\n", req.Settings.MaxPlayers)
+			return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+		}
+		if req.Settings.MaxPlayers > 0 {
+			settings.MaxPlayers = req.Settings.MaxPlayers
+		}
+		settings.Public = req.Settings.Public
+		if req.Settings.Language != "" {
+			settings.Language = strings.ToLower(req.Settings.Language)
+		}
+	}
+	// 開始に必要な人数より少ない定員にはできない
+	if settings.MaxPlayers < settings.MinPlayers {
+		fmt.Printf("INFO:max_players %v is less than min_players %v\n", settings.MaxPlayers, settings.MinPlayers)
+		return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+	}
+	if !validLanguage(settings.Language) {
+		fmt.Printf("INFO:invalid language %q\n", settings.Language)
+		return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if utf8.RuneCountInString(req.Name) > maxRoomNameLength {
+		fmt.Printf("INFO:room name is too long\n")
+		return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+	}
+	if req.Passcode != "" {
+		// 公開ルームは誰でも入れるので合言葉は付けられない
+		if settings.Public {
+			fmt.Println("INFO:public rooms cannot have a passcode")
+			return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+		}
+		if err := passcode.Validate(req.Passcode); err != nil {
+			fmt.Printf("INFO:%v\n", err)
+			return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+		}
+	}
 
-	if exists {
+	svc := dynamodb.NewFromConfig(cfg)
+	questions, err := question.Draw(ctx, svc, appCfg, req.PackId, settings.QuestionCount)
+	if errors.Is(err, question.ErrPackNotFound) {
+		fmt.Printf("INFO:pack %v not found\n", req.PackId)
+		return createEmptyResponseWithStatus(http.StatusBadRequest), nil
+	}
+	if err != nil {
+		return createEmptyResponseWithStatus(http.StatusInternalServerError), err
+	}
+
+	room := game.NewRoomItem(appCfg, req.RoomId, settings, questions, time.Now())
+	room.Name = req.Name
+	room.PackID = req.PackId
+	if req.Passcode != "" {
+		if room.PasscodeHash, err = passcode.Hash(req.Passcode); err != nil {
+			return createEmptyResponseWithStatus(http.StatusInternalServerError), err
+		}
+	}
+	err = game.CreateRoom(ctx, svc, appCfg, room)
+	var exists *types.ConditionalCheckFailedException
+	if errors.As(err, &exists) {
 		return createEmptyResponseWithStatus(http.StatusConflict), nil
 	}
-	responseBody, err := json.Marshal(req)
+	if err != nil {
+		return createEmptyResponseWithStatus(http.StatusInternalServerError), err
+	}
+	resp := responseBody{
+		RoomId:   room.RoomID,
+		Name:     room.Name,
+		PackId:   room.PackID,
+		Settings: room.Settings,
+		Private:  room.PasscodeHash != "",
+	}
+	for _, q := range room.Questions {
+		resp.QuestionIds = append(resp.QuestionIds, q.QuestionID)
+	}
+	responseBody, err := json.Marshal(resp)
 
 	if err != nil {
 		return createEmptyResponseWithStatus(http.StatusInternalServerError), err
@@ -51,33 +163,46 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 	return events.APIGatewayProxyResponse{
 		Body:       string(responseBody),
 		StatusCode: http.StatusCreated,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
+// "ja" や "pt-br" のような言語タグ
+func validLanguage(lang string) bool {
+	if len(lang) < 2 || len(lang) > 16 {
+		return false
+	}
+	for _, r := range lang {
+		if (r < 'a' || r > 'z') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+const maxRoomNameLength = 40
+
+var appCfg appconfig.Config
+
 func main() {
-	lambda.Start(handler)
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.QuestionTable, appconfig.PackTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
 }
 
 func createEmptyResponseWithStatus(statuCode int) events.APIGatewayProxyResponse {
 	return events.APIGatewayProxyResponse{
 		Body:       "",
 		StatusCode: statuCode,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}
 }
 
-func createRoom(cfg aws.Config, ctx context.Context, roomId, roomTableName string) bool {
-	svc := dynamodb.NewFromConfig(cfg)
-	ttl := time.Now().Add(12 * time.Hour).Unix()
-	_, err := svc.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(roomTableName),
-		Item: map[string]types.AttributeValue{
-			"room_id":      &types.AttributeValueMemberS{Value: roomId},
-			"participants": &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
-			"TTL":          &types.AttributeValueMemberN{Value: fmt.Sprintf("%v", ttl)},
-		},
-		ConditionExpression: aws.String("attribute_not_exists(room_id)"),
-	})
-	return err != nil
-}
+// 1ルームで出題できる質問数の上限
+const maxQuestionCount = 100
+
+// 1ルームで遊べるラウンド数の上限
+const maxRounds = 10
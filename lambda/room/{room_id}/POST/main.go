@@ -2,36 +2,77 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+	"shared/nickname"
+	"shared/passcode"
 )
 
-type Answer struct {
-	QuestionID int  `json:"question_id" dynamodbav:"question_id"`
-	Answer     bool `json:"answer" dynamodbav:"answer"`
-}
 type UserData struct {
-	UserID   string   `json:"user_id" dynamodbav:"user_id"`
-	NickName string   `json:"nickname" dynamodbav:"nickname"`
-	RoomID   string   `json:"room_id" dynamodbav:"room_id"`
-	Answers  []Answer `json:"answers" dynamodbav:"answers"`
-	IsSanta  bool     `json:"is_santa" dynamodbav:"is_santa"`
+	UserID   string        `json:"user_id" dynamodbav:"user_id"`
+	NickName string        `json:"nickname" dynamodbav:"nickname"`
+	RoomID   string        `json:"room_id" dynamodbav:"room_id"`
+	Answers  []game.Answer `json:"answers" dynamodbav:"answers"`
+	IsSanta  bool          `json:"is_santa" dynamodbav:"is_santa"`
+	// 本人確認用のトークン。DB にはハッシュだけを保存し、平文は参加時のレスポンスでのみ返す
+	SessionToken     string `json:"session_token,omitempty" dynamodbav:"-"`
+	SessionTokenHash string `json:"-" dynamodbav:"session_token_hash"`
+	// 再接続に使う端末 ID のハッシュ
+	DeviceHash string `json:"-" dynamodbav:"device_hash,omitempty"`
+}
+
+// 参加を受け付けるのはロビーの間だけ
+const roomStatusLobby = "lobby"
+
+type RoomData struct {
+	RoomID string `json:"room_id" dynamodbav:"room_id"`
+	// status が無い古いルームはロビーとして扱う
+	Status       string              `json:"status" dynamodbav:"status"`
+	Participants []string            `json:"participants" dynamodbav:"participants"`
+	Questions    []game.RoomQuestion `json:"questions" dynamodbav:"questions"`
+	Settings     struct {
+		MaxPlayers int `json:"max_players" dynamodbav:"max_players"`
+	} `json:"settings" dynamodbav:"settings"`
+	// 端末 ID のハッシュから user_id を引く。同じ端末から二重に参加させない
+	Devices map[string]string `json:"devices" dynamodbav:"devices"`
+	// 正規化したニックネームから user_id を引く。ルーム内でニックネームを重複させない
+	NickNames map[string]string `json:"nicknames" dynamodbav:"nicknames"`
+	Invites   map[string]Invite `json:"invites" dynamodbav:"invites"`
+	passcode.Lock
 }
 
 type requestBody struct {
-	NickName string   `json:"nickname"`
-	Answers  []Answer `json:"answers"`
+	NickName string        `json:"nickname"`
+	Answers  []game.Answer `json:"answers"`
+	// クライアントが端末に保存しておく ID。ページを再読み込みしたら POST /room/{room_id}/rejoin で使う
+	DeviceID string `json:"device_id"`
+	// プライベートルームの合言葉
+	Passcode string `json:"passcode"`
+	// 合言葉の代わりに使える招待トークン
+	InviteToken string `json:"invite_token"`
 }
 
 func enterRoomHandler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -41,15 +82,15 @@ func enterRoomHandler(ctx context.Context, event events.APIGatewayProxyRequest)
 	}
 	roomId, err := url.PathUnescape(roomId)
 	if err != nil {
-		createEmptyResponseWithStatus(500, "could not decode room_id")
+		return createEmptyResponseWithStatus(500, "could not decode room_id")
 	}
 
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
-		createEmptyResponseWithStatus(500, "")
+		return createEmptyResponseWithStatus(500, "")
 	}
 	// room が存在するかの確認
-	ok, err := existRoom(ctx, cfg, roomId)
+	room, ok, err := getRoom(ctx, cfg, roomId)
 	if err != nil {
 		return createEmptyResponseWithStatus(500, "Could not get the room")
 	}
@@ -62,33 +103,125 @@ func enterRoomHandler(ctx context.Context, event events.APIGatewayProxyRequest)
 		return createEmptyResponseWithStatus(500, "JSON parse error")
 	}
 
+	// 招待トークンがあれば合言葉の代わりに使い、参加と同時に利用回数を数える
+	var inviteHash string
+	result := passcode.OK
+	if req.InviteToken != "" {
+		tokenRoomID, secretHash, ok := parseInviteToken(req.InviteToken)
+		invite, found := room.Invites[secretHash]
+		if !ok || !found || tokenRoomID != roomId {
+			return createErrorResponseWithCode(http.StatusForbidden, game.ValidationError{Code: "invalid_invite", Message: "invalid invite token"})
+		}
+		if !invite.usable(time.Now()) {
+			return createInviteExpiredResponse()
+		}
+		inviteHash = secretHash
+	} else {
+		// 間違えた回数は接続元ごとに数え、ほかの参加者は締め出さない
+		result, err = passcode.Check(ctx, dynamodb.NewFromConfig(cfg), appCfg, roomId, event.RequestContext.Identity.SourceIP, room.Lock, req.Passcode, time.Now())
+		if err != nil {
+			return createEmptyResponseWithStatus(500, "DB write error")
+		}
+	}
+	switch result {
+	case passcode.Required:
+		return createErrorResponseWithCode(http.StatusUnauthorized, game.ValidationError{Code: "passcode_required", Message: "this room requires a passcode"})
+	case passcode.Invalid:
+		return createErrorResponseWithCode(http.StatusForbidden, game.ValidationError{Code: "invalid_passcode", Message: "invalid passcode"})
+	case passcode.Locked:
+		return createErrorResponseWithCode(http.StatusTooManyRequests, game.ValidationError{Code: "too_many_attempts", Message: "too many wrong passcodes; try again later"})
+	}
+
+	// 同じプレイヤーとしての二重参加は受け付けず、再接続を案内する
+	alreadyJoined, err := isAlreadyJoined(ctx, cfg, event, room, req.DeviceID)
+	if err != nil {
+		return createEmptyResponseWithStatus(500, "Could not get the room")
+	}
+	if alreadyJoined {
+		return createAlreadyJoinedResponse()
+	}
+	if !room.acceptsPlayers() {
+		return createGameStartedResponse()
+	}
+	if len(room.Participants) >= room.capacity() {
+		return createRoomFullResponse()
+	}
+
+	req.NickName, err = nickname.Normalize(req.NickName, appCfg.Game.NickNameMaxLength)
+	if err != nil {
+		return createValidationErrorResponse(game.ValidationError{Code: "invalid_nickname", Message: err.Error()})
+	}
+	if nickname.ContainsBlockedWord(req.NickName, appCfg.Game.NickNameBlocklist) {
+		return createValidationErrorResponse(game.ValidationError{Code: "nickname_blocked", Message: "nickname contains a blocked word"})
+	}
+	if _, taken := room.NickNames[nickname.Key(req.NickName)]; taken {
+		return createNickNameTakenResponse(req.NickName, room.NickNames)
+	}
+	// ルーム作成時に選ばれた質問にちょうど答えているか
+	if verr := game.ValidateAnswers(req.Answers, room.Questions); verr != nil {
+		return createValidationErrorResponse(*verr)
+	}
+
 	//リクエストボディにuser_idは含まれていないので新しい構造体を使ってデータ挿入
 	var userData UserData
 	userId := uuid.New()
 
 	userData.UserID = userId.String()
+	userData.SessionToken, userData.SessionTokenHash, err = apigw.NewSessionToken()
+	if err != nil {
+		return createEmptyResponseWithStatus(500, "could not issue session token")
+	}
 	userData.NickName = req.NickName
 	userData.Answers = req.Answers
 	userData.RoomID = roomId
+	if req.DeviceID != "" {
+		userData.DeviceHash = apigw.HashSecret(req.DeviceID)
+	}
 
 	// 書き込み処理
-	if err = insertUserDataToCandleBackendUserTable(cfg, ctx, userData); err != nil {
-		createEmptyResponseWithStatus(500, "Data write error.")
+	err = game.JoinRoom(ctx, dynamodb.NewFromConfig(cfg), appCfg, roomId, game.Joiner{
+		UserID:           userData.UserID,
+		NickName:         userData.NickName,
+		NickNameKey:      nickname.Key(userData.NickName),
+		Answers:          userData.Answers,
+		SessionTokenHash: userData.SessionTokenHash,
+		DeviceHash:       userData.DeviceHash,
+	}, game.JoinOptions{
+		Capacity:   room.capacity(),
+		CreateMaps: room.Devices == nil || room.NickNames == nil,
+		InviteHash: inviteHash,
+	}, time.Now())
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		// 同じニックネームか同じ端末で同時に別の参加があったか、満員になったか、招待を使い切った
+		current, _ := canceledRoom(canceled)
+		if !current.acceptsPlayers() {
+			return createGameStartedResponse()
+		}
+		if len(current.Participants) >= room.capacity() {
+			return createRoomFullResponse()
+		}
+		if _, taken := current.NickNames[nickname.Key(req.NickName)]; taken {
+			return createNickNameTakenResponse(req.NickName, current.NickNames)
+		}
+		if invite, ok := current.Invites[inviteHash]; inviteHash != "" && (!ok || !invite.usable(time.Now())) {
+			return createInviteExpiredResponse()
+		}
+		return createAlreadyJoinedResponse()
 	}
-
-	jsonUserData, err := json.Marshal(userData)
 	if err != nil {
-		createEmptyResponseWithStatus(500, "JSON parse error.")
+		return createEmptyResponseWithStatus(500, "Data write error.")
 	}
 
-	if err = insertUserIDToRoomTableParticipantsColumn(cfg, ctx, userData); err != nil {
-		createEmptyResponseWithStatus(500, "DB write error")
+	jsonUserData, err := json.Marshal(userData)
+	if err != nil {
+		return createEmptyResponseWithStatus(500, "JSON parse error.")
 	}
 
 	return events.APIGatewayProxyResponse{
 		Body:       string(jsonUserData),
 		StatusCode: http.StatusOK,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
@@ -96,111 +229,189 @@ func createEmptyResponseWithStatus(statusCode int, responseMessage string) (even
 	return events.APIGatewayProxyResponse{
 		Body:       responseMessage,
 		StatusCode: statusCode,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
-func insertUserDataToCandleBackendUserTable(cfg aws.Config, ctx context.Context, userData UserData) error {
-	svc := dynamodb.NewFromConfig(cfg)
-
-	tableName := "CandleBackendUserTable"
-	if t, exists := os.LookupEnv("USER_TABLE_NAME"); exists {
-		tableName = t
+// Authorization のセッショントークンか端末 ID が、既にルームにいるプレイヤーのものか
+func isAlreadyJoined(ctx context.Context, cfg aws.Config, event events.APIGatewayProxyRequest, room RoomData, deviceID string) (bool, error) {
+	if deviceID != "" {
+		if _, ok := room.Devices[apigw.HashSecret(deviceID)]; ok {
+			return true, nil
+		}
+	}
+	token, ok := strings.CutPrefix(apigw.RequestHeader(event, "Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !ok || token == "" {
+		return false, nil
 	}
 
-	var answers []types.AttributeValue
-	for _, ans := range userData.Answers {
-		ansMap := map[string]types.AttributeValue{
-			"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(ans.QuestionID)},
-			"answer":      &types.AttributeValueMemberBOOL{Value: ans.Answer},
+	svc := dynamodb.NewFromConfig(cfg)
+	tokenHash := apigw.HashSecret(token)
+	for _, participant := range room.Participants {
+		result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(appCfg.UserTableName),
+			Key: map[string]types.AttributeValue{
+				"user_id": &types.AttributeValueMemberS{Value: participant},
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		var user UserData
+		if err = attributevalue.UnmarshalMap(result.Item, &user); err != nil {
+			return false, err
+		}
+		if user.SessionTokenHash != "" && subtle.ConstantTimeCompare([]byte(user.SessionTokenHash), []byte(tokenHash)) == 1 {
+			return true, nil
 		}
-		answers = append(answers, &types.AttributeValueMemberM{Value: ansMap})
 	}
+	return false, nil
+}
 
-	params := &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item: map[string]types.AttributeValue{
-			"user_id":  &types.AttributeValueMemberS{Value: userData.UserID},
-			"nickname": &types.AttributeValueMemberS{Value: userData.NickName},
-			"room_id":  &types.AttributeValueMemberS{Value: userData.RoomID},
-			"answers":  &types.AttributeValueMemberL{Value: answers},
-		},
+// 末尾に番号を付けて、まだ使われていないニックネームを提案する
+func suggestNickName(name string, taken map[string]string) string {
+	for n := 2; ; n++ {
+		suffix := strconv.Itoa(n)
+		base := []rune(name)
+		if limit := appCfg.Game.NickNameMaxLength - len(suffix); len(base) > limit {
+			base = base[:max(limit, 0)]
+		}
+		candidate := string(base) + suffix
+		if _, ok := taken[nickname.Key(candidate)]; !ok {
+			return candidate
+		}
 	}
+}
 
-	_, err := svc.PutItem(ctx, params)
-	if err != nil {
-		return err
+// トランザクションが条件で失敗したときの、その時点のルーム
+func canceledRoom(canceled *types.TransactionCanceledException) (RoomData, bool) {
+	for _, reason := range canceled.CancellationReasons {
+		if reason.Item == nil {
+			continue
+		}
+		var room RoomData
+		if err := attributevalue.UnmarshalMap(reason.Item, &room); err == nil {
+			return room, true
+		}
 	}
-
-	return nil
+	return RoomData{}, false
 }
 
-func insertUserIDToRoomTableParticipantsColumn(cfg aws.Config, ctx context.Context, user UserData) error {
-	svc := dynamodb.NewFromConfig(cfg)
-
-	getItemOutput, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String("CandleBackendRoomTable"),
-		Key: map[string]types.AttributeValue{
-			"room_id": &types.AttributeValueMemberS{Value: user.RoomID},
-		},
+func createNickNameTakenResponse(name string, taken map[string]string) (events.APIGatewayProxyResponse, error) {
+	json, _ := json.Marshal(game.ValidationError{
+		Code:       "nickname_taken",
+		Message:    "nickname is already used in this room",
+		Suggestion: suggestNickName(name, taken),
 	})
-	if err != nil {
-		return err
-	}
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: http.StatusConflict,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
 
-	var participants []string
-	if attr, found := getItemOutput.Item["participants"]; found {
-		if attrList, ok := attr.(*types.AttributeValueMemberL); ok {
-			for _, v := range attrList.Value {
-				if s, ok := v.(*types.AttributeValueMemberS); ok {
-					participants = append(participants, s.Value)
-				}
-			}
-		}
+// 定員の設定がない古いルームは既定値を使う
+func (r RoomData) capacity() int {
+	if r.Settings.MaxPlayers > 0 {
+		return r.Settings.MaxPlayers
 	}
+	return appCfg.Game.MaxPlayers
+}
 
-	participants = append(participants, user.UserID)
+// status が無い古いルームも受け付ける
+func (r RoomData) acceptsPlayers() bool {
+	return r.Status == "" || r.Status == roomStatusLobby
+}
 
-	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String("CandleBackendRoomTable"),
-		Key: map[string]types.AttributeValue{
-			"room_id": &types.AttributeValueMemberS{Value: user.RoomID},
-		},
-		UpdateExpression: aws.String("SET participants = :participants"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":participants": &types.AttributeValueMemberL{
-				Value: buildAttributeValueList(participants),
-			},
-		},
-	})
-	if err != nil {
-		return err
-	}
+func createGameStartedResponse() (events.APIGatewayProxyResponse, error) {
+	return createErrorResponseWithCode(http.StatusConflict, game.ValidationError{Code: "game_started", Message: "the game has already started"})
+}
 
-	return nil
+func createRoomFullResponse() (events.APIGatewayProxyResponse, error) {
+	return createErrorResponseWithCode(http.StatusConflict, game.ValidationError{Code: "room_full", Message: "the room is full"})
 }
-func buildAttributeValueList(values []string) []types.AttributeValue {
-	var attributeList []types.AttributeValue
-	for _, value := range values {
-		attributeList = append(attributeList, &types.AttributeValueMemberS{Value: value})
-	}
-	return attributeList
+
+func createInviteExpiredResponse() (events.APIGatewayProxyResponse, error) {
+	return createErrorResponseWithCode(http.StatusGone, game.ValidationError{Code: "invite_expired", Message: "invite has expired or has been used up"})
+}
+
+func createAlreadyJoinedResponse() (events.APIGatewayProxyResponse, error) {
+	json, _ := json.Marshal(game.ValidationError{
+		Code:    "already_joined",
+		Message: "already joined this room; use POST /room/{room_id}/rejoin",
+	})
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: http.StatusConflict,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
 }
 
-func existRoom(ctx context.Context, cfg aws.Config, roomID string) (bool, error) {
+func getRoom(ctx context.Context, cfg aws.Config, roomID string) (RoomData, bool, error) {
 	svc := dynamodb.NewFromConfig(cfg)
 	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String("CandleBackendRoomTable"),
+		TableName: aws.String(appCfg.RoomTableName),
 		Key: map[string]types.AttributeValue{
 			"room_id": &types.AttributeValueMemberS{Value: roomID},
 		},
 	})
-	if result.Item["room_id"] == nil {
-		return false, err
+	if err != nil || result.Item["room_id"] == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+func createValidationErrorResponse(body game.ValidationError) (events.APIGatewayProxyResponse, error) {
+	return createErrorResponseWithCode(http.StatusUnprocessableEntity, body)
+}
+
+func createErrorResponseWithCode(statusCode int, body game.ValidationError) (events.APIGatewayProxyResponse, error) {
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// ルームに保存する招待。キーはトークンの秘密部分のハッシュ
+type Invite struct {
+	ExpiresAt int64 `json:"expires_at" dynamodbav:"expires_at"`
+	// 0 なら回数の制限なし
+	MaxUses   int    `json:"max_uses" dynamodbav:"max_uses"`
+	Uses      int    `json:"uses" dynamodbav:"uses"`
+	CreatedBy string `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt int64  `json:"created_at" dynamodbav:"created_at"`
+}
+
+func (i Invite) usable(now time.Time) bool {
+	return now.Unix() < i.ExpiresAt && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// 招待トークンは "<base64url(room_id)>.<秘密>"。ルームはトークンだけから引ける
+func parseInviteToken(token string) (roomID, secretHash string, ok bool) {
+	encoded, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(b) == 0 {
+		return "", "", false
 	}
-	return true, err
+	sum := sha256.Sum256([]byte(secret))
+	return string(b), hex.EncodeToString(sum[:]), true
 }
 
+var appCfg appconfig.Config
+
 func main() {
-	lambda.Start(enterRoomHandler)
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.PasscodeAttemptTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, enterRoomHandler))
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+type UserData struct {
+	UserID           string `json:"user_id" dynamodbav:"user_id"`
+	RoomID           string `json:"room_id" dynamodbav:"room_id"`
+	SessionTokenHash string `json:"-" dynamodbav:"session_token_hash"`
+}
+
+type RoomData struct {
+	RoomID       string            `json:"room_id" dynamodbav:"room_id"`
+	Status       string            `json:"status" dynamodbav:"status"`
+	HostID       string            `json:"host_id" dynamodbav:"host_id"`
+	Participants []string          `json:"participants" dynamodbav:"participants"`
+	Invites      map[string]Invite `json:"invites" dynamodbav:"invites"`
+	TTL          int64             `json:"-" dynamodbav:"TTL"`
+}
+
+type requestBody struct {
+	// 有効期限 (秒)。省略すると INVITE_TTL
+	ExpiresIn int `json:"expires_in"`
+	MaxUses   int `json:"max_uses"`
+}
+
+type responseBody struct {
+	InviteToken string `json:"invite_token"`
+	RoomID      string `json:"room_id"`
+	ExpiresAt   int64  `json:"expires_at"`
+	MaxUses     int    `json:"max_uses"`
+}
+
+const (
+	roomStatusFinished = "finished"
+	// 1ルームあたりの招待の上限
+	maxInvites = 20
+)
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	var req requestBody
+	if event.Body != "" {
+		if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
+			return createErrorResponseWithStatus(http.StatusBadRequest, "JSON parse error")
+		}
+	}
+	if req.ExpiresIn < 0 || req.MaxUses < 0 {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "expires_in and max_uses must not be negative")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+
+	// ホストのいない古いルームでは参加者なら誰でも招待できる
+	token, ok := apigw.BearerToken(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "missing session token")
+	}
+	var creator string
+	for _, participant := range room.Participants {
+		if room.HostID != "" && participant != room.HostID {
+			continue
+		}
+		user, found, err := getUser(ctx, svc, participant)
+		if err != nil {
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+		}
+		if found && user.RoomID == roomID && apigw.MatchSessionToken(token, user.SessionTokenHash) {
+			creator = user.UserID
+			break
+		}
+	}
+	if creator == "" {
+		return createErrorResponseWithStatus(http.StatusForbidden, "only the host can create invites")
+	}
+	if room.Status == roomStatusFinished {
+		return createErrorResponseWithStatus(http.StatusConflict, "the game has already finished")
+	}
+	if len(room.Invites) >= maxInvites {
+		return createErrorResponseWithStatus(http.StatusConflict, "too many invites")
+	}
+
+	now := time.Now()
+	ttl := appCfg.InviteTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	invite := Invite{
+		ExpiresAt: now.Add(ttl).Unix(),
+		MaxUses:   req.MaxUses,
+		CreatedBy: creator,
+		CreatedAt: now.Unix(),
+	}
+	// ルームより長生きする招待は作らない
+	if room.TTL > 0 && invite.ExpiresAt > room.TTL {
+		invite.ExpiresAt = room.TTL
+	}
+
+	inviteToken, secretHash, err := newInviteToken(roomID)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "could not issue invite token")
+	}
+	err = addInvite(ctx, svc, roomID, secretHash, invite)
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return createErrorResponseWithStatus(http.StatusConflict, "too many invites")
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	jsonResponse, err := json.Marshal(responseBody{InviteToken: inviteToken, RoomID: roomID, ExpiresAt: invite.ExpiresAt, MaxUses: invite.MaxUses})
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// 招待トークンを発行し、トークンと秘密部分のハッシュを返す
+func newInviteToken(roomID string) (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString([]byte(roomID)) + "." + base64.RawURLEncoding.EncodeToString(b)
+	_, secretHash, _ := parseInviteToken(token)
+	return token, secretHash, nil
+}
+
+// invites を持たないルームでは先に空のマップを作る
+func addInvite(ctx context.Context, svc *dynamodb.Client, roomID, secretHash string, invite Invite) error {
+	key := map[string]types.AttributeValue{
+		"room_id": &types.AttributeValueMemberS{Value: roomID},
+	}
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(appCfg.RoomTableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET invites = if_not_exists(invites, :empty)"),
+		ConditionExpression: aws.String("attribute_exists(room_id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	av, err := attributevalue.Marshal(invite)
+	if err != nil {
+		return err
+	}
+	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(appCfg.RoomTableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET invites.#invite = :invite"),
+		ConditionExpression: aws.String("size(invites) < :max"),
+		ExpressionAttributeNames: map[string]string{
+			"#invite": secretHash,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":invite": av,
+			":max":    &types.AttributeValueMemberN{Value: strconv.Itoa(maxInvites)},
+		},
+	})
+	return err
+}
+
+// ルームに保存する招待。キーはトークンの秘密部分のハッシュ
+type Invite struct {
+	ExpiresAt int64 `json:"expires_at" dynamodbav:"expires_at"`
+	// 0 なら回数の制限なし
+	MaxUses   int    `json:"max_uses" dynamodbav:"max_uses"`
+	Uses      int    `json:"uses" dynamodbav:"uses"`
+	CreatedBy string `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt int64  `json:"created_at" dynamodbav:"created_at"`
+}
+
+func (i Invite) usable(now time.Time) bool {
+	return now.Unix() < i.ExpiresAt && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// 招待トークンは "<base64url(room_id)>.<秘密>"。ルームはトークンだけから引ける
+func parseInviteToken(token string) (roomID, secretHash string, ok bool) {
+	encoded, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(b) == 0 {
+		return "", "", false
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return string(b), hex.EncodeToString(sum[:]), true
+}
+
+func getUser(ctx context.Context, svc *dynamodb.Client, userID string) (UserData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
+	}
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+)
+
+type UserData struct {
+	UserID           string `json:"user_id" dynamodbav:"user_id"`
+	RoomID           string `json:"room_id" dynamodbav:"room_id"`
+	SessionTokenHash string `json:"-" dynamodbav:"session_token_hash"`
+}
+
+type RoomData struct {
+	RoomID       string   `json:"room_id" dynamodbav:"room_id"`
+	HostID       string   `json:"host_id" dynamodbav:"host_id"`
+	Participants []string `json:"participants" dynamodbav:"participants"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	userID, err := url.PathUnescape(event.PathParameters["user_id"])
+	if err != nil || userID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	user, found, err := getUser(ctx, svc, userID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found || user.RoomID != roomID {
+		return createErrorResponseWithStatus(http.StatusNotFound, "user not found in the room")
+	}
+
+	// 本人の退室か、ホストによるキックだけを許可する
+	token, ok := apigw.BearerToken(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "missing session token")
+	}
+	if !apigw.MatchSessionToken(token, user.SessionTokenHash) {
+		allowed := false
+		if room.HostID != "" && room.HostID != userID {
+			host, found, err := getUser(ctx, svc, room.HostID)
+			if err != nil {
+				fmt.Println(err.Error())
+				return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+			}
+			allowed = found && host.RoomID == roomID && apigw.MatchSessionToken(token, host.SessionTokenHash)
+		}
+		if !allowed {
+			return createErrorResponseWithStatus(http.StatusForbidden, "only the player or the host can remove the player")
+		}
+	}
+
+	if err = game.RemovePlayer(ctx, svc, appCfg, roomID, userID); err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+	}, nil
+}
+
+func getUser(ctx context.Context, svc *dynamodb.Client, userID string) (UserData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
+	}
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+)
+
+type UserData struct {
+	UserID           string        `json:"user_id" dynamodbav:"user_id"`
+	RoomID           string        `json:"room_id" dynamodbav:"room_id"`
+	Answers          []game.Answer `json:"answers" dynamodbav:"answers"`
+	SessionTokenHash string        `json:"-" dynamodbav:"session_token_hash"`
+}
+
+type RoomData struct {
+	RoomID    string              `json:"room_id" dynamodbav:"room_id"`
+	Status    string              `json:"status" dynamodbav:"status"`
+	Questions []game.RoomQuestion `json:"questions" dynamodbav:"questions"`
+}
+
+type requestBody struct {
+	Answers []game.Answer `json:"answers"`
+}
+
+const roomStatusLobby = "lobby"
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	userID, err := url.PathUnescape(event.PathParameters["user_id"])
+	if err != nil || userID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	var req requestBody
+	if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "JSON parse error")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	user, found, err := getUser(ctx, svc, userID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found || user.RoomID != roomID {
+		return createErrorResponseWithStatus(http.StatusNotFound, "user not found in the room")
+	}
+	if !apigw.ValidSession(event, user.SessionTokenHash) {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "invalid session token")
+	}
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	if !isLobby(room.Status) {
+		return createErrorResponseWithStatus(http.StatusConflict, "answers can only be edited before the game starts")
+	}
+	if verr := game.ValidateAnswers(req.Answers, room.Questions); verr != nil {
+		return createValidationErrorResponse(*verr)
+	}
+
+	err = updateAnswers(ctx, svc, roomID, userID, req.Answers)
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		// 検証後にゲームが始まった
+		return createErrorResponseWithStatus(http.StatusConflict, "answers can only be edited before the game starts")
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	user.Answers = req.Answers
+	jsonResponse, err := json.Marshal(user)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// status を持たない古いルームはロビーとして扱う
+func isLobby(status string) bool {
+	return status == "" || status == roomStatusLobby
+}
+
+func getUser(ctx context.Context, svc *dynamodb.Client, userID string) (UserData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
+	}
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+// ルームがロビーにある間だけ回答を書き換える
+func updateAnswers(ctx context.Context, svc *dynamodb.Client, roomID, userID string, answers []game.Answer) error {
+	av, err := attributevalue.MarshalList(answers)
+	if err != nil {
+		return err
+	}
+	_, err = svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				ConditionCheck: &types.ConditionCheck{
+					TableName: aws.String(appCfg.RoomTableName),
+					Key: map[string]types.AttributeValue{
+						"room_id": &types.AttributeValueMemberS{Value: roomID},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(#status) OR #status = :lobby"),
+					ExpressionAttributeNames: map[string]string{
+						"#status": "status",
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":lobby": &types.AttributeValueMemberS{Value: roomStatusLobby},
+					},
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(appCfg.UserTableName),
+					Key: map[string]types.AttributeValue{
+						"user_id": &types.AttributeValueMemberS{Value: userID},
+					},
+					UpdateExpression: aws.String("SET answers = :answers"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":answers": &types.AttributeValueMemberL{Value: av},
+					},
+				},
+			},
+		},
+	})
+	return err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func createValidationErrorResponse(body game.ValidationError) (events.APIGatewayProxyResponse, error) {
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: http.StatusUnprocessableEntity,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
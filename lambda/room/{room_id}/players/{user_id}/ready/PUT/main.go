@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+)
+
+type UserData struct {
+	UserID           string `json:"user_id" dynamodbav:"user_id"`
+	NickName         string `json:"nickname" dynamodbav:"nickname"`
+	RoomID           string `json:"room_id" dynamodbav:"room_id"`
+	Ready            bool   `json:"ready" dynamodbav:"ready"`
+	SessionTokenHash string `json:"-" dynamodbav:"session_token_hash"`
+	// クイック参加したプレイヤーは回答を送るまで空
+	Answers []game.Answer `json:"-" dynamodbav:"answers"`
+}
+
+// 準備状況の項目に加え、準備完了の前に回答を確かめる質問を持つ
+type RoomData struct {
+	game.LobbyRoom
+	Questions []game.RoomQuestion `json:"questions" dynamodbav:"questions"`
+}
+
+const (
+	roomStatusLobby   = "lobby"
+	roomStatusPlaying = "playing"
+)
+
+type readyRequest struct {
+	Ready *bool `json:"ready"`
+}
+
+// 自動開始したときは started が true になる
+type readyResponse struct {
+	game.LobbySummary
+	Started bool `json:"started"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	userID, err := url.PathUnescape(event.PathParameters["user_id"])
+	if err != nil || userID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	var req readyRequest
+	if err := json.Unmarshal([]byte(event.Body), &req); err != nil || req.Ready == nil {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "ready is required")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	user, found, err := getUser(ctx, svc, userID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found || user.RoomID != roomID {
+		return createErrorResponseWithStatus(http.StatusNotFound, "user not found in the room")
+	}
+	if !apigw.ValidSession(event, user.SessionTokenHash) {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "invalid session token")
+	}
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	if !isLobby(room.Status) {
+		return createErrorResponseWithStatus(http.StatusConflict, "the game has already started")
+	}
+
+	if *req.Ready {
+		if len(user.Answers) == 0 {
+			return createErrorResponseWithStatus(http.StatusConflict, "answer the questions before getting ready")
+		}
+		// ルームの質問にちょうど答えていなければ準備完了にしない
+		if verr := game.ValidateAnswers(user.Answers, room.Questions); verr != nil {
+			return createErrorResponseWithCode(http.StatusConflict, *verr)
+		}
+	}
+
+	if err = setReady(ctx, svc, userID, *req.Ready); err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	summary, err := game.SummarizeLobby(ctx, svc, appCfg, room.LobbyRoom)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	resp := readyResponse{LobbySummary: summary}
+	if room.Settings.AutoStart && summary.AllReady {
+		// 手動の開始と同じくサンタ・質問・投票の締め切りまで固定して始める
+		_, err = game.StartGame(ctx, svc, appCfg, roomID, time.Now())
+		switch {
+		case errors.Is(err, game.ErrNoCandidates), errors.Is(err, game.ErrUnansweredPlayers):
+			// 回答が揃わずまだ始められない。ロビーのまま回答の編集を待つ
+			fmt.Println(err.Error())
+		case errors.Is(err, game.ErrGameOver):
+			resp.Status = game.StatusFinished
+		case err != nil && !errors.Is(err, game.ErrAlreadyStarted):
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+		default:
+			// 同時に準備完了した別のプレイヤーが先に始めていても結果は同じ
+			resp.Status = roomStatusPlaying
+			resp.Started = err == nil
+		}
+	}
+
+	jsonResponse, err := json.Marshal(resp)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func setReady(ctx context.Context, svc *dynamodb.Client, userID string, ready bool) error {
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		UpdateExpression: aws.String("SET ready = :ready"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ready": &types.AttributeValueMemberBOOL{Value: ready},
+		},
+	})
+	return err
+}
+
+// status を持たない古いルームはロビーとして扱う
+func isLobby(status string) bool {
+	return status == "" || status == roomStatusLobby
+}
+
+func getUser(ctx context.Context, svc *dynamodb.Client, userID string) (UserData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
+	}
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func createErrorResponseWithCode(statusCode int, body game.ValidationError) (events.APIGatewayProxyResponse, error) {
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.QuestionTable, appconfig.PackTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	qrcode "github.com/skip2/go-qrcode"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+type RoomData struct {
+	RoomID  string            `json:"room_id" dynamodbav:"room_id"`
+	Invites map[string]Invite `json:"invites" dynamodbav:"invites"`
+}
+
+// PNG の一辺の大きさ (px)
+const (
+	defaultQRSize = 256
+	minQRSize     = 128
+	maxQRSize     = 1024
+)
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	if appCfg.JoinURL == "" {
+		return createErrorResponseWithStatus(http.StatusNotImplemented, "JOIN_URL is not configured")
+	}
+	// format がなければ Accept で選ぶ
+	format := event.QueryStringParameters["format"]
+	if format == "" {
+		format = "svg"
+		if acceptsPNG(event) {
+			format = "png"
+		}
+	}
+	if format != "svg" && format != "png" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "format must be svg or png")
+	}
+	// API Gateway は Accept が binaryMediaTypes に合うときだけ base64 をバイナリに戻す。
+	// 合わないまま PNG を返すと base64 の文字列が届くので断る
+	if format == "png" && !acceptsPNG(event) {
+		return createErrorResponseWithStatus(http.StatusNotAcceptable, "send Accept: image/png to get a PNG")
+	}
+	size := defaultQRSize
+	if v := event.QueryStringParameters["size"]; v != "" {
+		size, err = strconv.Atoi(v)
+		if err != nil || size < minQRSize || size > maxQRSize {
+			return createErrorResponseWithStatus(http.StatusBadRequest, fmt.Sprintf("size must be between %d and %d", minQRSize, maxQRSize))
+		}
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+
+	// 招待トークンはハッシュしか保存していないので、持っているホストがクエリで渡す
+	inviteToken := event.QueryStringParameters["invite_token"]
+	if inviteToken != "" {
+		tokenRoomID, secretHash, ok := parseInviteToken(inviteToken)
+		invite, found := room.Invites[secretHash]
+		if !ok || !found || tokenRoomID != roomID {
+			return createErrorResponseWithStatus(http.StatusBadRequest, "invalid invite token")
+		}
+		if !invite.usable(time.Now()) {
+			return createErrorResponseWithStatus(http.StatusGone, "invite has expired or has been used up")
+		}
+	}
+
+	qr, err := qrcode.New(joinURL(roomID, inviteToken), qrcode.Medium)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	headers := map[string]string{"Cache-Control": "private, max-age=300", "Vary": "Accept"}
+	if format == "png" {
+		png, err := qr.PNG(size)
+		if err != nil {
+			return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+		}
+		headers["Content-Type"] = "image/png"
+		// API Gateway が image/png をバイナリとして返す
+		return events.APIGatewayProxyResponse{
+			StatusCode:      http.StatusOK,
+			Body:            base64.StdEncoding.EncodeToString(png),
+			IsBase64Encoded: true,
+			Headers:         headers,
+		}, nil
+	}
+	headers["Content-Type"] = "image/svg+xml"
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       renderSVG(qr.Bitmap()),
+		Headers:    headers,
+	}, nil
+}
+
+// Accept に image/png がそのまま含まれるか。image/* や */* では API Gateway がバイナリに戻さない
+func acceptsPNG(event events.APIGatewayProxyRequest) bool {
+	for _, part := range strings.Split(apigw.RequestHeader(event, "Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "image/png") {
+			return true
+		}
+	}
+	return false
+}
+
+func joinURL(roomID, inviteToken string) string {
+	u, _ := url.Parse(appCfg.JoinURL)
+	q := u.Query()
+	q.Set("room_id", roomID)
+	if inviteToken != "" {
+		q.Set("invite_token", inviteToken)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// 黒いモジュールを1つの path にまとめた SVG。Bitmap は周囲の余白を含む
+func renderSVG(bitmap [][]bool) string {
+	var b strings.Builder
+	n := len(bitmap)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, black := range row {
+			if black {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// ルームに保存する招待。キーはトークンの秘密部分のハッシュ
+type Invite struct {
+	ExpiresAt int64 `json:"expires_at" dynamodbav:"expires_at"`
+	// 0 なら回数の制限なし
+	MaxUses   int    `json:"max_uses" dynamodbav:"max_uses"`
+	Uses      int    `json:"uses" dynamodbav:"uses"`
+	CreatedBy string `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt int64  `json:"created_at" dynamodbav:"created_at"`
+}
+
+func (i Invite) usable(now time.Time) bool {
+	return now.Unix() < i.ExpiresAt && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
+
+// 招待トークンは "<base64url(room_id)>.<秘密>"。ルームはトークンだけから引ける
+func parseInviteToken(token string) (roomID, secretHash string, ok bool) {
+	encoded, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(b) == 0 {
+		return "", "", false
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return string(b), hex.EncodeToString(sum[:]), true
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
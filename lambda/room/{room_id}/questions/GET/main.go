@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+// ルーム作成時に固定された質問
+type Question struct {
+	QuestionID int               `json:"question_id" dynamodbav:"question_id"`
+	Statement  string            `json:"statement" dynamodbav:"statement"`
+	Statements map[string]string `json:"-" dynamodbav:"statements"`
+	Locale     string            `json:"locale" dynamodbav:"-"`
+}
+
+type RoomData struct {
+	RoomID    string     `json:"room_id" dynamodbav:"room_id"`
+	Questions []Question `json:"questions" dynamodbav:"questions"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+
+	svc := dynamodb.NewFromConfig(cfg)
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ProjectionExpression: aws.String("room_id, questions"),
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if result.Item == nil {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+
+	var room RoomData
+	if err := attributevalue.UnmarshalMap(result.Item, &room); err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	locales := apigw.PreferredLocales(event, appCfg.DefaultLocale)
+	questions := make([]Question, 0, len(room.Questions))
+	for _, q := range room.Questions {
+		q.Statement, q.Locale = apigw.Localize(q.Statement, q.Statements, locales, appCfg.DefaultLocale)
+		questions = append(questions, q)
+	}
+
+	jsonResponse, err := json.Marshal(RoomData{RoomID: room.RoomID, Questions: questions})
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json", "Vary": "Accept-Language"},
+	}, nil
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
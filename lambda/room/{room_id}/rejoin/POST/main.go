@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+type Answer struct {
+	QuestionID int  `json:"question_id" dynamodbav:"question_id"`
+	Answer     bool `json:"answer" dynamodbav:"answer"`
+}
+
+type UserData struct {
+	UserID           string   `json:"user_id" dynamodbav:"user_id"`
+	NickName         string   `json:"nickname" dynamodbav:"nickname"`
+	RoomID           string   `json:"room_id" dynamodbav:"room_id"`
+	Answers          []Answer `json:"answers" dynamodbav:"answers"`
+	Ready            bool     `json:"ready" dynamodbav:"ready"`
+	IsSanta          bool     `json:"is_santa" dynamodbav:"is_santa"`
+	Fired            *bool    `json:"fire" dynamodbav:"fire"`
+	Abstained        bool     `json:"abstained" dynamodbav:"abstained"`
+	SessionTokenHash string   `json:"-" dynamodbav:"session_token_hash"`
+	DeviceHash       string   `json:"-" dynamodbav:"device_hash"`
+}
+
+type RoomData struct {
+	RoomID       string   `json:"room_id" dynamodbav:"room_id"`
+	Status       string   `json:"status" dynamodbav:"status"`
+	HostID       string   `json:"host_id" dynamodbav:"host_id"`
+	Participants []string `json:"participants" dynamodbav:"participants"`
+	Settings     struct {
+		Rounds int `json:"rounds" dynamodbav:"rounds"`
+	} `json:"settings" dynamodbav:"settings"`
+	Round          int               `json:"round" dynamodbav:"round"`
+	VotingDeadline int64             `json:"voting_deadline" dynamodbav:"voting_deadline"`
+	Scores         map[string]int    `json:"scores" dynamodbav:"scores"`
+	Devices        map[string]string `json:"devices" dynamodbav:"devices"`
+}
+
+type requestBody struct {
+	DeviceID string `json:"device_id"`
+}
+
+// 再接続したプレイヤー本人から見たゲームの状態
+type playerView struct {
+	Status         string `json:"status"`
+	Round          int    `json:"round"`
+	Rounds         int    `json:"rounds"`
+	HostID         string `json:"host_id"`
+	VotingDeadline int64  `json:"voting_deadline,omitempty"`
+	Ready          bool   `json:"ready"`
+	IsSanta        bool   `json:"is_santa"`
+	Fired          *bool  `json:"fire"`
+	Abstained      bool   `json:"abstained"`
+	Score          int    `json:"score"`
+}
+
+type rejoinResponse struct {
+	UserID   string   `json:"user_id"`
+	NickName string   `json:"nickname"`
+	RoomID   string   `json:"room_id"`
+	Answers  []Answer `json:"answers"`
+	// 端末 ID で再接続したときだけ、新しく発行したトークンを返す
+	SessionToken string     `json:"session_token,omitempty"`
+	View         playerView `json:"view"`
+}
+
+const (
+	roomStatusLobby = "lobby"
+)
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	var req requestBody
+	if event.Body != "" {
+		if err = json.Unmarshal([]byte(event.Body), &req); err != nil {
+			return createErrorResponseWithStatus(http.StatusBadRequest, "Invalid JSON format")
+		}
+	}
+	token, hasToken := apigw.BearerToken(event)
+	if !hasToken && req.DeviceID == "" {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "session token or device_id is required")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+
+	// セッショントークンを優先し、なければ端末 ID で本人を探す
+	var user UserData
+	found = false
+	if hasToken {
+		user, found, err = findPlayerByToken(ctx, svc, room, token)
+	} else if userID, ok := room.Devices[apigw.HashSecret(req.DeviceID)]; ok {
+		user, found, err = getUser(ctx, svc, userID)
+		found = found && user.RoomID == roomID && user.DeviceHash == apigw.HashSecret(req.DeviceID)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "no player in this room matches the credentials")
+	}
+
+	resp := rejoinResponse{
+		UserID:   user.UserID,
+		NickName: user.NickName,
+		RoomID:   roomID,
+		Answers:  user.Answers,
+		View:     buildPlayerView(room, user),
+	}
+	if resp.Answers == nil {
+		resp.Answers = []Answer{}
+	}
+
+	var tokenHash string
+	if !hasToken {
+		// 端末 ID での再接続ではトークンを発行し直し、古いトークンを無効にする
+		resp.SessionToken, tokenHash, err = apigw.NewSessionToken()
+		if err != nil {
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "Could not issue a session token")
+		}
+	}
+	err = touchUser(ctx, svc, user, tokenHash, time.Now())
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		// 直前に退室した
+		return createErrorResponseWithStatus(http.StatusNotFound, "user not found in the room")
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	jsonResponse, err := json.Marshal(resp)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func buildPlayerView(room RoomData, user UserData) playerView {
+	view := playerView{
+		Status:         room.Status,
+		Round:          max(room.Round, 1),
+		Rounds:         max(room.Settings.Rounds, 1),
+		HostID:         room.HostID,
+		VotingDeadline: room.VotingDeadline,
+		Ready:          user.Ready,
+		IsSanta:        user.IsSanta,
+		Fired:          user.Fired,
+		Abstained:      user.Abstained,
+		Score:          room.Scores[user.UserID],
+	}
+	if view.Status == "" {
+		view.Status = roomStatusLobby
+	}
+	return view
+}
+
+func findPlayerByToken(ctx context.Context, svc *dynamodb.Client, room RoomData, token string) (UserData, bool, error) {
+	for _, participant := range room.Participants {
+		user, found, err := getUser(ctx, svc, participant)
+		if err != nil {
+			return UserData{}, false, err
+		}
+		if found && user.RoomID == room.RoomID && apigw.MatchSessionToken(token, user.SessionTokenHash) {
+			return user, true, nil
+		}
+	}
+	return UserData{}, false, nil
+}
+
+// last_seen を更新する。tokenHash があればセッショントークンも差し替える
+func touchUser(ctx context.Context, svc *dynamodb.Client, user UserData, tokenHash string, now time.Time) error {
+	update := "SET last_seen = :now"
+	values := map[string]types.AttributeValue{
+		":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		":room_id": &types.AttributeValueMemberS{Value: user.RoomID},
+	}
+	if tokenHash != "" {
+		update += ", session_token_hash = :token_hash"
+		values[":token_hash"] = &types.AttributeValueMemberS{Value: tokenHash}
+	}
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: user.UserID},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("room_id = :room_id"),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+func getUser(ctx context.Context, svc *dynamodb.Client, userID string) (UserData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
+	}
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+	"shared/passcode"
+	"shared/question"
+)
+
+type RoomSettings struct {
+	QuestionCount int    `json:"question_count" dynamodbav:"question_count"`
+	MinPlayers    int    `json:"min_players" dynamodbav:"min_players"`
+	AutoStart     bool   `json:"auto_start" dynamodbav:"auto_start"`
+	Rounds        int    `json:"rounds" dynamodbav:"rounds"`
+	MaxPlayers    int    `json:"max_players,omitempty" dynamodbav:"max_players,omitempty"`
+	Public        bool   `json:"public" dynamodbav:"public"`
+	Language      string `json:"language,omitempty" dynamodbav:"language,omitempty"`
+}
+
+const (
+	roomStatusLobby    = "lobby"
+	roomStatusFinished = "finished"
+)
+
+type RoomData struct {
+	RoomId        string                  `json:"room_id" dynamodbav:"room_id"`
+	Name          string                  `json:"name,omitempty" dynamodbav:"name,omitempty"`
+	Status        string                  `json:"status" dynamodbav:"status"`
+	HostID        string                  `json:"host_id,omitempty" dynamodbav:"host_id,omitempty"`
+	Participants  []string                `json:"participants" dynamodbav:"participants"`
+	PackId        string                  `json:"pack_id,omitempty" dynamodbav:"pack_id,omitempty"`
+	Settings      RoomSettings            `json:"settings" dynamodbav:"settings"`
+	Questions     []question.RoomQuestion `json:"questions" dynamodbav:"questions"`
+	Round         int                     `json:"round" dynamodbav:"round"`
+	CreatedAt     int64                   `json:"created_at" dynamodbav:"created_at"`
+	LobbyDeadline int64                   `json:"lobby_deadline" dynamodbav:"lobby_deadline"`
+	// DeadlineIndex のソートキー
+	Deadline int64 `json:"-" dynamodbav:"deadline"`
+	// 再戦で作られたルームの元のルーム
+	PreviousRoomID string `json:"previous_room_id,omitempty" dynamodbav:"previous_room_id,omitempty"`
+	// 端末 ID のハッシュ -> user_id
+	Devices map[string]string `json:"-" dynamodbav:"devices"`
+	// 正規化したニックネーム -> user_id
+	NickNames      map[string]string `json:"-" dynamodbav:"nicknames"`
+	PublicLanguage string            `json:"-" dynamodbav:"public_language,omitempty"`
+	// プライベートルームの合言葉は再戦のルームにも引き継ぐ
+	passcode.Lock
+	TTL int64 `json:"-" dynamodbav:"TTL"`
+}
+
+type UserData struct {
+	UserID           string `json:"user_id" dynamodbav:"user_id"`
+	RoomID           string `json:"room_id" dynamodbav:"room_id"`
+	SessionTokenHash string `json:"-" dynamodbav:"session_token_hash"`
+}
+
+type requestBody struct {
+	// 新しいルームのコード。省略するとランダムに作る
+	RoomId string `json:"room_id"`
+	// 前のルームの質問と回答をそのまま使う
+	ReuseAnswers bool `json:"reuse_answers"`
+}
+
+type responseBody struct {
+	RoomId         string       `json:"room_id"`
+	PreviousRoomID string       `json:"previous_room_id"`
+	PackId         string       `json:"pack_id,omitempty"`
+	Settings       RoomSettings `json:"settings"`
+	QuestionIds    []int        `json:"question_ids"`
+	Participants   []string     `json:"participants"`
+	ReuseAnswers   bool         `json:"reuse_answers"`
+}
+
+// 元のルームにつながっているクライアントへ送る通知
+type rematchNotification struct {
+	Type           string `json:"type"`
+	RoomID         string `json:"room_id"`
+	PreviousRoomID string `json:"previous_room_id"`
+}
+
+// TransactWriteItems で一度に書き込める件数 (新旧ルームの2件を除いた分がプレイヤー)
+const maxTransactItems = 100
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	oldRoomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || oldRoomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	var req requestBody
+	if event.Body != "" {
+		if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
+			return createErrorResponseWithStatus(http.StatusBadRequest, "JSON parse error")
+		}
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	oldRoom, found, err := getRoom(ctx, svc, oldRoomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+
+	// ホストのいない古いルームでは参加者なら誰でも再戦を始められる
+	token, ok := apigw.BearerToken(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "missing session token")
+	}
+	var players []string
+	allowed := false
+	for _, participant := range oldRoom.Participants {
+		user, found, err := getUser(ctx, svc, participant)
+		if err != nil {
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+		}
+		if !found || user.RoomID != oldRoomID {
+			continue
+		}
+		players = append(players, user.UserID)
+		if (oldRoom.HostID == "" || user.UserID == oldRoom.HostID) && apigw.MatchSessionToken(token, user.SessionTokenHash) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return createErrorResponseWithStatus(http.StatusForbidden, "only the host can start a rematch")
+	}
+	if oldRoom.Status != roomStatusFinished {
+		return createErrorResponseWithStatus(http.StatusConflict, "the game has not finished yet")
+	}
+	if len(players)+2 > maxTransactItems {
+		return createErrorResponseWithStatus(http.StatusConflict, "too many players to move at once")
+	}
+
+	if req.RoomId == "" {
+		if req.RoomId, err = game.NewRoomID(); err != nil {
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "could not create room_id")
+		}
+	}
+
+	questions := oldRoom.Questions
+	if !req.ReuseAnswers || len(questions) == 0 {
+		req.ReuseAnswers = false
+		if questions, err = drawRoomQuestions(ctx, svc, oldRoom); err != nil {
+			fmt.Println(err.Error())
+			return createErrorResponseWithStatus(http.StatusInternalServerError, "could not draw questions")
+		}
+	}
+
+	now := time.Now()
+	room := RoomData{
+		RoomId:         req.RoomId,
+		Name:           oldRoom.Name,
+		Status:         roomStatusLobby,
+		HostID:         oldRoom.HostID,
+		Participants:   players,
+		PackId:         oldRoom.PackId,
+		Settings:       oldRoom.Settings,
+		Questions:      questions,
+		Round:          1,
+		CreatedAt:      now.Unix(),
+		LobbyDeadline:  now.Add(appCfg.LobbyTimeout).Unix(),
+		Deadline:       now.Add(appCfg.LobbyTimeout).Unix(),
+		PreviousRoomID: oldRoomID,
+		Devices:        map[string]string{},
+		NickNames:      map[string]string{},
+		PublicLanguage: oldRoom.PublicLanguage,
+		Lock:           oldRoom.Lock,
+		TTL:            now.Add(appCfg.RoomTTL).Unix(),
+	}
+	if room.Participants == nil {
+		room.Participants = []string{}
+	}
+	// 移るプレイヤーは新しいルームでも同じ端末 ID で再接続できる
+	for device, userID := range oldRoom.Devices {
+		if slices.Contains(room.Participants, userID) {
+			room.Devices[device] = userID
+		}
+	}
+	for nickname, userID := range oldRoom.NickNames {
+		if slices.Contains(room.Participants, userID) {
+			room.NickNames[nickname] = userID
+		}
+	}
+
+	err = createRematchRoom(ctx, svc, oldRoomID, room, req.ReuseAnswers, now)
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		return createErrorResponseWithStatus(http.StatusConflict, rematchConflictMessage(canceled))
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	// 通知に失敗しても再戦のルームはできているので、ログだけ残す
+	if err := notifyRoom(ctx, oldRoomID, rematchNotification{Type: "rematch", RoomID: room.RoomId, PreviousRoomID: oldRoomID}); err != nil {
+		fmt.Printf("WARN:could not notify room %v: %v\n", oldRoomID, err)
+	}
+
+	resp := responseBody{
+		RoomId:         room.RoomId,
+		PreviousRoomID: oldRoomID,
+		PackId:         room.PackId,
+		Settings:       room.Settings,
+		QuestionIds:    []int{},
+		Participants:   room.Participants,
+		ReuseAnswers:   req.ReuseAnswers,
+	}
+	for _, q := range room.Questions {
+		resp.QuestionIds = append(resp.QuestionIds, q.QuestionID)
+	}
+	jsonResponse, err := json.Marshal(resp)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// 元のルームと同じパックと設定で質問を引き直す
+func drawRoomQuestions(ctx context.Context, svc *dynamodb.Client, oldRoom RoomData) ([]question.RoomQuestion, error) {
+	count := oldRoom.Settings.QuestionCount
+	if count == 0 {
+		count = appCfg.Game.QuestionCount
+	}
+	return question.Draw(ctx, svc, appCfg, oldRoom.PackId, count)
+}
+
+// 新しいルームを作り、プレイヤーを同じ user_id とセッショントークンのまま移す。
+// 元のルームには再戦先を記録し、同じルームから二重に再戦できないようにする
+func createRematchRoom(ctx context.Context, svc *dynamodb.Client, oldRoomID string, room RoomData, reuseAnswers bool, now time.Time) error {
+	item, err := attributevalue.MarshalMap(room)
+	if err != nil {
+		return err
+	}
+
+	items := []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName:           aws.String(appCfg.RoomTableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(room_id)"),
+			},
+		},
+		{
+			Update: &types.Update{
+				TableName: aws.String(appCfg.RoomTableName),
+				Key: map[string]types.AttributeValue{
+					"room_id": &types.AttributeValueMemberS{Value: oldRoomID},
+				},
+				UpdateExpression:    aws.String("SET rematch_room_id = :room"),
+				ConditionExpression: aws.String("#status = :finished AND attribute_not_exists(rematch_room_id)"),
+				ExpressionAttributeNames: map[string]string{
+					"#status": "status",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":room":     &types.AttributeValueMemberS{Value: room.RoomId},
+					":finished": &types.AttributeValueMemberS{Value: roomStatusFinished},
+				},
+			},
+		},
+	}
+
+	// 前のゲームの役割と投票は消す。回答を使い回さないときは PUT answers で答え直す
+	update := "SET room_id = :room, ready = :false, last_seen = :now REMOVE is_santa, fire, fired_by, fire_round, abstained, abstained_round"
+	if !reuseAnswers {
+		update = "SET room_id = :room, ready = :false, last_seen = :now, answers = :empty REMOVE is_santa, fire, fired_by, fire_round, abstained, abstained_round"
+	}
+	for _, userID := range room.Participants {
+		values := map[string]types.AttributeValue{
+			":room":  &types.AttributeValueMemberS{Value: room.RoomId},
+			":old":   &types.AttributeValueMemberS{Value: oldRoomID},
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		}
+		if !reuseAnswers {
+			values[":empty"] = &types.AttributeValueMemberL{Value: []types.AttributeValue{}}
+		}
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(appCfg.UserTableName),
+				Key: map[string]types.AttributeValue{
+					"user_id": &types.AttributeValueMemberS{Value: userID},
+				},
+				UpdateExpression:          aws.String(update),
+				ConditionExpression:       aws.String("room_id = :old"),
+				ExpressionAttributeValues: values,
+			},
+		})
+	}
+
+	_, err = svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	return err
+}
+
+// どの条件で失敗したかを利用者向けのメッセージにする
+func rematchConflictMessage(canceled *types.TransactionCanceledException) string {
+	reasons := canceled.CancellationReasons
+	switch {
+	case len(reasons) > 0 && aws.ToString(reasons[0].Code) == "ConditionalCheckFailed":
+		return "room is already in use"
+	case len(reasons) > 1 && aws.ToString(reasons[1].Code) == "ConditionalCheckFailed":
+		return "a rematch has already been created"
+	default:
+		return "players changed while creating the rematch, please retry"
+	}
+}
+
+// WebSocket サーバーの POST /publish でトピック (ルーム ID) に通知する
+func notifyRoom(ctx context.Context, topic string, message any) error {
+	if appCfg.NotifyURL == "" {
+		return nil
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"topic": topic, "message": string(payload)})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, appCfg.NotifyURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+appCfg.NotifyToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("publish returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func getUser(ctx context.Context, svc *dynamodb.Client, userID string) (UserData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
+	}
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.QuestionTable, appconfig.PackTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
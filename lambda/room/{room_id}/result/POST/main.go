@@ -5,22 +5,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/url"
-	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/aws-sdk-go/aws"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
 )
 
 type requestBody struct {
 	UserID     string `json:"user_id"`
 	FireUserID string `json:"fire_user_id"`
 	QuestionID int    `json:"question_id"`
+	// クライアントが投票しているラウンド。省略すると今のラウンドへの投票として扱う
+	Round int `json:"round"`
 }
 
 type answer struct {
@@ -42,12 +50,12 @@ type response struct {
 
 var svc *dynamodb.Client
 
+// 投票の締め切りと比べる時計。1回の呼び出しの中では同じ時刻を使う
+var clock = time.Now
+
 func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	fmt.Printf("room/{%s}/reult/%s\n", event.PathParameters["room_id"], event.HTTPMethod)
-	tableName := os.Getenv("TABLE_NAME")
-	if tableName == "" {
-		return serverErrorResponse(fmt.Errorf("TABLE_NAME environment variable is not set"))
-	}
+	tableName := appCfg.UserTableName
 
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
@@ -55,6 +63,7 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 	}
 
 	svc = dynamodb.NewFromConfig(cfg)
+	now := clock()
 
 	var body requestBody
 	err = json.Unmarshal([]byte(event.Body), &body)
@@ -102,28 +111,81 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 		return badRequestErrorResponse(errors.New("user not found in the room"))
 	}
 
+	// 投票はラウンドが始まってから締め切りまでに、今のラウンドに対してだけ受け付ける
+	room, err := getRoom(ctx, roomID)
+	if err != nil {
+		return serverErrorResponse(err)
+	}
+	if room.Status != game.StatusPlaying || room.VotingDeadline == 0 || now.Unix() > room.VotingDeadline {
+		return conflictResponse("voting is not open")
+	}
+	round := room.CurrentRound()
+	if body.Round != 0 && body.Round != round {
+		return conflictResponse(fmt.Sprintf("round %d is not in progress", body.Round))
+	}
+
 	// 火を灯したユーザがサンタでなければ灯されたユーザの火は消えない
-	is_fire := !fireUser.IsSanta
+	isSanta := fireUser.IsSanta
+	if room.SantaID != "" {
+		isSanta = fireUser.UserID == room.SantaID
+	}
+	is_fire := !isSanta
+	// 質問はルームに固定されたものを使う
+	questionID := body.QuestionID
+	if room.QuestionID != 0 {
+		questionID = room.QuestionID
+	}
 	// 火を灯したユーザがサンタでなくても質問が false なら火は消える
 	if is_fire { // 配布される質問は全てサンタが false にしたもののはずだけど一応確認
 		for _, ans := range fireUser.Answers {
-			if ans.QuestionID == body.QuestionID {
+			if ans.QuestionID == questionID {
 				is_fire = ans.Answer
 			}
 		}
 	}
 
-	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"user_id": &types.AttributeValueMemberS{Value: body.UserID},
-		},
-		UpdateExpression: aws.String("set fire = :f, fired_by = :u"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":f": &types.AttributeValueMemberBOOL{Value: is_fire},
-			":u": &types.AttributeValueMemberS{Value: fireUser.UserID},
+	// 読んでから書くまでにラウンドが進んだり締め切られたりしていれば書き込まない
+	_, err = svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				ConditionCheck: &types.ConditionCheck{
+					TableName: aws.String(appCfg.RoomTableName),
+					Key: map[string]types.AttributeValue{
+						"room_id": &types.AttributeValueMemberS{Value: roomID},
+					},
+					ConditionExpression: aws.String("#status = :playing AND (#round = :round OR attribute_not_exists(#round)) AND voting_deadline >= :now"),
+					ExpressionAttributeNames: map[string]string{
+						"#status": "status",
+						"#round":  "round",
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":playing": &types.AttributeValueMemberS{Value: game.StatusPlaying},
+						":round":   &types.AttributeValueMemberN{Value: strconv.Itoa(round)},
+						":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+					},
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(tableName),
+					Key: map[string]types.AttributeValue{
+						"user_id": &types.AttributeValueMemberS{Value: body.UserID},
+					},
+					UpdateExpression:    aws.String("SET fire = :f, fired_by = :u, fire_round = :round"),
+					ConditionExpression: aws.String("attribute_exists(user_id)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":f":     &types.AttributeValueMemberBOOL{Value: is_fire},
+						":u":     &types.AttributeValueMemberS{Value: fireUser.UserID},
+						":round": &types.AttributeValueMemberN{Value: strconv.Itoa(round)},
+					},
+				},
+			},
 		},
 	})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		return conflictResponse("voting for this round is closed")
+	}
 	if err != nil {
 		return serverErrorResponse(err)
 	}
@@ -136,7 +198,7 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
 		Body:       string(resp),
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
@@ -145,7 +207,7 @@ func serverErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
 	return events.APIGatewayProxyResponse{
 		StatusCode: 500,
 		Body:       `{"message": "Internal Server Error"}`,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
@@ -154,10 +216,36 @@ func badRequestErrorResponse(err error) (events.APIGatewayProxyResponse, error)
 	return events.APIGatewayProxyResponse{
 		StatusCode: 400,
 		Body:       `{"message": "Bad Request"}`,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
+func conflictResponse(message string) (events.APIGatewayProxyResponse, error) {
+	fmt.Println(message)
+	body, _ := json.Marshal(map[string]string{"message": message})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 409,
+		Body:       string(body),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func getRoom(ctx context.Context, roomID string) (game.Room, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return game.Room{}, err
+	}
+	var room game.Room
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, err
+}
+
 func getUser(ctx context.Context, tableName string, userID string) (*dynamodb.GetItemOutput, error) {
 	return svc.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
@@ -167,6 +255,13 @@ func getUser(ctx context.Context, tableName string, userID string) (*dynamodb.Ge
 	})
 }
 
+var appCfg appconfig.Config
+
 func main() {
-	lambda.Start(handler)
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 
@@ -14,6 +15,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
 )
 
 type response struct {
@@ -34,6 +38,8 @@ type user struct {
 	IsSanta  bool     `json:"is_santa" dynamodbav:"is_santa"`
 	FiredBy  string   `json:"fired_by" dynamodbav:"fired_by"`
 	Answers  []answer `json:"answers" dynamodbav:"answers"`
+	// 投票中に応答がなくなったプレイヤー
+	Abstained bool `json:"abstained" dynamodbav:"abstained"`
 }
 type answer struct {
 	QuestionId int  `json:"question_id" dynamodbav:"question_id"`
@@ -41,8 +47,8 @@ type answer struct {
 }
 
 func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	const roomTableName = "CandleBackendRoomTable" // || os.LookupEnv("ROOM_TABLE_NAME")
-	const userTableName = "CandleBackendUserTable" // || os.LookupEnv("USER_TABLE_NAME")
+	roomTableName := appCfg.RoomTableName
+	userTableName := appCfg.UserTableName
 	roomId := event.PathParameters["room_id"]
 	roomId, err := url.PathUnescape(roomId)
 	if err != nil {
@@ -65,14 +71,18 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 	requestedUser, calculated, err := getUser(cfg, userId, userTableName)
 	if err != nil {
 		return createResponseWithStatus(http.StatusInternalServerError), err
-	} else if !calculated {
+	} else if !calculated && !requestedUser.Abstained {
 		return createResponseWithStatus(http.StatusAccepted), nil
 	}
-	igniteUser, calculated, err := getUser(cfg, requestedUser.FiredBy, userTableName)
-	if err != nil {
-		return createResponseWithStatus(http.StatusInternalServerError), err
-	} else if !calculated {
-		return createResponseWithStatus(http.StatusAccepted), nil
+	// 棄権扱いになったプレイヤーには火を灯した人がいない
+	var igniteUser user
+	if calculated {
+		igniteUser, calculated, err = getUser(cfg, requestedUser.FiredBy, userTableName)
+		if err != nil {
+			return createResponseWithStatus(http.StatusInternalServerError), err
+		} else if !calculated {
+			return createResponseWithStatus(http.StatusAccepted), nil
+		}
 	}
 	numberParticipants := len(targetRoom.Participants)
 	numberFired := 0
@@ -82,6 +92,11 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 		if err != nil {
 			return createResponseWithStatus(http.StatusInternalServerError), err
 		} else if !calculated {
+			// 離脱したプレイヤーは集計から外す
+			if u.Abstained {
+				numberParticipants--
+				continue
+			}
 			fmt.Printf("INFO:room %v, user %v not fired\n", roomId, participant)
 			return createResponseWithStatus(http.StatusAccepted), nil
 		}
@@ -111,19 +126,26 @@ func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.A
 	return events.APIGatewayProxyResponse{
 		Body:       string(jsonResp),
 		StatusCode: http.StatusOK,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 
 }
 
+var appCfg appconfig.Config
+
 func main() {
-	lambda.Start(handler)
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
 }
 
 func createResponseWithStatus(statuCode int) events.APIGatewayProxyResponse {
 	return events.APIGatewayProxyResponse{
 		StatusCode: statuCode,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}
 }
 func getRoom(cfg aws.Config, roomId string, tableName string) (room, error) {
@@ -150,13 +172,10 @@ func getUser(cfg aws.Config, userId string, tableName string) (user, bool, error
 			"user_id": &types.AttributeValueMemberS{Value: userId},
 		},
 	})
-	if resp.Item["fire"] == nil {
-		return user{}, false, nil
-	}
 	var u user
 	if err != nil {
 		return u, true, err
 	}
 	err = attributevalue.UnmarshalMap(resp.Item, &u)
-	return u, true, err
+	return u, resp.Item["fire"] != nil, err
 }
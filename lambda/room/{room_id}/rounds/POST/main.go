@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+)
+
+type UserData struct {
+	game.Player
+	NickName         string `json:"nickname" dynamodbav:"nickname"`
+	RoomID           string `json:"room_id" dynamodbav:"room_id"`
+	SessionTokenHash string `json:"-" dynamodbav:"session_token_hash"`
+}
+
+type RoomData struct {
+	game.Room
+	HostID string `json:"host_id" dynamodbav:"host_id"`
+}
+
+// 今のラウンドを集計して次のラウンドへ進める (ホストだけが呼べる)
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	players, err := getPlayers(ctx, svc, room)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+
+	// ホストのいない古いルームでは参加者なら誰でも進められる
+	token, ok := apigw.BearerToken(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "missing session token")
+	}
+	allowed := false
+	for _, p := range players {
+		if (room.HostID == "" || p.UserID == room.HostID) && apigw.MatchSessionToken(token, p.SessionTokenHash) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return createErrorResponseWithStatus(http.StatusForbidden, "only the host can advance the round")
+	}
+
+	if room.Status != game.StatusPlaying {
+		return createErrorResponseWithStatus(http.StatusConflict, "no round is in progress")
+	}
+	gamePlayers := make([]game.Player, 0, len(players))
+	for _, p := range players {
+		gamePlayers = append(gamePlayers, p.Player)
+	}
+	result, complete := game.TallyRound(room.Room, gamePlayers)
+	if !complete {
+		return createErrorResponseWithStatus(http.StatusConflict, "the round is not over yet")
+	}
+	room.Room, err = game.AdvanceRound(ctx, svc, appCfg, room.Room, gamePlayers, result)
+	if errors.Is(err, game.ErrRoundChanged) {
+		return createErrorResponseWithStatus(http.StatusConflict, "the round has already been advanced")
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	jsonResponse, err := json.Marshal(buildStandings(room, players))
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+type standing struct {
+	Rank     int    `json:"rank"`
+	UserID   string `json:"user_id"`
+	NickName string `json:"nickname"`
+	Score    int    `json:"score"`
+}
+
+type standingsResponse struct {
+	RoomID       string             `json:"room_id"`
+	Status       string             `json:"status"`
+	Round        int                `json:"round"`
+	Rounds       int                `json:"rounds"`
+	Finished     bool               `json:"finished"`
+	Standings    []standing         `json:"standings"`
+	RoundResults []game.RoundResult `json:"round_results"`
+}
+
+// 累計点の高い順に並べる。同点は同じ順位にする
+func buildStandings(room RoomData, players []UserData) standingsResponse {
+	resp := standingsResponse{
+		RoomID:       room.RoomID,
+		Status:       room.Status,
+		Round:        room.CurrentRound(),
+		Rounds:       room.TotalRounds(),
+		Finished:     room.Status == game.StatusFinished,
+		Standings:    []standing{},
+		RoundResults: room.RoundResults,
+	}
+	if resp.RoundResults == nil {
+		resp.RoundResults = []game.RoundResult{}
+	}
+	for _, p := range players {
+		resp.Standings = append(resp.Standings, standing{UserID: p.UserID, NickName: p.NickName, Score: room.Scores[p.UserID]})
+	}
+	sort.SliceStable(resp.Standings, func(i, j int) bool {
+		return resp.Standings[i].Score > resp.Standings[j].Score
+	})
+	for i := range resp.Standings {
+		if i > 0 && resp.Standings[i].Score == resp.Standings[i-1].Score {
+			resp.Standings[i].Rank = resp.Standings[i-1].Rank
+		} else {
+			resp.Standings[i].Rank = i + 1
+		}
+	}
+	return resp
+}
+
+func getPlayers(ctx context.Context, svc *dynamodb.Client, room RoomData) ([]UserData, error) {
+	var players []UserData
+	for _, participant := range room.Participants {
+		result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(appCfg.UserTableName),
+			Key: map[string]types.AttributeValue{
+				"user_id": &types.AttributeValueMemberS{Value: participant},
+			},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.Item == nil {
+			continue
+		}
+		var user UserData
+		if err = attributevalue.UnmarshalMap(result.Item, &user); err != nil {
+			return nil, err
+		}
+		players = append(players, user)
+	}
+	return players, nil
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+)
+
+// 投票と棄権は何ラウンド目のものかを game.Player で見分ける
+type UserData struct {
+	game.Player
+	NickName string `json:"nickname" dynamodbav:"nickname"`
+	Ready    bool   `json:"ready" dynamodbav:"ready"`
+}
+
+type Spectator struct {
+	Name      string `json:"name" dynamodbav:"name"`
+	TokenHash string `json:"-" dynamodbav:"token_hash"`
+}
+
+type RoomData struct {
+	RoomID       string   `json:"room_id" dynamodbav:"room_id"`
+	Status       string   `json:"status" dynamodbav:"status"`
+	HostID       string   `json:"host_id" dynamodbav:"host_id"`
+	Participants []string `json:"participants" dynamodbav:"participants"`
+	Settings     struct {
+		MinPlayers int `json:"min_players" dynamodbav:"min_players"`
+		Rounds     int `json:"rounds" dynamodbav:"rounds"`
+	} `json:"settings" dynamodbav:"settings"`
+	Round          int                  `json:"round" dynamodbav:"round"`
+	VotingDeadline int64                `json:"voting_deadline" dynamodbav:"voting_deadline"`
+	RoundResults   []RoundResult        `json:"round_results" dynamodbav:"round_results"`
+	Scores         map[string]int       `json:"scores" dynamodbav:"scores"`
+	Spectators     map[string]Spectator `json:"spectators" dynamodbav:"spectators"`
+}
+
+type RoundResult struct {
+	Round         int            `json:"round" dynamodbav:"round"`
+	SantaID       string         `json:"santa_id" dynamodbav:"santa_id"`
+	QuestionID    int            `json:"question_id" dynamodbav:"question_id"`
+	SantaSurvived bool           `json:"santa_survived" dynamodbav:"santa_survived"`
+	Points        map[string]int `json:"points" dynamodbav:"points"`
+}
+
+const (
+	roomStatusLobby    = "lobby"
+	roomStatusFinished = "finished"
+)
+
+// 観戦者に見せるプレイヤー。役割や誰が火を灯したかはゲームが終わるまで伏せる
+type spectatorPlayer struct {
+	UserID    string `json:"user_id"`
+	NickName  string `json:"nickname"`
+	Host      bool   `json:"host"`
+	Ready     bool   `json:"ready"`
+	Voted     bool   `json:"voted"`
+	IsSanta   *bool  `json:"is_santa,omitempty"`
+	Fired     *bool  `json:"fired,omitempty"`
+	FiredBy   string `json:"fired_by,omitempty"`
+	Abstained bool   `json:"abstained,omitempty"`
+	Score     *int   `json:"score,omitempty"`
+}
+
+type spectatorView struct {
+	RoomID         string            `json:"room_id"`
+	Status         string            `json:"status"`
+	Round          int               `json:"round"`
+	Rounds         int               `json:"rounds"`
+	MinPlayers     int               `json:"min_players"`
+	VotingDeadline int64             `json:"voting_deadline,omitempty"`
+	PlayerCount    int               `json:"player_count"`
+	ReadyCount     int               `json:"ready_count"`
+	VotedCount     int               `json:"voted_count"`
+	Players        []spectatorPlayer `json:"players"`
+	// ゲームが終わったときだけ返す
+	RoundResults []RoundResult `json:"round_results,omitempty"`
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	token, ok := apigw.BearerToken(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "missing spectator token")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	authorized := false
+	for _, spectator := range room.Spectators {
+		if apigw.MatchSessionToken(token, spectator.TokenHash) {
+			authorized = true
+		}
+	}
+	if !authorized {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "invalid spectator token")
+	}
+
+	players, err := getPlayers(ctx, svc, room)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+
+	jsonResponse, err := json.Marshal(buildSpectatorView(room, players))
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+func buildSpectatorView(room RoomData, players []UserData) spectatorView {
+	view := spectatorView{
+		RoomID:         room.RoomID,
+		Status:         room.Status,
+		Round:          max(room.Round, 1),
+		Rounds:         max(room.Settings.Rounds, 1),
+		MinPlayers:     room.Settings.MinPlayers,
+		VotingDeadline: room.VotingDeadline,
+		PlayerCount:    len(players),
+		Players:        []spectatorPlayer{},
+	}
+	if view.Status == "" {
+		view.Status = roomStatusLobby
+	}
+	// 人数の設定がない古いルームは既定値を使う
+	if view.MinPlayers == 0 {
+		view.MinPlayers = appCfg.Game.MinPlayers
+	}
+	finished := view.Status == roomStatusFinished
+
+	nickNames := make(map[string]string, len(players))
+	for _, p := range players {
+		nickNames[p.UserID] = p.NickName
+	}
+	for _, p := range players {
+		sp := spectatorPlayer{
+			UserID:   p.UserID,
+			NickName: p.NickName,
+			Host:     p.UserID == room.HostID,
+			Ready:    p.Ready,
+			// 前のラウンドの投票や棄権は数えない
+			Voted: p.FiredIn(view.Round) || p.AbstainedIn(view.Round),
+		}
+		if sp.Ready {
+			view.ReadyCount++
+		}
+		if sp.Voted {
+			view.VotedCount++
+		}
+		if finished {
+			isSanta := p.IsSanta
+			score := room.Scores[p.UserID]
+			sp.IsSanta = &isSanta
+			sp.Fired = p.Fired
+			sp.FiredBy = nickNames[p.FiredBy]
+			sp.Abstained = p.Abstained
+			sp.Score = &score
+		}
+		view.Players = append(view.Players, sp)
+	}
+	if finished {
+		view.RoundResults = room.RoundResults
+		sort.SliceStable(view.Players, func(i, j int) bool {
+			return *view.Players[i].Score > *view.Players[j].Score
+		})
+	}
+	return view
+}
+
+func getPlayers(ctx context.Context, svc *dynamodb.Client, room RoomData) ([]UserData, error) {
+	var players []UserData
+	for _, participant := range room.Participants {
+		result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(appCfg.UserTableName),
+			Key: map[string]types.AttributeValue{
+				"user_id": &types.AttributeValueMemberS{Value: participant},
+			},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.Item == nil {
+			continue
+		}
+		var user UserData
+		if err = attributevalue.UnmarshalMap(result.Item, &user); err != nil {
+			return nil, err
+		}
+		players = append(players, user)
+	}
+	return players, nil
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
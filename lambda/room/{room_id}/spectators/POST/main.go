@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/passcode"
+)
+
+// 観戦者はプレイヤーにも参加者一覧にも入らず、ルームに閲覧用トークンのハッシュだけを持つ
+type Spectator struct {
+	Name      string `json:"name" dynamodbav:"name"`
+	TokenHash string `json:"-" dynamodbav:"token_hash"`
+	JoinedAt  int64  `json:"joined_at" dynamodbav:"joined_at"`
+}
+
+type RoomData struct {
+	RoomID     string               `json:"room_id" dynamodbav:"room_id"`
+	Spectators map[string]Spectator `json:"spectators" dynamodbav:"spectators"`
+	passcode.Lock
+}
+
+type requestBody struct {
+	Name string `json:"name"`
+	// プライベートルームの合言葉
+	Passcode string `json:"passcode"`
+}
+
+type responseBody struct {
+	SpectatorID    string `json:"spectator_id"`
+	Name           string `json:"name"`
+	SpectatorToken string `json:"spectator_token"`
+}
+
+// 1ルームあたりの観戦者の上限
+const maxSpectators = 10
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+	var req requestBody
+	if event.Body != "" {
+		if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
+			return createErrorResponseWithStatus(http.StatusBadRequest, "JSON parse error")
+		}
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if utf8.RuneCountInString(req.Name) > appCfg.Game.NickNameMaxLength {
+		return createErrorResponseWithStatus(http.StatusBadRequest, fmt.Sprintf("name must be at most %d characters", appCfg.Game.NickNameMaxLength))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	result, err := passcode.Check(ctx, svc, appCfg, roomID, event.RequestContext.Identity.SourceIP, room.Lock, req.Passcode, time.Now())
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+	switch result {
+	case passcode.Required:
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "passcode required")
+	case passcode.Invalid:
+		return createErrorResponseWithStatus(http.StatusForbidden, "invalid passcode")
+	case passcode.Locked:
+		return createErrorResponseWithStatus(http.StatusTooManyRequests, "too many wrong passcodes; try again later")
+	}
+	if len(room.Spectators) >= maxSpectators {
+		return createErrorResponseWithStatus(http.StatusConflict, "too many spectators")
+	}
+
+	token, tokenHash, err := newSpectatorToken()
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "could not issue spectator token")
+	}
+	spectatorID := uuid.New().String()
+	spectator := Spectator{Name: req.Name, TokenHash: tokenHash, JoinedAt: time.Now().Unix()}
+	err = addSpectator(ctx, svc, roomID, spectatorID, spectator)
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	jsonResponse, err := json.Marshal(responseBody{SpectatorID: spectatorID, Name: req.Name, SpectatorToken: token})
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// spectators を持たないルームでは先に空のマップを作る
+func addSpectator(ctx context.Context, svc *dynamodb.Client, roomID, spectatorID string, spectator Spectator) error {
+	key := map[string]types.AttributeValue{
+		"room_id": &types.AttributeValueMemberS{Value: roomID},
+	}
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(appCfg.RoomTableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET spectators = if_not_exists(spectators, :empty)"),
+		ConditionExpression: aws.String("attribute_exists(room_id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	av, err := attributevalue.Marshal(spectator)
+	if err != nil {
+		return err
+	}
+	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(appCfg.RoomTableName),
+		Key:              key,
+		UpdateExpression: aws.String("SET spectators.#id = :spectator"),
+		ExpressionAttributeNames: map[string]string{
+			"#id": spectatorID,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":spectator": av,
+		},
+	})
+	return err
+}
+
+// 閲覧用トークンを発行し、平文とハッシュを返す
+func newSpectatorToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.PasscodeAttemptTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
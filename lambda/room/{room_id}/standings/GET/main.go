@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+type UserData struct {
+	UserID   string `json:"user_id" dynamodbav:"user_id"`
+	NickName string `json:"nickname" dynamodbav:"nickname"`
+	RoomID   string `json:"room_id" dynamodbav:"room_id"`
+}
+
+type RoomData struct {
+	RoomID       string   `json:"room_id" dynamodbav:"room_id"`
+	Status       string   `json:"status" dynamodbav:"status"`
+	Participants []string `json:"participants" dynamodbav:"participants"`
+	Settings     struct {
+		Rounds int `json:"rounds" dynamodbav:"rounds"`
+	} `json:"settings" dynamodbav:"settings"`
+	Round        int            `json:"round" dynamodbav:"round"`
+	RoundResults []RoundResult  `json:"round_results" dynamodbav:"round_results"`
+	Scores       map[string]int `json:"scores" dynamodbav:"scores"`
+}
+
+const roomStatusFinished = "finished"
+
+type RoundResult struct {
+	Round         int            `json:"round" dynamodbav:"round"`
+	SantaID       string         `json:"santa_id" dynamodbav:"santa_id"`
+	QuestionID    int            `json:"question_id" dynamodbav:"question_id"`
+	SantaSurvived bool           `json:"santa_survived" dynamodbav:"santa_survived"`
+	Points        map[string]int `json:"points" dynamodbav:"points"`
+}
+
+// round を持たない古いルームは1ラウンド目として扱う
+func (r RoomData) currentRound() int {
+	return max(r.Round, 1)
+}
+
+func (r RoomData) totalRounds() int {
+	return max(r.Settings.Rounds, 1)
+}
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	room, found, err := getRoom(ctx, svc, roomID)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
+	}
+	players, err := getPlayers(ctx, svc, room)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+
+	jsonResponse, err := json.Marshal(buildStandings(room, players))
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+type standing struct {
+	Rank     int    `json:"rank"`
+	UserID   string `json:"user_id"`
+	NickName string `json:"nickname"`
+	Score    int    `json:"score"`
+}
+
+type standingsResponse struct {
+	RoomID       string        `json:"room_id"`
+	Status       string        `json:"status"`
+	Round        int           `json:"round"`
+	Rounds       int           `json:"rounds"`
+	Finished     bool          `json:"finished"`
+	Standings    []standing    `json:"standings"`
+	RoundResults []RoundResult `json:"round_results"`
+}
+
+// 累計点の高い順に並べる。同点は同じ順位にする
+func buildStandings(room RoomData, players []UserData) standingsResponse {
+	resp := standingsResponse{
+		RoomID:       room.RoomID,
+		Status:       room.Status,
+		Round:        room.currentRound(),
+		Rounds:       room.totalRounds(),
+		Finished:     room.Status == roomStatusFinished,
+		Standings:    []standing{},
+		RoundResults: room.RoundResults,
+	}
+	if resp.RoundResults == nil {
+		resp.RoundResults = []RoundResult{}
+	}
+	for _, p := range players {
+		resp.Standings = append(resp.Standings, standing{UserID: p.UserID, NickName: p.NickName, Score: room.Scores[p.UserID]})
+	}
+	sort.SliceStable(resp.Standings, func(i, j int) bool {
+		return resp.Standings[i].Score > resp.Standings[j].Score
+	})
+	for i := range resp.Standings {
+		if i > 0 && resp.Standings[i].Score == resp.Standings[i-1].Score {
+			resp.Standings[i].Rank = resp.Standings[i-1].Rank
+		} else {
+			resp.Standings[i].Rank = i + 1
+		}
+	}
+	return resp
+}
+
+func getPlayers(ctx context.Context, svc *dynamodb.Client, room RoomData) ([]UserData, error) {
+	var players []UserData
+	for _, participant := range room.Participants {
+		result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(appCfg.UserTableName),
+			Key: map[string]types.AttributeValue{
+				"user_id": &types.AttributeValueMemberS{Value: participant},
+			},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.Item == nil {
+			continue
+		}
+		var user UserData
+		if err = attributevalue.UnmarshalMap(result.Item, &user); err != nil {
+			return nil, err
+		}
+		players = append(players, user)
+	}
+	return players, nil
+}
+
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
+	}
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
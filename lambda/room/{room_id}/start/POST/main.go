@@ -5,9 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"log"
+	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"time"
 
@@ -18,24 +18,23 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-)
 
-type Answer struct {
-	QuestionID string `json:"question_id" dynamodbav:"question_id"`
-	Answer     bool   `json:"answer" dynamodbav:"answer"`
-}
+	"shared/apigw"
+	"shared/appconfig"
+	"shared/game"
+)
 
 type UserData struct {
-	UserID   string   `json:"user_id" dynamodbav:"user_id"`
-	NickName string   `json:"nickname" dynamodbav:"nickname"`
-	RoomID   string   `json:"room_id" dynamodbav:"room_id"`
-	Answers  []Answer `json:"answers" dynamodbav:"answers"`
+	UserID           string `json:"user_id" dynamodbav:"user_id"`
+	RoomID           string `json:"room_id" dynamodbav:"room_id"`
+	SessionTokenHash string `json:"-" dynamodbav:"session_token_hash"`
 }
 
 type RoomData struct {
-	RoomID       string   `json:"room_id" dynamodbav:"room_id"`
-	Participants []string `json:"participants" dynamodbav:"participants"`
+	game.Room
+	HostID string `json:"host_id" dynamodbav:"host_id"`
 }
+
 type RequestBody struct {
 	UserID string `json:"user_id" dynamodbav:"user_id"`
 }
@@ -45,177 +44,52 @@ type ResponseBody struct {
 	IsSanta             bool   `json:"is_santa"`
 	QuestionID          string `json:"question_id"`
 	QuestionDescription string `json:"question_description"`
+	QuestionLocale      string `json:"question_locale"`
+	Round               int    `json:"round"`
 }
 
 type ErrorResponseBody struct {
 	Message string `json:"message"`
 }
 
-type Question struct {
-	QuestionID int    `json:"question_id" dynamodbav:"question_id"`
-	Statement  string `json:"statement" dynamodbav:"statement"`
-}
-
-type QuestionResponse struct {
-	Questions []Question `json:"questions"`
-}
-
-func getRoomData(cfg aws.Config, ctx context.Context, roomID string) (RoomData, error) {
-	svc := dynamodb.NewFromConfig(cfg)
-	tableName := "CandleBackendRoomTable"
-	if t, exists := os.LookupEnv("USER_TABLE_NAME"); exists {
-		tableName = t
-	}
-
-	response, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+func getRoom(ctx context.Context, svc *dynamodb.Client, roomID string) (RoomData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.RoomTableName),
 		Key: map[string]types.AttributeValue{
 			"room_id": &types.AttributeValueMemberS{Value: roomID},
 		},
-		TableName: aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
 	})
-	if err != nil {
-		return RoomData{}, err
-	}
-
-	var roomData RoomData
-	if err = attributevalue.UnmarshalMap(response.Item, &roomData); err != nil {
-		return RoomData{}, err
-	}
-
-	return roomData, nil
-}
-
-func getAllUserData(cfg aws.Config, ctx context.Context, userIDList []string) ([]UserData, error) {
-	svc := dynamodb.NewFromConfig(cfg)
-	tableName := "CandleBackendUserTable"
-	if t, exists := os.LookupEnv("USER_TABLE_NAME"); exists {
-		tableName = t
-	}
-
-	var allUserInfo []UserData
-	for _, userID := range userIDList {
-		var userInfo UserData
-		response, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
-			Key: map[string]types.AttributeValue{
-				"user_id": &types.AttributeValueMemberS{Value: userID},
-			},
-			TableName: aws.String(tableName),
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		if response.Item == nil {
-			return nil, errors.New("User data response is empty")
-		}
-
-		if err = attributevalue.UnmarshalMap(response.Item, &userInfo); err != nil {
-			return nil, err
-		}
-
-		allUserInfo = append(allUserInfo, userInfo)
-	}
-
-	if len(allUserInfo) <= 2 {
-		return nil, errors.New("Game cannot start because there are not enough participants.")
-	}
-	return allUserInfo, nil
-
-}
-func ReturnSantaCandidateList(users []UserData) ([]UserData, error) {
-	//サンタ疑惑のあるユーザーリストの返却
-	falseCountByUser := make(map[string]int)
-	var santaCandidateList []UserData
-	maxFalseCount := -1
-	for _, user := range users {
-		for _, answer := range user.Answers {
-			if !answer.Answer {
-				falseCountByUser[user.UserID]++
-			}
-		}
-		if falseCountByUser[user.UserID] > maxFalseCount {
-			santaCandidateList = []UserData{user}
-			maxFalseCount = falseCountByUser[user.UserID]
-		} else if falseCountByUser[user.UserID] == maxFalseCount {
-			santaCandidateList = append(santaCandidateList, user)
-		}
-	}
-
-	if len(santaCandidateList) == 0 {
-		return nil, errors.New("Game cannot start because there are not enough participants.")
-	}
-	return santaCandidateList, nil
-}
-
-func returnNumberOfTrueForEachQuestion(userData []UserData) map[string]int {
-	//各QuestionのTrueの回答を集計
-	totalCount := make(map[string]int)
-	for _, user := range userData {
-		for _, ans := range user.Answers {
-			key := ans.QuestionID
-			if ans.Answer {
-				totalCount[key]++
-			}
-		}
-	}
-	return totalCount
-}
-
-func extractionSantaFalseQueID(userData []UserData, santaID string) []string {
-	//サンタの回答がFalseの質問を列挙する
-	var santaTrueQueList []string
-	for _, user := range userData {
-		if user.UserID != santaID {
-			continue
-		}
-
-		for _, ans := range user.Answers {
-			if ans.Answer {
-				continue
-			}
-
-			santaTrueQueList = append(santaTrueQueList, ans.QuestionID)
-		}
+	if err != nil || result.Item == nil {
+		return RoomData{}, false, err
 	}
-	return santaTrueQueList
+	var room RoomData
+	err = attributevalue.UnmarshalMap(result.Item, &room)
+	return room, true, err
 }
 
-func carefullySelectionOfTrueAnsTwoOrMore(userData []UserData, eachQueCount map[string]int, santaID string) []string {
-	//質問のTrue回答が2以上のものに修正
-	santaFalseQueList := extractionSantaFalseQueID(userData, santaID)
-
-	var twoOrMoreQueIDList []string
-	for key, count := range eachQueCount {
-		for _, santaTrueQue := range santaFalseQueList {
-			if count >= 2 && key == santaTrueQue {
-				//質問のカウントが2以上＆その質問がサンタが答えれなかったもの
-				twoOrMoreQueIDList = append(twoOrMoreQueIDList, key)
-			}
-		}
+func getUser(ctx context.Context, svc *dynamodb.Client, userID string) (UserData, bool, error) {
+	result, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(appCfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return UserData{}, false, err
 	}
-	return twoOrMoreQueIDList
+	var user UserData
+	err = attributevalue.UnmarshalMap(result.Item, &user)
+	return user, true, err
 }
 
-func decidingSanta(santaCandidateList []UserData, allUserData []UserData) string {
-	trueQueMap := returnNumberOfTrueForEachQuestion(allUserData)
-
-	var maxTrueCount int
-	maxTrueCount = -1
-	var santaUserID string
-
-	for _, santaData := range santaCandidateList {
-		for _, answer := range santaData.Answers {
-			trueCount := trueQueMap[answer.QuestionID]
-			if trueCount == 0 {
-				continue
-			}
-			if (!answer.Answer) && (trueCount > maxTrueCount) {
-				maxTrueCount = trueCount
-				santaUserID = santaData.UserID
-			}
+func isParticipant(room RoomData, userID string) bool {
+	for _, p := range room.Participants {
+		if p == userID {
+			return true
 		}
 	}
-	return santaUserID
+	return false
 }
 
 func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
@@ -226,47 +100,16 @@ func createErrorResponseWithStatus(statusCode int, responseMessage string) (even
 	return events.APIGatewayProxyResponse{
 		Body:       string(json),
 		StatusCode: statusCode,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
-func getQuestionDescriptionFromQuestionID(cfg aws.Config, ctx context.Context, questionID int) (string, error) {
-	tableName := "CandleBackendQuestionTable"
-	if t, exists := os.LookupEnv("QUESTION_TABLE_NAME"); exists {
-		tableName = t
-	}
-
-	svc := dynamodb.NewFromConfig(cfg)
-
-	response, err := svc.Scan(ctx, &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-	})
-	if err != nil {
-		fmt.Println("Error scanning DynamoDB table:", err)
-		return "", err
-	}
-
-	var queRes []Question
-	err = attributevalue.UnmarshalListOfMaps(response.Items, &queRes)
-	if err != nil {
-		fmt.Println("Error unmarshaling DynamoDB response:", err)
-		return "", err
-	}
-
-	for _, que := range queRes {
-		if que.QuestionID == questionID {
-			return que.Statement, nil
-		}
-	}
-	return "", errors.New("Invalid QuestionID")
-}
-
 func addIsSantaColumn(cfg aws.Config, ctx context.Context, userID string, isSanta bool) error {
 	//サンタだった場合にUserTableを更新
 	svc := dynamodb.NewFromConfig(cfg)
 
 	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String("CandleBackendUserTable"),
+		TableName: aws.String(appCfg.UserTableName),
 		Key: map[string]types.AttributeValue{
 			"user_id": &types.AttributeValueMemberS{Value: userID},
 		},
@@ -281,87 +124,110 @@ func addIsSantaColumn(cfg aws.Config, ctx context.Context, userID string, isSant
 	return nil
 }
 
+// ゲームを始めるか、進行中のラウンドで呼んだプレイヤーの役割と質問を返す。
+// ロビーから始められるのはホストだけ。始まった後は参加者なら誰でも自分の役割を取得できる
 func gameStartHandler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	roomID := event.PathParameters["room_id"]
-	if roomID == "" {
-		return createErrorResponseWithStatus(400, "Incorrect path parameter")
-	}
-	roomID, err := url.PathUnescape(roomID)
-	if err != nil {
-		return createErrorResponseWithStatus(500, "could not decode room_id")
+	roomID, err := url.PathUnescape(event.PathParameters["room_id"])
+	if err != nil || roomID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "Incorrect path parameter")
 	}
 
 	var req RequestBody
-	if err := json.Unmarshal([]byte(event.Body), &req); err != nil {
-		return createErrorResponseWithStatus(500, "JSON parse error")
+	if err := json.Unmarshal([]byte(event.Body), &req); err != nil || req.UserID == "" {
+		return createErrorResponseWithStatus(http.StatusBadRequest, "JSON parse error")
+	}
+	token, ok := apigw.BearerToken(event)
+	if !ok {
+		return createErrorResponseWithStatus(http.StatusUnauthorized, "missing session token")
 	}
 
 	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
-		return createErrorResponseWithStatus(500, "Internal server error")
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
 	}
+	svc := dynamodb.NewFromConfig(cfg)
 
+	room, found, err := getRoom(ctx, svc, roomID)
 	if err != nil {
-		return createErrorResponseWithStatus(500, err.Error())
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
 	}
-
-	roomResult, err := getRoomData(cfg, ctx, roomID)
-	if err != nil {
-		return createErrorResponseWithStatus(500, "DB get error")
+	if !found {
+		return createErrorResponseWithStatus(http.StatusNotFound, "room not found")
 	}
-
-	allUserData, err := getAllUserData(cfg, ctx, roomResult.Participants)
+	user, found, err := getUser(ctx, svc, req.UserID)
 	if err != nil {
-		return createErrorResponseWithStatus(500, err.Error())
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
 	}
-
-	santaCandidateList, err := ReturnSantaCandidateList(allUserData)
-	if err != nil {
-		return createErrorResponseWithStatus(500, err.Error())
+	if !found || user.RoomID != roomID || !isParticipant(room, req.UserID) || !apigw.MatchSessionToken(token, user.SessionTokenHash) {
+		return createErrorResponseWithStatus(http.StatusForbidden, "not a player of this room")
 	}
-	santaUserID := decidingSanta(santaCandidateList, allUserData)
 
-	var responseBody ResponseBody
-	//先ほど取得したサンタのuser_idとリクエストボディのuser_idが一致したらサンタである
-	if santaUserID == req.UserID {
-		responseBody.IsSanta = true
-	} else {
-		responseBody.IsSanta = false
+	if room.Status == game.StatusFinished {
+		return createErrorResponseWithStatus(http.StatusConflict, "The game is over")
 	}
-	addIsSantaColumn(cfg, ctx, req.UserID, responseBody.IsSanta)
-	trueQueMap := returnNumberOfTrueForEachQuestion(allUserData)
-	twoOrMoreQueIDList := carefullySelectionOfTrueAnsTwoOrMore(allUserData, trueQueMap, santaUserID)
-
-	if len(twoOrMoreQueIDList) == 0 {
-		return createErrorResponseWithStatus(500, "Unable to start game due to question answer status")
+	if room.Status == "" || room.Status == game.StatusLobby {
+		// ホストのいない古いルームでは参加者なら誰でも始められる
+		if room.HostID != "" && room.HostID != req.UserID {
+			return createErrorResponseWithStatus(http.StatusForbidden, "only the host can start the game")
+		}
+		// 人数の設定がない古いルームは既定値を使う
+		minPlayers := room.Settings.MinPlayers
+		if minPlayers == 0 {
+			minPlayers = appCfg.Game.MinPlayers
+		}
+		if len(room.Participants) < minPlayers {
+			return createErrorResponseWithStatus(http.StatusConflict, "Game cannot start because there are not enough participants.")
+		}
 	}
 
-	//回答者が2以上のquestion_idをリストアップして、その長さ分ランダムな整数値を生成し、その数字をインデックスにしてquestion_idを決定
-	rand.Seed(time.Now().UnixNano())
-	randNum := rand.Intn(len(twoOrMoreQueIDList))
-	responseBody.QuestionID = twoOrMoreQueIDList[randNum]
-
-	intQueID, err := strconv.Atoi(responseBody.QuestionID)
-	if err != nil {
-		return createErrorResponseWithStatus(500, err.Error())
+	// 同時に開始したプレイヤーとは同じサンタと質問を使う
+	locked, err := game.LockRound(ctx, svc, appCfg, roomID, time.Now())
+	if errors.Is(err, game.ErrGameOver) {
+		return createErrorResponseWithStatus(http.StatusConflict, "The game is over")
+	}
+	if errors.Is(err, game.ErrUnansweredPlayers) {
+		// クイック参加したプレイヤーが回答を送るまで待つ
+		return createErrorResponseWithStatus(http.StatusConflict, "Game cannot start until every participant has answered the questions.")
+	}
+	if errors.Is(err, game.ErrNoCandidates) {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Unable to start game due to question answer status")
 	}
-	description, err := getQuestionDescriptionFromQuestionID(cfg, ctx, intQueID)
 	if err != nil {
-		return createErrorResponseWithStatus(500, err.Error())
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
+	}
+
+	var responseBody ResponseBody
+	//固定されたサンタの user_id とリクエストボディの user_id が一致したらサンタである
+	responseBody.IsSanta = locked.Room.SantaID == req.UserID
+	if err = addIsSantaColumn(cfg, ctx, req.UserID, responseBody.IsSanta); err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB write error")
 	}
 
 	responseBody.UserID = req.UserID
-	responseBody.QuestionDescription = description
+	responseBody.Round = locked.Room.CurrentRound()
+	responseBody.QuestionID = strconv.Itoa(locked.Question.QuestionID)
+	responseBody.QuestionDescription, responseBody.QuestionLocale = apigw.Localize(locked.Question.Statement, locked.Question.Statements, apigw.PreferredLocales(event, appCfg.DefaultLocale), appCfg.DefaultLocale)
 
 	json, _ := json.Marshal(responseBody)
 
 	return events.APIGatewayProxyResponse{
 		Body:       string(json),
 		StatusCode: 200,
-		Headers:    map[string]string{"Content-Type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"Content-Type": "application/json"},
 	}, nil
 }
 
+var appCfg appconfig.Config
+
 func main() {
-	lambda.Start(gameStartHandler)
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable, appconfig.UserTable, appconfig.QuestionTable, appconfig.PackTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, gameStartHandler))
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+	"shared/appconfig"
+)
+
+type RoomData struct {
+	RoomID       string   `json:"room_id" dynamodbav:"room_id"`
+	Name         string   `json:"name" dynamodbav:"name"`
+	Status       string   `json:"status" dynamodbav:"status"`
+	Participants []string `json:"participants" dynamodbav:"participants"`
+	Settings     struct {
+		MaxPlayers int    `json:"max_players" dynamodbav:"max_players"`
+		Language   string `json:"language" dynamodbav:"language"`
+	} `json:"settings" dynamodbav:"settings"`
+}
+
+type publicRoom struct {
+	RoomID      string `json:"room_id"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	PlayerCount int    `json:"player_count"`
+	MaxPlayers  int    `json:"max_players"`
+	Language    string `json:"language"`
+}
+
+type roomsResponse struct {
+	Rooms []publicRoom `json:"rooms"`
+}
+
+const (
+	roomStatusLobby    = "lobby"
+	roomStatusFinished = "finished"
+)
+
+func handler(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	language := strings.ToLower(event.QueryStringParameters["lang"])
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "Internal server error")
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+
+	rooms, err := getPublicRooms(ctx, svc, language)
+	if err != nil {
+		fmt.Println(err.Error())
+		return createErrorResponseWithStatus(http.StatusInternalServerError, "DB get error")
+	}
+
+	resp := roomsResponse{Rooms: []publicRoom{}}
+	for _, room := range rooms {
+		r := publicRoom{
+			RoomID:      room.RoomID,
+			Name:        room.Name,
+			Status:      room.Status,
+			PlayerCount: len(room.Participants),
+			MaxPlayers:  room.capacity(),
+			Language:    room.Settings.Language,
+		}
+		if r.Name == "" {
+			r.Name = room.RoomID
+		}
+		if r.Status == "" {
+			r.Status = roomStatusLobby
+		}
+		resp.Rooms = append(resp.Rooms, r)
+	}
+	// 参加できるロビーを先に、人の多い順に並べる
+	sort.SliceStable(resp.Rooms, func(i, j int) bool {
+		a, b := resp.Rooms[i], resp.Rooms[j]
+		if (a.Status == roomStatusLobby) != (b.Status == roomStatusLobby) {
+			return a.Status == roomStatusLobby
+		}
+		return a.PlayerCount > b.PlayerCount
+	})
+
+	jsonResponse, err := json.Marshal(resp)
+	if err != nil {
+		return createErrorResponseWithStatus(http.StatusInternalServerError, err.Error())
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(jsonResponse),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+// 公開ルームだけが public_language を持つ疎なインデックス
+const publicRoomIndex = "PublicRoomIndex"
+
+// 言語を指定すればその言語の公開ルームを、しなければすべての公開ルームを返す。終わったルームは除く
+func getPublicRooms(ctx context.Context, svc *dynamodb.Client, language string) ([]RoomData, error) {
+	var rooms []RoomData
+	names := map[string]string{"#status": "status"}
+	values := map[string]types.AttributeValue{
+		":finished": &types.AttributeValueMemberS{Value: roomStatusFinished},
+	}
+	filter := aws.String("attribute_not_exists(#status) OR #status <> :finished")
+	var lastKey map[string]types.AttributeValue
+	for {
+		var items []map[string]types.AttributeValue
+		if language != "" {
+			values[":language"] = &types.AttributeValueMemberS{Value: language}
+			out, err := svc.Query(ctx, &dynamodb.QueryInput{
+				TableName:                 aws.String(appCfg.RoomTableName),
+				IndexName:                 aws.String(publicRoomIndex),
+				KeyConditionExpression:    aws.String("public_language = :language"),
+				FilterExpression:          filter,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				ExclusiveStartKey:         lastKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			items, lastKey = out.Items, out.LastEvaluatedKey
+		} else {
+			out, err := svc.Scan(ctx, &dynamodb.ScanInput{
+				TableName:                 aws.String(appCfg.RoomTableName),
+				IndexName:                 aws.String(publicRoomIndex),
+				FilterExpression:          filter,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				ExclusiveStartKey:         lastKey,
+			})
+			if err != nil {
+				return nil, err
+			}
+			items, lastKey = out.Items, out.LastEvaluatedKey
+		}
+		var page []RoomData
+		if err := attributevalue.UnmarshalListOfMaps(items, &page); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, page...)
+		if lastKey == nil {
+			return rooms, nil
+		}
+	}
+}
+
+// 定員の設定がない古いルームは既定値を使う
+func (r RoomData) capacity() int {
+	if r.Settings.MaxPlayers > 0 {
+		return r.Settings.MaxPlayers
+	}
+	return appCfg.Game.MaxPlayers
+}
+
+type ErrorResponseBody struct {
+	Message string `json:"message"`
+}
+
+func createErrorResponseWithStatus(statusCode int, responseMessage string) (events.APIGatewayProxyResponse, error) {
+	body := ErrorResponseBody{
+		Message: responseMessage,
+	}
+	json, _ := json.Marshal(body)
+	return events.APIGatewayProxyResponse{
+		Body:       string(json),
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}, nil
+}
+
+var appCfg appconfig.Config
+
+func main() {
+	var err error
+	appCfg, err = appconfig.Load(appconfig.RoomTable)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	lambda.Start(apigw.WithCORS(appCfg, handler))
+}
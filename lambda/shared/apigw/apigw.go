@@ -0,0 +1,50 @@
+// apigw は API Gateway から呼ばれる Lambda に共通の処理をまとめる
+package apigw
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"shared/appconfig"
+)
+
+type Handler func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// 許可したオリジンからのリクエストにだけ Access-Control-Allow-Origin を返す
+func WithCORS(cfg appconfig.Config, h Handler) Handler {
+	return func(ctx context.Context, event events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		resp, err := h(ctx, event)
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		origin := cfg.AllowOrigin(RequestHeader(event, "Origin"))
+		resp.Headers["Access-Control-Allow-Origin"] = origin
+		if origin != "*" {
+			if vary := resp.Headers["Vary"]; vary != "" {
+				resp.Headers["Vary"] = vary + ", Origin"
+			} else {
+				resp.Headers["Vary"] = "Origin"
+			}
+		}
+		return resp, err
+	}
+}
+
+// ヘッダー名は大文字と小文字を区別しない
+func RequestHeader(event events.APIGatewayProxyRequest, name string) string {
+	for k, v := range event.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// Authorization: Bearer <token> のトークン
+func BearerToken(event events.APIGatewayProxyRequest) (string, bool) {
+	token, ok := strings.CutPrefix(RequestHeader(event, "Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	return token, ok && token != ""
+}
@@ -0,0 +1,66 @@
+package apigw
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ?lang= と Accept-Language から希望するロケールを優先順に並べる (最後は既定のロケール)
+func PreferredLocales(event events.APIGatewayProxyRequest, defaultLocale string) []string {
+	var tags []string
+	if lang := event.QueryStringParameters["lang"]; lang != "" {
+		tags = append(tags, lang)
+	}
+
+	type weightedTag struct {
+		tag string
+		q   float64
+	}
+	var accepted []weightedTag
+	for _, part := range strings.Split(RequestHeader(event, "Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, weightedTag{tag: tag, q: q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	for _, a := range accepted {
+		tags = append(tags, a.tag)
+	}
+
+	// en-US の翻訳が無ければ en を使う
+	var locales []string
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		locales = append(locales, tag)
+		if base, _, ok := strings.Cut(tag, "-"); ok {
+			locales = append(locales, base)
+		}
+	}
+	return append(locales, defaultLocale)
+}
+
+// 希望するロケールのうち翻訳がある最初のものを選ぶ。どれも無ければ statement を既定のロケールとして返す
+func Localize(statement string, statements map[string]string, locales []string, defaultLocale string) (string, string) {
+	for _, l := range locales {
+		if s := statements[l]; s != "" {
+			return s, l
+		}
+	}
+	return statement, defaultLocale
+}
@@ -0,0 +1,40 @@
+package apigw
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestLocalize(t *testing.T) {
+	statements := map[string]string{"ja": "料理は好きですか？", "en": "Do you like cooking?"}
+	tests := []struct {
+		name        string
+		lang        string
+		accept      string
+		wantLocales []string
+		wantLocale  string
+	}{
+		{name: "lang wins over Accept-Language", lang: "en", accept: "ja", wantLocales: []string{"en", "ja", "ja"}, wantLocale: "en"},
+		{name: "highest q first", accept: "fr;q=0.5, en-US;q=0.9", wantLocales: []string{"en-us", "en", "fr", "ja"}, wantLocale: "en"},
+		{name: "zero q and wildcard are skipped", accept: "en;q=0, *", wantLocales: []string{"ja"}, wantLocale: "ja"},
+		{name: "no translation falls back to the default", accept: "de", wantLocales: []string{"de", "ja"}, wantLocale: "ja"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := events.APIGatewayProxyRequest{
+				Headers:               map[string]string{"accept-language": tt.accept},
+				QueryStringParameters: map[string]string{"lang": tt.lang},
+			}
+			locales := PreferredLocales(event, "ja")
+			if !slices.Equal(locales, tt.wantLocales) {
+				t.Errorf("PreferredLocales = %v, want %v", locales, tt.wantLocales)
+			}
+			statement, locale := Localize("料理は好きですか？", statements, locales, "ja")
+			if locale != tt.wantLocale || statement != statements[tt.wantLocale] {
+				t.Errorf("Localize = %q, %q, want the %q statement", statement, locale, tt.wantLocale)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package apigw
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// セッショントークンを発行し、平文とハッシュを返す。DB にはハッシュだけを保存する
+func NewSessionToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashSecret(token), nil
+}
+
+// セッショントークンや招待の秘密を保存するときのハッシュ (SHA-256 の16進)
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// token のハッシュが保存されたハッシュと一致するか。定数時間で比べ、ハッシュが無ければ一致しない
+func MatchSessionToken(token, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashSecret(token)), []byte(hash)) == 1
+}
+
+// Authorization: Bearer <session_token> が参加時に発行したトークンと一致するか
+func ValidSession(event events.APIGatewayProxyRequest, hash string) bool {
+	token, ok := BearerToken(event)
+	return ok && MatchSessionToken(token, hash)
+}
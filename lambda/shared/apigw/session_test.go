@@ -0,0 +1,36 @@
+package apigw
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestValidSession(t *testing.T) {
+	token, hash, err := NewSessionToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(authorization string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"authorization": authorization}}
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		hash          string
+		want          bool
+	}{
+		{name: "matching token", authorization: "Bearer " + token, hash: hash, want: true},
+		{name: "another token", authorization: "Bearer " + token + "x", hash: hash},
+		{name: "missing bearer", authorization: token, hash: hash},
+		{name: "player without a token", authorization: "Bearer " + token},
+		{name: "empty token against an empty hash", authorization: "Bearer ", hash: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidSession(request(tt.authorization), tt.hash); got != tt.want {
+				t.Errorf("ValidSession = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// apikey は運営用 API の API キーを確かめる。キーは tools/apikey で発行する
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/apigw"
+)
+
+// API キーに付けられる権限
+const (
+	ScopeQuestionsWrite = "questions:write"
+	ScopeRoomsAdmin     = "rooms:admin"
+)
+
+// API キーは "<key_id>.<secret>" の形で渡される。secret はハッシュだけを保存する
+type Key struct {
+	KeyID      string   `dynamodbav:"key_id"`
+	SecretHash string   `dynamodbav:"secret_hash"`
+	Scopes     []string `dynamodbav:"scopes,stringset"`
+	RevokedAt  int64    `dynamodbav:"revoked_at,omitempty"`
+}
+
+// Check が使う DynamoDB の操作。テストでは差し替えられる
+type DB interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// Bearer で渡された API キーが scope を持つか確かめる。通さないときは返すステータスとメッセージを返す
+func Check(ctx context.Context, db DB, tableName string, event events.APIGatewayProxyRequest, scope string) (int, string) {
+	token, ok := apigw.BearerToken(event)
+	if !ok {
+		return http.StatusUnauthorized, "missing API key"
+	}
+	keyID, secret, ok := strings.Cut(token, ".")
+	if !ok || keyID == "" || secret == "" {
+		return http.StatusUnauthorized, "invalid API key"
+	}
+	result, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"key_id": &types.AttributeValueMemberS{Value: keyID},
+		},
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return http.StatusInternalServerError, "DB get error"
+	}
+	if result.Item == nil {
+		return http.StatusUnauthorized, "invalid API key"
+	}
+	var key Key
+	if err = attributevalue.UnmarshalMap(result.Item, &key); err != nil {
+		fmt.Println(err.Error())
+		return http.StatusInternalServerError, "DB get error"
+	}
+	// secret は十分に長い乱数なので、ソルトなしの SHA-256 で比べる
+	sum := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(key.SecretHash)) != 1 || key.RevokedAt != 0 {
+		return http.StatusUnauthorized, "invalid API key"
+	}
+	if !slices.Contains(key.Scopes, scope) {
+		return http.StatusForbidden, fmt.Sprintf("API key lacks the %s scope", scope)
+	}
+
+	// 使われていないキーを CLI で棚卸しできるようにする。記録に失敗しても認証は通す
+	_, err = db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"key_id": &types.AttributeValueMemberS{Value: keyID},
+		},
+		UpdateExpression: aws.String("SET last_used_at = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	return http.StatusOK, ""
+}
@@ -0,0 +1,85 @@
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type fakeDB struct {
+	keys    map[string]Key
+	getErr  error
+	touched []string
+}
+
+func (f *fakeDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	keyID := params.Key["key_id"].(*types.AttributeValueMemberS).Value
+	key, ok := f.keys[keyID]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	item, err := attributevalue.MarshalMap(key)
+	return &dynamodb.GetItemOutput{Item: item}, err
+}
+
+func (f *fakeDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.touched = append(f.touched, params.Key["key_id"].(*types.AttributeValueMemberS).Value)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCheck(t *testing.T) {
+	keys := map[string]Key{
+		"staff":   {KeyID: "staff", SecretHash: hashSecret("s3cret"), Scopes: []string{ScopeRoomsAdmin}},
+		"editor":  {KeyID: "editor", SecretHash: hashSecret("s3cret"), Scopes: []string{ScopeQuestionsWrite}},
+		"revoked": {KeyID: "revoked", SecretHash: hashSecret("s3cret"), Scopes: []string{ScopeRoomsAdmin}, RevokedAt: 1},
+	}
+	tests := []struct {
+		name          string
+		authorization string
+		getErr        error
+		wantStatus    int
+		wantTouched   bool
+	}{
+		{name: "valid key with the scope", authorization: "Bearer staff.s3cret", wantStatus: http.StatusOK, wantTouched: true},
+		{name: "missing header", wantStatus: http.StatusUnauthorized},
+		{name: "not a bearer token", authorization: "Basic staff.s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "no secret", authorization: "Bearer staff", wantStatus: http.StatusUnauthorized},
+		{name: "unknown key", authorization: "Bearer nobody.s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "wrong secret", authorization: "Bearer staff.guess", wantStatus: http.StatusUnauthorized},
+		{name: "revoked key", authorization: "Bearer revoked.s3cret", wantStatus: http.StatusUnauthorized},
+		{name: "key without the scope", authorization: "Bearer editor.s3cret", wantStatus: http.StatusForbidden},
+		{name: "DB error", authorization: "Bearer staff.s3cret", getErr: errors.New("boom"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{keys: keys, getErr: tt.getErr}
+			event := events.APIGatewayProxyRequest{Headers: map[string]string{}}
+			if tt.authorization != "" {
+				event.Headers["authorization"] = tt.authorization
+			}
+			status, _ := Check(context.Background(), db, "keys", event, ScopeRoomsAdmin)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if touched := len(db.touched) > 0; touched != tt.wantTouched {
+				t.Errorf("last_used_at updated = %v, want %v", touched, tt.wantTouched)
+			}
+		})
+	}
+}
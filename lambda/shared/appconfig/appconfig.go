@@ -0,0 +1,258 @@
+// appconfig は全ての Lambda が使う設定を環境変数から読み込む
+package appconfig
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type GameRules struct {
+	MinPlayers int
+	// ルームに入れる人数の既定値で、設定できる上限でもある
+	MaxPlayers     int
+	MinTrueAnswers int
+	QuestionCount  int
+	// 1つのルームで遊ぶラウンド数
+	Rounds int
+	// ニックネームの最大文字数 (rune 単位)
+	NickNameMaxLength int
+	// ニックネームに含めてはいけない語
+	NickNameBlocklist []string
+}
+
+type Config struct {
+	RoomTableName     string
+	UserTableName     string
+	QuestionTableName string
+	PackTableName     string
+	APIKeyTableName   string
+	// 合言葉を間違えた回数を接続元ごとに数えるテーブル
+	PasscodeAttemptTableName string
+	RoomTTL                  time.Duration
+	QuestionCacheTTL         time.Duration
+	CORSAllowOrigins         []string
+	DefaultLocale            string
+	// 最後のハートビートからこの時間が過ぎたプレイヤーを finalizer が離脱扱いにする。
+	// ハートビートを送らないクライアントもあるので、0 (既定) なら誰も離脱扱いにしない
+	PlayerIdleTimeout time.Duration
+	// 各フェーズの締め切り。過ぎたルームは定期実行の finalizer が進める
+	LobbyTimeout  time.Duration
+	VotingTimeout time.Duration
+	// WebSocket サーバーの通知エンドポイント。空なら通知しない
+	NotifyURL   string
+	NotifyToken string
+	// 合言葉をこの回数間違えた接続元からは PasscodeLockout の間そのルームへの参加を受け付けない
+	PasscodeMaxAttempts int
+	// 接続元を変えながらの総当たりに備え、ルーム全体でこの回数間違えたら誰の合言葉も確かめない
+	PasscodeRoomMaxAttempts int
+	PasscodeLockout         time.Duration
+	// 招待トークンの有効期限を指定しなかったときの既定値
+	InviteTTL time.Duration
+	// QR コードに埋め込む参加ページの URL。room_id と invite_token をクエリに付ける
+	JoinURL string
+	Game    GameRules
+}
+
+// Lambda が使う DynamoDB テーブル。Load に渡したテーブルの名前だけを必須にする
+type Table int
+
+const (
+	RoomTable Table = iota
+	UserTable
+	QuestionTable
+	PackTable
+	APIKeyTable
+	PasscodeAttemptTable
+)
+
+func (c *Config) tableName(t Table) (string, *string) {
+	switch t {
+	case RoomTable:
+		return "ROOM_TABLE_NAME", &c.RoomTableName
+	case UserTable:
+		return "USER_TABLE_NAME", &c.UserTableName
+	case QuestionTable:
+		return "QUESTION_TABLE_NAME", &c.QuestionTableName
+	case PackTable:
+		return "PACK_TABLE_NAME", &c.PackTableName
+	case APIKeyTable:
+		return "API_KEY_TABLE_NAME", &c.APIKeyTableName
+	case PasscodeAttemptTable:
+		return "PASSCODE_ATTEMPT_TABLE_NAME", &c.PasscodeAttemptTableName
+	}
+	panic(fmt.Sprintf("appconfig: unknown table %d", t))
+}
+
+// 環境変数から設定を読み込む。必須項目が欠けていればコールドスタートで失敗させる
+func Load(tables ...Table) (Config, error) {
+	c := Config{
+		RoomTTL:                 12 * time.Hour,
+		QuestionCacheTTL:        5 * time.Minute,
+		LobbyTimeout:            10 * time.Minute,
+		VotingTimeout:           5 * time.Minute,
+		PasscodeLockout:         5 * time.Minute,
+		InviteTTL:               time.Hour,
+		CORSAllowOrigins:        []string{"*"},
+		DefaultLocale:           "ja",
+		PasscodeMaxAttempts:     5,
+		PasscodeRoomMaxAttempts: 50,
+		Game: GameRules{
+			MinPlayers:        3,
+			MaxPlayers:        10,
+			MinTrueAnswers:    2,
+			QuestionCount:     10,
+			Rounds:            1,
+			NickNameMaxLength: 20,
+		},
+	}
+
+	var missing []string
+	for _, t := range tables {
+		name, dst := c.tableName(t)
+		*dst = os.Getenv(name)
+		if *dst == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return c, fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	var err error
+	if c.RoomTTL, err = durationEnv("ROOM_TTL", c.RoomTTL); err != nil {
+		return c, err
+	}
+	if c.QuestionCacheTTL, err = durationEnv("QUESTION_CACHE_TTL", c.QuestionCacheTTL); err != nil {
+		return c, err
+	}
+	if c.PlayerIdleTimeout, err = durationEnv("PLAYER_IDLE_TIMEOUT", c.PlayerIdleTimeout); err != nil {
+		return c, err
+	}
+	if c.LobbyTimeout, err = durationEnv("LOBBY_TIMEOUT", c.LobbyTimeout); err != nil {
+		return c, err
+	}
+	if c.VotingTimeout, err = durationEnv("VOTING_TIMEOUT", c.VotingTimeout); err != nil {
+		return c, err
+	}
+	if c.PasscodeLockout, err = durationEnv("PASSCODE_LOCKOUT", c.PasscodeLockout); err != nil {
+		return c, err
+	}
+	if c.PasscodeMaxAttempts, err = intEnv("PASSCODE_MAX_ATTEMPTS", c.PasscodeMaxAttempts); err != nil {
+		return c, err
+	}
+	if c.PasscodeMaxAttempts == 0 {
+		return c, fmt.Errorf("PASSCODE_MAX_ATTEMPTS must be at least 1")
+	}
+	if c.PasscodeRoomMaxAttempts, err = intEnv("PASSCODE_ROOM_MAX_ATTEMPTS", c.PasscodeRoomMaxAttempts); err != nil {
+		return c, err
+	}
+	if c.PasscodeRoomMaxAttempts < c.PasscodeMaxAttempts {
+		return c, fmt.Errorf("PASSCODE_ROOM_MAX_ATTEMPTS must be at least PASSCODE_MAX_ATTEMPTS (%d)", c.PasscodeMaxAttempts)
+	}
+	if c.InviteTTL, err = durationEnv("INVITE_TTL", c.InviteTTL); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("CORS_ALLOW_ORIGINS"); v != "" {
+		c.CORSAllowOrigins = nil
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				c.CORSAllowOrigins = append(c.CORSAllowOrigins, o)
+			}
+		}
+		if len(c.CORSAllowOrigins) == 0 {
+			return c, fmt.Errorf("CORS_ALLOW_ORIGINS has no origins: %q", v)
+		}
+	}
+
+	c.NotifyURL = os.Getenv("NOTIFY_URL")
+	c.NotifyToken = os.Getenv("NOTIFY_TOKEN")
+	if c.NotifyURL != "" && c.NotifyToken == "" {
+		return c, fmt.Errorf("NOTIFY_TOKEN is required when NOTIFY_URL is set")
+	}
+
+	c.JoinURL = os.Getenv("JOIN_URL")
+	if c.JoinURL != "" {
+		if u, err := url.Parse(c.JoinURL); err != nil || !u.IsAbs() {
+			return c, fmt.Errorf("JOIN_URL must be an absolute URL: %q", c.JoinURL)
+		}
+	}
+
+	if v := os.Getenv("DEFAULT_LOCALE"); v != "" {
+		c.DefaultLocale = strings.ToLower(v)
+	}
+
+	if c.Game.MinPlayers, err = intEnv("MIN_PLAYERS", c.Game.MinPlayers); err != nil {
+		return c, err
+	}
+	if c.Game.MaxPlayers, err = intEnv("MAX_PLAYERS", c.Game.MaxPlayers); err != nil {
+		return c, err
+	}
+	if c.Game.MaxPlayers < c.Game.MinPlayers {
+		return c, fmt.Errorf("MAX_PLAYERS must be at least MIN_PLAYERS (%d)", c.Game.MinPlayers)
+	}
+	if c.Game.MinTrueAnswers, err = intEnv("MIN_TRUE_ANSWERS", c.Game.MinTrueAnswers); err != nil {
+		return c, err
+	}
+	if c.Game.QuestionCount, err = intEnv("QUESTION_COUNT", c.Game.QuestionCount); err != nil {
+		return c, err
+	}
+	if c.Game.QuestionCount == 0 {
+		return c, fmt.Errorf("QUESTION_COUNT must be at least 1")
+	}
+	if c.Game.Rounds, err = intEnv("ROUNDS", c.Game.Rounds); err != nil {
+		return c, err
+	}
+	if c.Game.Rounds == 0 {
+		return c, fmt.Errorf("ROUNDS must be at least 1")
+	}
+	if c.Game.NickNameMaxLength, err = intEnv("NICKNAME_MAX_LENGTH", c.Game.NickNameMaxLength); err != nil {
+		return c, err
+	}
+	if c.Game.NickNameMaxLength == 0 {
+		return c, fmt.Errorf("NICKNAME_MAX_LENGTH must be at least 1")
+	}
+	for _, w := range strings.Split(os.Getenv("NICKNAME_BLOCKLIST"), ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			c.Game.NickNameBlocklist = append(c.Game.NickNameBlocklist, w)
+		}
+	}
+	return c, nil
+}
+
+func durationEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration: %q", name, v)
+	}
+	return d, nil
+}
+
+func intEnv(name string, defaultValue int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer: %q", name, v)
+	}
+	return n, nil
+}
+
+// リクエストの Origin が許可リストにあればそれを返す
+func (c Config) AllowOrigin(origin string) string {
+	for _, o := range c.CORSAllowOrigins {
+		if o == "*" || o == origin {
+			return o
+		}
+	}
+	return c.CORSAllowOrigins[0]
+}
@@ -0,0 +1,134 @@
+package appconfig
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		tables  []Table
+		env     map[string]string
+		wantErr string
+		check   func(t *testing.T, c Config)
+	}{
+		{
+			name:   "only the requested tables are required",
+			tables: []Table{RoomTable, UserTable},
+			env:    map[string]string{"ROOM_TABLE_NAME": "rooms", "USER_TABLE_NAME": "users"},
+			check: func(t *testing.T, c Config) {
+				if c.RoomTableName != "rooms" || c.UserTableName != "users" {
+					t.Errorf("table names = %q, %q", c.RoomTableName, c.UserTableName)
+				}
+				if c.QuestionTableName != "" {
+					t.Errorf("QuestionTableName = %q, want empty", c.QuestionTableName)
+				}
+			},
+		},
+		{
+			name:    "missing table names are listed",
+			tables:  []Table{RoomTable, QuestionTable, APIKeyTable},
+			env:     map[string]string{"ROOM_TABLE_NAME": "rooms"},
+			wantErr: "QUESTION_TABLE_NAME, API_KEY_TABLE_NAME",
+		},
+		{
+			name: "defaults",
+			check: func(t *testing.T, c Config) {
+				if c.RoomTTL != 12*time.Hour || c.VotingTimeout != 5*time.Minute {
+					t.Errorf("RoomTTL = %v, VotingTimeout = %v", c.RoomTTL, c.VotingTimeout)
+				}
+				if c.Game.MinPlayers != 3 || c.Game.MaxPlayers != 10 {
+					t.Errorf("players = %d..%d", c.Game.MinPlayers, c.Game.MaxPlayers)
+				}
+				if len(c.CORSAllowOrigins) != 1 || c.CORSAllowOrigins[0] != "*" {
+					t.Errorf("CORSAllowOrigins = %v", c.CORSAllowOrigins)
+				}
+				// 離脱扱いは明示的に有効にしたときだけ
+				if c.PlayerIdleTimeout != 0 {
+					t.Errorf("PlayerIdleTimeout = %v, want 0", c.PlayerIdleTimeout)
+				}
+			},
+		},
+		{
+			name: "overrides",
+			env: map[string]string{
+				"ROOM_TTL":            "1h",
+				"PLAYER_IDLE_TIMEOUT": "3m",
+				"CORS_ALLOW_ORIGINS":  "https://a.example, https://b.example",
+				"DEFAULT_LOCALE":      "EN",
+				"ROUNDS":              "3",
+				"NICKNAME_BLOCKLIST":  "foo, ,bar",
+			},
+			check: func(t *testing.T, c Config) {
+				if c.RoomTTL != time.Hour || c.PlayerIdleTimeout != 3*time.Minute {
+					t.Errorf("RoomTTL = %v, PlayerIdleTimeout = %v", c.RoomTTL, c.PlayerIdleTimeout)
+				}
+				if strings.Join(c.CORSAllowOrigins, "|") != "https://a.example|https://b.example" {
+					t.Errorf("CORSAllowOrigins = %v", c.CORSAllowOrigins)
+				}
+				if c.DefaultLocale != "en" || c.Game.Rounds != 3 {
+					t.Errorf("DefaultLocale = %q, Rounds = %d", c.DefaultLocale, c.Game.Rounds)
+				}
+				if strings.Join(c.Game.NickNameBlocklist, "|") != "foo|bar" {
+					t.Errorf("NickNameBlocklist = %v", c.Game.NickNameBlocklist)
+				}
+			},
+		},
+		{
+			name:    "room-wide passcode attempts below the per-client limit",
+			env:     map[string]string{"PASSCODE_MAX_ATTEMPTS": "10", "PASSCODE_ROOM_MAX_ATTEMPTS": "5"},
+			wantErr: "PASSCODE_ROOM_MAX_ATTEMPTS",
+		},
+		{
+			name:    "invalid duration",
+			env:     map[string]string{"VOTING_TIMEOUT": "-1m"},
+			wantErr: "VOTING_TIMEOUT",
+		},
+		{
+			name:    "max players below min players",
+			env:     map[string]string{"MIN_PLAYERS": "5", "MAX_PLAYERS": "4"},
+			wantErr: "MAX_PLAYERS",
+		},
+		{
+			name:    "notify url without token",
+			env:     map[string]string{"NOTIFY_URL": "http://localhost/publish"},
+			wantErr: "NOTIFY_TOKEN",
+		},
+		{
+			name:    "relative join url",
+			env:     map[string]string{"JOIN_URL": "/join"},
+			wantErr: "JOIN_URL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, err := Load(tt.tables...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestAllowOrigin(t *testing.T) {
+	c := Config{CORSAllowOrigins: []string{"https://a.example", "https://b.example"}}
+	if got := c.AllowOrigin("https://b.example"); got != "https://b.example" {
+		t.Errorf("AllowOrigin(b) = %q", got)
+	}
+	// 許可していないオリジンには先頭のオリジンを返して、ブラウザに弾かせる
+	if got := c.AllowOrigin("https://evil.example"); got != "https://a.example" {
+		t.Errorf("AllowOrigin(evil) = %q", got)
+	}
+}
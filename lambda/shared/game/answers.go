@@ -0,0 +1,54 @@
+package game
+
+// プレイヤーが参加時に送る、質問ごとの回答
+type Answer struct {
+	QuestionID int  `json:"question_id" dynamodbav:"question_id"`
+	Answer     bool `json:"answer" dynamodbav:"answer"`
+}
+
+// ルーム作成時に固定された質問のうち、回答の確認に使う項目
+type RoomQuestion struct {
+	QuestionID int `json:"question_id" dynamodbav:"question_id"`
+}
+
+// 参加や回答の確認に失敗したときのレスポンス
+type ValidationError struct {
+	Code                 string `json:"code"`
+	Message              string `json:"message"`
+	MissingQuestionIDs   []int  `json:"missing_question_ids,omitempty"`
+	DuplicateQuestionIDs []int  `json:"duplicate_question_ids,omitempty"`
+	UnknownQuestionIDs   []int  `json:"unknown_question_ids,omitempty"`
+	// nickname_taken のときに提案する別のニックネーム
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// 回答がルームの質問と過不足なく1対1で対応しているかを確かめ、問題のある question_id を返す
+// (質問を持たない古いルームでは重複だけを確認する)
+func ValidateAnswers(answers []Answer, questions []RoomQuestion) *ValidationError {
+	known := make(map[int]bool, len(questions))
+	for _, q := range questions {
+		known[q.QuestionID] = true
+	}
+
+	verr := ValidationError{Code: "invalid_answers", Message: "answers do not match the questions of the room"}
+	answered := make(map[int]int, len(answers))
+	for _, ans := range answers {
+		answered[ans.QuestionID]++
+		if answered[ans.QuestionID] == 2 {
+			verr.DuplicateQuestionIDs = append(verr.DuplicateQuestionIDs, ans.QuestionID)
+		}
+		if len(questions) > 0 && !known[ans.QuestionID] && answered[ans.QuestionID] == 1 {
+			verr.UnknownQuestionIDs = append(verr.UnknownQuestionIDs, ans.QuestionID)
+		}
+	}
+	for _, q := range questions {
+		if answered[q.QuestionID] == 0 {
+			verr.MissingQuestionIDs = append(verr.MissingQuestionIDs, q.QuestionID)
+		}
+	}
+
+	if verr.MissingQuestionIDs == nil && verr.DuplicateQuestionIDs == nil && verr.UnknownQuestionIDs == nil {
+		return nil
+	}
+	return &verr
+}
@@ -0,0 +1,45 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateAnswers(t *testing.T) {
+	questions := []RoomQuestion{{QuestionID: 1}, {QuestionID: 2}, {QuestionID: 3}}
+	tests := []struct {
+		name      string
+		answers   []Answer
+		questions []RoomQuestion
+		want      *ValidationError
+	}{
+		{
+			name:      "all questions answered once",
+			answers:   []Answer{{QuestionID: 3}, {QuestionID: 1, Answer: true}, {QuestionID: 2}},
+			questions: questions,
+		},
+		{
+			name:      "missing, duplicate and unknown",
+			answers:   []Answer{{QuestionID: 1}, {QuestionID: 1}, {QuestionID: 9}, {QuestionID: 9}},
+			questions: questions,
+			want: &ValidationError{
+				Code:                 "invalid_answers",
+				Message:              "answers do not match the questions of the room",
+				MissingQuestionIDs:   []int{2, 3},
+				DuplicateQuestionIDs: []int{1, 9},
+				UnknownQuestionIDs:   []int{9},
+			},
+		},
+		{
+			name:    "room without questions only checks duplicates",
+			answers: []Answer{{QuestionID: 4}, {QuestionID: 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateAnswers(tt.answers, tt.questions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateAnswers = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+// game はラウンドの集計と進行をまとめる。ラウンドを進める Lambda はすべてここを通す
+package game
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+// ルームの進行状況
+const (
+	StatusLobby    = "lobby"
+	StatusPlaying  = "playing"
+	StatusFinished = "finished"
+)
+
+// 1ラウンドで得られる点数
+const (
+	// 市民が本物の火を灯した1人ごと
+	pointsPerIgnition = 1
+	// 市民側が勝ったときの市民全員
+	pointsCitizenWin = 2
+	// サンタが逃げ切ったとき
+	pointsSantaSurvive = 3
+)
+
+// TransactWriteItems に一度に載せられる件数
+const maxTransactItems = 100
+
+// ラウンドの集計で進行中の書き込みと衝突した。読み直せばやり直せる
+var ErrRoundChanged = errors.New("the round has changed")
+
+type Settings struct {
+	MinPlayers int `json:"min_players" dynamodbav:"min_players"`
+	Rounds     int `json:"rounds" dynamodbav:"rounds"`
+}
+
+// ラウンドの進行に使うルームの項目
+type Room struct {
+	RoomID       string   `json:"room_id" dynamodbav:"room_id"`
+	Status       string   `json:"status" dynamodbav:"status"`
+	Participants []string `json:"participants" dynamodbav:"participants"`
+	Settings     Settings `json:"settings" dynamodbav:"settings"`
+	// 進行中のラウンド (1 始まり)
+	Round int `json:"round" dynamodbav:"round"`
+	// 進行中のラウンドで選ばれたサンタと質問
+	SantaID    string `json:"santa_id" dynamodbav:"santa_id"`
+	QuestionID int    `json:"question_id" dynamodbav:"question_id"`
+	// ラウンドが始まってから決まる投票の締め切り (UNIX 秒)。
+	// finalizer が DeadlineIndex で探せるよう deadline にも同じ値を書き、ラウンドを進めたら両方消す
+	VotingDeadline int64          `json:"voting_deadline" dynamodbav:"voting_deadline"`
+	RoundResults   []RoundResult  `json:"round_results" dynamodbav:"round_results"`
+	Scores         map[string]int `json:"scores" dynamodbav:"scores"`
+}
+
+// round を持たない古いルームは1ラウンド目として扱う
+func (r Room) CurrentRound() int {
+	return max(r.Round, 1)
+}
+
+func (r Room) TotalRounds() int {
+	return max(r.Settings.Rounds, 1)
+}
+
+// ラウンドの集計に使うプレイヤーの項目。投票と棄権は何ラウンド目のものかも記録する
+type Player struct {
+	UserID         string `json:"user_id" dynamodbav:"user_id"`
+	IsSanta        bool   `json:"is_santa" dynamodbav:"is_santa"`
+	Fired          *bool  `json:"fire" dynamodbav:"fire"`
+	FiredBy        string `json:"fired_by" dynamodbav:"fired_by"`
+	FireRound      int    `json:"-" dynamodbav:"fire_round,omitempty"`
+	Abstained      bool   `json:"abstained" dynamodbav:"abstained"`
+	AbstainedRound int    `json:"-" dynamodbav:"abstained_round,omitempty"`
+}
+
+// round のラウンドで火を灯されたか。ラウンドを記録していない古い投票は今のラウンドのものとして扱う
+func (p Player) FiredIn(round int) bool {
+	return p.Fired != nil && (p.FireRound == 0 || p.FireRound == round)
+}
+
+func (p Player) AbstainedIn(round int) bool {
+	return p.Abstained && (p.AbstainedRound == 0 || p.AbstainedRound == round)
+}
+
+type RoundResult struct {
+	Round         int            `json:"round" dynamodbav:"round"`
+	SantaID       string         `json:"santa_id" dynamodbav:"santa_id"`
+	QuestionID    int            `json:"question_id" dynamodbav:"question_id"`
+	SantaSurvived bool           `json:"santa_survived" dynamodbav:"santa_survived"`
+	Points        map[string]int `json:"points" dynamodbav:"points"`
+}
+
+// ラウンドの進行に使う DynamoDB の操作。テストでは差し替えられる
+type DB interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// 全員に火が灯されたか棄権していればラウンドの結果を返す。結果 GET と同じ基準で勝敗を決める
+func TallyRound(room Room, players []Player) (RoundResult, bool) {
+	round := room.CurrentRound()
+	santaID := room.SantaID
+	if santaID == "" {
+		for _, p := range players {
+			if p.IsSanta {
+				santaID = p.UserID
+			}
+		}
+	}
+
+	result := RoundResult{
+		Round:      round,
+		SantaID:    santaID,
+		QuestionID: room.QuestionID,
+		Points:     make(map[string]int),
+	}
+	numberParticipants := 0
+	numberFired := 0
+	for _, p := range players {
+		if !p.FiredIn(round) {
+			if !p.AbstainedIn(round) {
+				return result, false
+			}
+			continue
+		}
+		// 棄権せずに参加したプレイヤーは0点でも結果に載せる
+		if _, ok := result.Points[p.UserID]; !ok {
+			result.Points[p.UserID] = 0
+		}
+		if p.UserID == santaID {
+			continue
+		}
+		numberParticipants++
+		if *p.Fired {
+			numberFired++
+			if p.FiredBy != "" && p.FiredBy != santaID {
+				result.Points[p.FiredBy] += pointsPerIgnition
+			}
+		}
+	}
+
+	//サンタ以外の人間の半数以上が点火されているなら、市民の勝利
+	citizensWin := numberParticipants/2 < numberFired
+	result.SantaSurvived = !citizensWin
+	for _, p := range players {
+		if !p.FiredIn(round) || p.UserID == santaID {
+			continue
+		}
+		if citizensWin {
+			result.Points[p.UserID] += pointsCitizenWin
+		}
+	}
+	if !citizensWin && santaID != "" {
+		result.Points[santaID] += pointsSantaSurvive
+	}
+	return result, true
+}
+
+// ラウンドの結果と累計点を記録して次のラウンドへ進める。最後のラウンドならゲームを終える。
+// 同じラウンドを二重に進めないよう、ラウンド番号を条件にする。
+// 次のラウンドのサンタ・質問・投票の締め切りは、そのラウンドを始めたときに決める
+func AdvanceRound(ctx context.Context, db DB, cfg appconfig.Config, room Room, players []Player, result RoundResult) (Room, error) {
+	scores := make(map[string]int)
+	for id, score := range room.Scores {
+		scores[id] = score
+	}
+	for id, points := range result.Points {
+		scores[id] += points
+	}
+	scoresAV, err := attributevalue.Marshal(scores)
+	if err != nil {
+		return room, err
+	}
+	resultAV, err := attributevalue.Marshal(result)
+	if err != nil {
+		return room, err
+	}
+
+	values := map[string]types.AttributeValue{
+		":scores":  scoresAV,
+		":result":  &types.AttributeValueMemberL{Value: []types.AttributeValue{resultAV}},
+		":empty":   &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+		":playing": &types.AttributeValueMemberS{Value: StatusPlaying},
+		":round":   &types.AttributeValueMemberN{Value: strconv.Itoa(room.CurrentRound())},
+	}
+	update := "SET scores = :scores, round_results = list_append(if_not_exists(round_results, :empty), :result)"
+	finished := room.CurrentRound() >= room.TotalRounds()
+	if finished {
+		update += ", #status = :finished REMOVE voting_deadline, deadline"
+		values[":finished"] = &types.AttributeValueMemberS{Value: StatusFinished}
+	} else {
+		update += ", #round = :next REMOVE santa_id, question_id, voting_deadline, deadline"
+		values[":next"] = &types.AttributeValueMemberN{Value: strconv.Itoa(room.CurrentRound() + 1)}
+	}
+
+	items := []types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName: aws.String(cfg.RoomTableName),
+			Key: map[string]types.AttributeValue{
+				"room_id": &types.AttributeValueMemberS{Value: room.RoomID},
+			},
+			UpdateExpression:    aws.String(update),
+			ConditionExpression: aws.String("#status = :playing AND (#round = :round OR attribute_not_exists(#round))"),
+			ExpressionAttributeNames: map[string]string{
+				"#status": "status",
+				"#round":  "round",
+			},
+			ExpressionAttributeValues: values,
+		},
+	}}
+	// 次のラウンドのために役割と投票を消す。最後のラウンドは結果 GET のために残しておく
+	if !finished {
+		for _, p := range players {
+			items = append(items, types.TransactWriteItem{
+				Update: &types.Update{
+					TableName: aws.String(cfg.UserTableName),
+					Key: map[string]types.AttributeValue{
+						"user_id": &types.AttributeValueMemberS{Value: p.UserID},
+					},
+					UpdateExpression:    aws.String("SET is_santa = :false REMOVE fire, fired_by, fire_round, abstained, abstained_round"),
+					ConditionExpression: aws.String("attribute_exists(user_id)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":false": &types.AttributeValueMemberBOOL{Value: false},
+					},
+				},
+			})
+		}
+	}
+
+	// ルームの更新は最初のトランザクションに入れる。入りきらなかったプレイヤーの投票は
+	// ラウンド番号が合わないので、消し損ねても次のラウンドの集計には使われない
+	for start := 0; start < len(items); start += maxTransactItems {
+		end := min(start+maxTransactItems, len(items))
+		_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items[start:end]})
+		var canceled *types.TransactionCanceledException
+		if start == 0 && errors.As(err, &canceled) && conditionFailed(canceled) {
+			return room, ErrRoundChanged
+		}
+		if err != nil {
+			return room, err
+		}
+	}
+
+	room.Scores = scores
+	room.RoundResults = append(room.RoundResults, result)
+	room.VotingDeadline = 0
+	if finished {
+		room.Status = StatusFinished
+		return room, nil
+	}
+	room.Round = room.CurrentRound() + 1
+	room.SantaID = ""
+	room.QuestionID = 0
+	return room, nil
+}
+
+// まだ火を灯されていないプレイヤーを棄権扱いにして投票を締め切り、ラウンドを集計して進める
+func CloseVoting(ctx context.Context, db DB, cfg appconfig.Config, room Room) (Room, error) {
+	round := room.CurrentRound()
+	var players []Player
+	for _, participant := range room.Participants {
+		player, found, err := GetPlayer(ctx, db, cfg, participant)
+		if err != nil {
+			return room, err
+		}
+		if !found {
+			continue
+		}
+		if !player.FiredIn(round) && !player.AbstainedIn(round) {
+			abstained, err := MarkAbstained(ctx, db, cfg, participant, round)
+			if err != nil {
+				return room, err
+			}
+			if abstained {
+				player.Abstained = true
+				player.AbstainedRound = round
+			} else if player, found, err = GetPlayer(ctx, db, cfg, participant); err != nil {
+				// 直前に火を灯されたので読み直す
+				return room, err
+			} else if !found {
+				continue
+			}
+		}
+		players = append(players, player)
+	}
+
+	result, complete := TallyRound(room, players)
+	if !complete {
+		return room, fmt.Errorf("round %d could not be closed", round)
+	}
+	return AdvanceRound(ctx, db, cfg, room, players, result)
+}
+
+// round のラウンドでまだ火を灯されていなければ棄権にする。棄権にしなかったときは false を返す
+func MarkAbstained(ctx context.Context, db DB, cfg appconfig.Config, userID string, round int) (bool, error) {
+	_, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(cfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		UpdateExpression:    aws.String("SET abstained = :true, abstained_round = :round"),
+		ConditionExpression: aws.String("attribute_exists(user_id) AND (attribute_not_exists(fire) OR (attribute_exists(fire_round) AND fire_round <> :round))"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true":  &types.AttributeValueMemberBOOL{Value: true},
+			":round": &types.AttributeValueMemberN{Value: strconv.Itoa(round)},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func GetPlayer(ctx context.Context, db DB, cfg appconfig.Config, userID string) (Player, bool, error) {
+	result, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(cfg.UserTableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return Player{}, false, err
+	}
+	var player Player
+	err = attributevalue.UnmarshalMap(result.Item, &player)
+	return player, true, err
+}
+
+func conditionFailed(canceled *types.TransactionCanceledException) bool {
+	for _, reason := range canceled.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
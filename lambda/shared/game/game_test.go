@@ -0,0 +1,241 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+var testCfg = appconfig.Config{RoomTableName: "rooms", UserTableName: "users"}
+
+type fakeDB struct {
+	// テーブル名ごとの項目。キーは room_id か user_id の値
+	items        map[string]map[string]map[string]types.AttributeValue
+	updates      []*dynamodb.UpdateItemInput
+	updateErr    error
+	transactions [][]types.TransactWriteItem
+	transactErr  error
+}
+
+func (f *fakeDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	for _, key := range params.Key {
+		if s, ok := key.(*types.AttributeValueMemberS); ok {
+			return &dynamodb.GetItemOutput{Item: f.items[aws.ToString(params.TableName)][s.Value]}, nil
+		}
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (f *fakeDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.updates = append(f.updates, params)
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	// 固定した値を ALL_NEW として返す
+	attrs := map[string]types.AttributeValue{
+		"status":          params.ExpressionAttributeValues[":playing"],
+		"santa_id":        params.ExpressionAttributeValues[":santa"],
+		"question_id":     params.ExpressionAttributeValues[":question"],
+		"voting_deadline": params.ExpressionAttributeValues[":deadline"],
+	}
+	for k, v := range attrs {
+		if v == nil {
+			delete(attrs, k)
+		}
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: attrs}, nil
+}
+
+func (f *fakeDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if f.transactErr != nil {
+		return nil, f.transactErr
+	}
+	f.transactions = append(f.transactions, params.TransactItems)
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	return &dynamodb.BatchGetItemOutput{}, nil
+}
+
+func fired(v bool) *bool { return &v }
+
+func TestTallyRound(t *testing.T) {
+	room := Room{Round: 2, SantaID: "santa", QuestionID: 7}
+	tests := []struct {
+		name         string
+		players      []Player
+		wantComplete bool
+		wantSurvived bool
+		wantPoints   map[string]int
+	}{
+		{
+			name: "citizens win",
+			players: []Player{
+				{UserID: "santa", Fired: fired(false), FiredBy: "a", FireRound: 2},
+				{UserID: "a", Fired: fired(true), FiredBy: "b", FireRound: 2},
+				{UserID: "b", Fired: fired(true), FiredBy: "a", FireRound: 2},
+			},
+			wantComplete: true,
+			wantPoints:   map[string]int{"santa": 0, "a": 3, "b": 3},
+		},
+		{
+			name: "santa survives",
+			players: []Player{
+				{UserID: "santa", Fired: fired(true), FiredBy: "a", FireRound: 2},
+				{UserID: "a", Fired: fired(false), FiredBy: "santa", FireRound: 2},
+				{UserID: "b", Fired: fired(false), FiredBy: "santa", FireRound: 2},
+			},
+			wantComplete: true,
+			wantSurvived: true,
+			wantPoints:   map[string]int{"santa": 3, "a": 0, "b": 0},
+		},
+		{
+			name: "a player has not been lit yet",
+			players: []Player{
+				{UserID: "santa", Fired: fired(true), FiredBy: "a", FireRound: 2},
+				{UserID: "a"},
+			},
+		},
+		{
+			name: "a vote from the previous round does not count",
+			players: []Player{
+				{UserID: "santa", Fired: fired(true), FiredBy: "a", FireRound: 2},
+				{UserID: "a", Fired: fired(true), FiredBy: "b", FireRound: 1},
+			},
+		},
+		{
+			name: "abstained players are left out",
+			players: []Player{
+				{UserID: "santa", Fired: fired(true), FiredBy: "a", FireRound: 2},
+				{UserID: "a", Fired: fired(false), FiredBy: "santa", FireRound: 2},
+				{UserID: "b", Abstained: true, AbstainedRound: 2},
+			},
+			wantComplete: true,
+			wantSurvived: true,
+			wantPoints:   map[string]int{"santa": 3, "a": 0},
+		},
+		{
+			name: "abstained in the previous round",
+			players: []Player{
+				{UserID: "santa", Fired: fired(true), FiredBy: "a", FireRound: 2},
+				{UserID: "b", Abstained: true, AbstainedRound: 1},
+			},
+		},
+		{
+			name: "votes without a round are from the current round",
+			players: []Player{
+				{UserID: "santa", Fired: fired(false), FiredBy: "a"},
+				{UserID: "a", Fired: fired(true), FiredBy: "b"},
+				{UserID: "b", Fired: fired(true), FiredBy: "a"},
+			},
+			wantComplete: true,
+			wantPoints:   map[string]int{"santa": 0, "a": 3, "b": 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, complete := TallyRound(room, tt.players)
+			if complete != tt.wantComplete {
+				t.Fatalf("complete = %v, want %v", complete, tt.wantComplete)
+			}
+			if !complete {
+				return
+			}
+			if result.Round != 2 || result.SantaID != "santa" || result.QuestionID != 7 {
+				t.Errorf("result = %+v", result)
+			}
+			if result.SantaSurvived != tt.wantSurvived {
+				t.Errorf("SantaSurvived = %v, want %v", result.SantaSurvived, tt.wantSurvived)
+			}
+			if fmt.Sprint(result.Points) != fmt.Sprint(tt.wantPoints) {
+				t.Errorf("Points = %v, want %v", result.Points, tt.wantPoints)
+			}
+		})
+	}
+}
+
+func TestAdvanceRound(t *testing.T) {
+	result := RoundResult{Round: 1, SantaID: "santa", Points: map[string]int{"santa": 3}}
+	players := []Player{{UserID: "santa"}, {UserID: "a"}, {UserID: "b"}}
+
+	t.Run("next round", func(t *testing.T) {
+		db := &fakeDB{}
+		room := Room{RoomID: "r", Status: StatusPlaying, Round: 1, Settings: Settings{Rounds: 2}, SantaID: "santa", VotingDeadline: 100}
+		room, err := AdvanceRound(context.Background(), db, testCfg, room, players, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if room.Round != 2 || room.SantaID != "" || room.VotingDeadline != 0 || room.Scores["santa"] != 3 {
+			t.Errorf("room = %+v", room)
+		}
+		if len(db.transactions) != 1 || len(db.transactions[0]) != 1+len(players) {
+			t.Fatalf("transactions = %v", db.transactions)
+		}
+		update := aws.ToString(db.transactions[0][0].Update.UpdateExpression)
+		// 次のラウンドの締め切りは始めたときに決める
+		if !strings.Contains(update, "REMOVE santa_id, question_id, voting_deadline") {
+			t.Errorf("room update = %q", update)
+		}
+		for _, item := range db.transactions[0][1:] {
+			if !strings.Contains(aws.ToString(item.Update.UpdateExpression), "REMOVE fire, fired_by, fire_round") {
+				t.Errorf("player update = %q", aws.ToString(item.Update.UpdateExpression))
+			}
+		}
+	})
+
+	t.Run("last round keeps the votes", func(t *testing.T) {
+		db := &fakeDB{}
+		room := Room{RoomID: "r", Status: StatusPlaying, Round: 2, Settings: Settings{Rounds: 2}}
+		room, err := AdvanceRound(context.Background(), db, testCfg, room, players, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if room.Status != StatusFinished || len(room.RoundResults) != 1 {
+			t.Errorf("room = %+v", room)
+		}
+		if len(db.transactions) != 1 || len(db.transactions[0]) != 1 {
+			t.Errorf("transactions = %v", db.transactions)
+		}
+	})
+
+	t.Run("many players are split into several transactions", func(t *testing.T) {
+		db := &fakeDB{}
+		many := make([]Player, 150)
+		for i := range many {
+			many[i].UserID = fmt.Sprint(i)
+		}
+		room := Room{RoomID: "r", Status: StatusPlaying, Round: 1, Settings: Settings{Rounds: 2}}
+		if _, err := AdvanceRound(context.Background(), db, testCfg, room, many, result); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.transactions) != 2 || len(db.transactions[0]) != maxTransactItems || len(db.transactions[1]) != 51 {
+			t.Errorf("transaction sizes = %d", len(db.transactions))
+		}
+		if db.transactions[0][0].Update.TableName == nil || *db.transactions[0][0].Update.TableName != "rooms" {
+			t.Errorf("the room update is not first")
+		}
+	})
+
+	t.Run("already advanced", func(t *testing.T) {
+		db := &fakeDB{transactErr: &types.TransactionCanceledException{
+			CancellationReasons: []types.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")}},
+		}}
+		room := Room{RoomID: "r", Status: StatusPlaying, Round: 1, Settings: Settings{Rounds: 2}}
+		got, err := AdvanceRound(context.Background(), db, testCfg, room, players, result)
+		if !errors.Is(err, ErrRoundChanged) {
+			t.Fatalf("err = %v, want ErrRoundChanged", err)
+		}
+		if got.Round != 1 {
+			t.Errorf("room changed to %+v", got)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package game
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+type LobbySettings struct {
+	MinPlayers int  `json:"min_players" dynamodbav:"min_players"`
+	MaxPlayers int  `json:"max_players" dynamodbav:"max_players"`
+	AutoStart  bool `json:"auto_start" dynamodbav:"auto_start"`
+}
+
+// ロビーの準備状況に使うルームの項目
+type LobbyRoom struct {
+	RoomID       string        `json:"room_id" dynamodbav:"room_id"`
+	Status       string        `json:"status" dynamodbav:"status"`
+	HostID       string        `json:"host_id" dynamodbav:"host_id"`
+	Participants []string      `json:"participants" dynamodbav:"participants"`
+	Settings     LobbySettings `json:"settings" dynamodbav:"settings"`
+}
+
+type LobbyPlayer struct {
+	UserID   string `json:"user_id" dynamodbav:"user_id"`
+	NickName string `json:"nickname" dynamodbav:"nickname"`
+	Ready    bool   `json:"ready" dynamodbav:"ready"`
+}
+
+// ロビーの準備状況
+type LobbySummary struct {
+	RoomID      string `json:"room_id"`
+	Status      string `json:"status"`
+	HostID      string `json:"host_id"`
+	MinPlayers  int    `json:"min_players"`
+	MaxPlayers  int    `json:"max_players"`
+	AutoStart   bool   `json:"auto_start"`
+	PlayerCount int    `json:"player_count"`
+	// あと何人参加できるか
+	RemainingSlots int           `json:"remaining_slots"`
+	ReadyCount     int           `json:"ready_count"`
+	AllReady       bool          `json:"all_ready"`
+	Players        []LobbyPlayer `json:"players"`
+}
+
+// 参加者を読み、ロビーの準備状況をまとめる。ロビーの取得と準備完了の応答が同じ形を返す
+func SummarizeLobby(ctx context.Context, db DB, cfg appconfig.Config, room LobbyRoom) (LobbySummary, error) {
+	summary := LobbySummary{
+		RoomID:     room.RoomID,
+		Status:     room.Status,
+		HostID:     room.HostID,
+		MinPlayers: room.Settings.MinPlayers,
+		MaxPlayers: room.Settings.MaxPlayers,
+		AutoStart:  room.Settings.AutoStart,
+		Players:    []LobbyPlayer{},
+	}
+	if summary.Status == "" {
+		summary.Status = StatusLobby
+	}
+	// 人数の設定がない古いルームは既定値を使う
+	if summary.MinPlayers == 0 {
+		summary.MinPlayers = cfg.Game.MinPlayers
+	}
+	if summary.MaxPlayers == 0 {
+		summary.MaxPlayers = cfg.Game.MaxPlayers
+	}
+
+	for _, participant := range room.Participants {
+		response, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(cfg.UserTableName),
+			Key: map[string]types.AttributeValue{
+				"user_id": &types.AttributeValueMemberS{Value: participant},
+			},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return summary, err
+		}
+		if response.Item == nil {
+			continue
+		}
+		var player LobbyPlayer
+		if err = attributevalue.UnmarshalMap(response.Item, &player); err != nil {
+			return summary, err
+		}
+		summary.Players = append(summary.Players, player)
+		if player.Ready {
+			summary.ReadyCount++
+		}
+	}
+	summary.PlayerCount = len(summary.Players)
+	summary.RemainingSlots = max(summary.MaxPlayers-len(room.Participants), 0)
+	summary.AllReady = summary.PlayerCount >= summary.MinPlayers && summary.ReadyCount == summary.PlayerCount
+	return summary, nil
+}
@@ -0,0 +1,35 @@
+package game
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestSummarizeLobby(t *testing.T) {
+	users := map[string]map[string]types.AttributeValue{}
+	for _, p := range []LobbyPlayer{{UserID: "a", NickName: "alice", Ready: true}, {UserID: "b", NickName: "bob"}} {
+		item, err := attributevalue.MarshalMap(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[p.UserID] = item
+	}
+	db := &fakeDB{items: map[string]map[string]map[string]types.AttributeValue{testCfg.UserTableName: users}}
+	cfg := testCfg
+	cfg.Game.MinPlayers, cfg.Game.MaxPlayers = 2, 4
+
+	// 項目が消えたプレイヤーは数えないが、枠は空けない
+	summary, err := SummarizeLobby(context.Background(), db, cfg, LobbyRoom{RoomID: "r", HostID: "a", Participants: []string{"a", "b", "gone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != StatusLobby || summary.MinPlayers != 2 || summary.MaxPlayers != 4 {
+		t.Errorf("summary = %+v, want the defaults for an old room", summary)
+	}
+	if summary.PlayerCount != 2 || summary.ReadyCount != 1 || summary.RemainingSlots != 1 || summary.AllReady {
+		t.Errorf("summary = %+v", summary)
+	}
+}
@@ -0,0 +1,294 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+// 参加させるプレイヤー
+type Joiner struct {
+	UserID   string
+	NickName string
+	// 重複の判定に使うニックネームのキー (nickname.Key)
+	NickNameKey string
+	// クイック参加では空のまま参加させ、ゲームを始めるまでに PUT .../answers で送らせる
+	Answers          []Answer
+	SessionTokenHash string
+	// 再接続に使う端末 ID のハッシュ
+	DeviceHash string
+}
+
+// 参加の条件
+type JoinOptions struct {
+	// ルームの定員
+	Capacity int
+	// devices や nicknames を持たない古いルームでは先に空のマップを作る
+	CreateMaps bool
+	// 招待で参加するときの招待のキー。参加と同じ書き込みで利用回数を数える
+	InviteHash string
+}
+
+// 参加で作るユーザーの項目
+type joiningUser struct {
+	UserID           string   `dynamodbav:"user_id"`
+	NickName         string   `dynamodbav:"nickname"`
+	RoomID           string   `dynamodbav:"room_id"`
+	Answers          []Answer `dynamodbav:"answers"`
+	SessionTokenHash string   `dynamodbav:"session_token_hash"`
+	DeviceHash       string   `dynamodbav:"device_hash,omitempty"`
+	LastSeen         int64    `dynamodbav:"last_seen"`
+}
+
+// 同時に他のプレイヤーが出入りして条件が外れたときの再試行回数
+const maxRemoveAttempts = 3
+
+// 退室に使うルームの項目
+type membership struct {
+	RoomID       string   `dynamodbav:"room_id"`
+	HostID       string   `dynamodbav:"host_id"`
+	Participants []string `dynamodbav:"participants"`
+	// 端末 ID のハッシュ -> user_id
+	Devices map[string]string `dynamodbav:"devices"`
+	// 正規化したニックネーム -> user_id
+	NickNames map[string]string `dynamodbav:"nicknames"`
+}
+
+// ルームの参加者から外し、ユーザーを削除する。ホストが抜けるときは次の参加者に引き継ぐ。
+// 本人の退室、ホストや運営によるキック、finalizer による離脱扱いのすべてがここを通る
+func RemovePlayer(ctx context.Context, db DB, cfg appconfig.Config, roomID, userID string) error {
+	var err error
+	// ユーザーの項目が別のルームのものになっていれば、参加者一覧から外すだけにする
+	deleteUser := true
+	for attempt := 1; attempt <= maxRemoveAttempts; attempt++ {
+		var room membership
+		var found bool
+		room, found, err = getMembership(ctx, db, cfg, roomID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			// ルームが先に消えていてもユーザーは消す
+			room = membership{RoomID: roomID}
+		}
+		err = removeMember(ctx, db, cfg, room, userID, deleteUser)
+		var canceled *types.TransactionCanceledException
+		if !errors.As(err, &canceled) {
+			return err
+		}
+		if reasons := canceled.CancellationReasons; deleteUser && len(reasons) > 0 && aws.ToString(reasons[len(reasons)-1].Code) == "ConditionalCheckFailed" {
+			deleteUser = false
+			continue
+		}
+		// 参加者の並びが変わっていたので読み直す
+	}
+	return err
+}
+
+// ユーザーの作成と参加者一覧への追加をまとめて行う。ニックネームと端末 ID をルームに登録し、既に使われていれば失敗させる。
+// ゲームが始まったルームや満員のルームには参加させない。条件で失敗したときの TransactionCanceledException には
+// その時点のルームが入る。参加とクイック参加がここを通る
+func JoinRoom(ctx context.Context, db DB, cfg appconfig.Config, roomID string, player Joiner, opts JoinOptions, now time.Time) error {
+	answers := player.Answers
+	if answers == nil {
+		answers = []Answer{}
+	}
+	item, err := attributevalue.MarshalMap(joiningUser{
+		UserID:           player.UserID,
+		NickName:         player.NickName,
+		RoomID:           roomID,
+		Answers:          answers,
+		SessionTokenHash: player.SessionTokenHash,
+		DeviceHash:       player.DeviceHash,
+		LastSeen:         now.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	key := map[string]types.AttributeValue{
+		"room_id": &types.AttributeValueMemberS{Value: roomID},
+	}
+
+	if opts.CreateMaps {
+		_, err = db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:        aws.String(cfg.RoomTableName),
+			Key:              key,
+			UpdateExpression: aws.String("SET devices = if_not_exists(devices, :empty_map), nicknames = if_not_exists(nicknames, :empty_map)"),
+			// 消えたルームの代わりに空の項目を作らない
+			ConditionExpression: aws.String("attribute_exists(room_id)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":empty_map": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+			},
+		})
+		var failed *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &failed) {
+			return err
+		}
+	}
+
+	// 最初に参加したプレイヤーをホストにする
+	update := "SET participants = list_append(if_not_exists(participants, :empty), :user_ids), host_id = if_not_exists(host_id, :user_id), nicknames.#nickname = :user_id"
+	// 定員は参加者一覧への追加と同じ書き込みで確かめる
+	condition := "attribute_exists(room_id) AND (attribute_not_exists(#status) OR #status = :lobby) AND attribute_exists(nicknames) AND attribute_not_exists(nicknames.#nickname) AND (attribute_not_exists(participants) OR size(participants) < :max)"
+	names := map[string]string{"#nickname": player.NickNameKey, "#status": "status"}
+	values := map[string]types.AttributeValue{
+		":lobby":    &types.AttributeValueMemberS{Value: StatusLobby},
+		":empty":    &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+		":user_ids": &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: player.UserID}}},
+		":user_id":  &types.AttributeValueMemberS{Value: player.UserID},
+		":max":      &types.AttributeValueMemberN{Value: strconv.Itoa(opts.Capacity)},
+	}
+	if player.DeviceHash != "" {
+		update += ", devices.#device = :user_id"
+		condition += " AND attribute_exists(devices) AND attribute_not_exists(devices.#device)"
+		names["#device"] = player.DeviceHash
+	}
+	if opts.InviteHash != "" {
+		update += ", invites.#invite.uses = invites.#invite.uses + :one"
+		condition += " AND invites.#invite.expires_at > :now AND (invites.#invite.max_uses = :zero OR invites.#invite.uses < invites.#invite.max_uses)"
+		names["#invite"] = opts.InviteHash
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+		values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+		values[":now"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)}
+	}
+
+	_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName: aws.String(cfg.UserTableName),
+					Item:      item,
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName:                 aws.String(cfg.RoomTableName),
+					Key:                       key,
+					UpdateExpression:          aws.String(update),
+					ConditionExpression:       aws.String(condition),
+					ExpressionAttributeNames:  names,
+					ExpressionAttributeValues: values,
+					// 失敗したときにニックネームと端末のどちらが衝突したかを見分ける
+					ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+				},
+			},
+		},
+	})
+	return err
+}
+
+func removeMember(ctx context.Context, db DB, cfg appconfig.Config, room membership, userID string, deleteUser bool) error {
+	index := -1
+	var next string
+	for i, id := range room.Participants {
+		if id == userID {
+			index = i
+		} else if next == "" {
+			next = id
+		}
+	}
+
+	var items []types.TransactWriteItem
+	if deleteUser {
+		// 別のルームに参加し直したユーザーは消さない。項目が既に無ければそのまま進める
+		items = append(items, types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: aws.String(cfg.UserTableName),
+				Key: map[string]types.AttributeValue{
+					"user_id": &types.AttributeValueMemberS{Value: userID},
+				},
+				ConditionExpression: aws.String("attribute_not_exists(user_id) OR room_id = :room_id"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":room_id": &types.AttributeValueMemberS{Value: room.RoomID},
+				},
+			},
+		})
+	}
+	if index < 0 {
+		if len(items) == 0 {
+			return nil
+		}
+		// 参加者一覧への書き込み前に失敗したユーザー
+		_, err := db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		return err
+	}
+
+	path := "participants[" + strconv.Itoa(index) + "]"
+	update := "REMOVE " + path
+	condition := path + " = :user_id"
+	values := map[string]types.AttributeValue{
+		":user_id": &types.AttributeValueMemberS{Value: userID},
+	}
+	if room.HostID == userID {
+		condition += " AND host_id = :user_id"
+		if next != "" {
+			update = "SET host_id = :next " + update
+			values[":next"] = &types.AttributeValueMemberS{Value: next}
+		} else {
+			update += ", host_id"
+		}
+	}
+	// 退室したプレイヤーの端末 ID で再接続できないようにし、ニックネームを空ける
+	names := map[string]string{}
+	for device, id := range room.Devices {
+		if id == userID {
+			update += ", devices.#device"
+			names["#device"] = device
+			break
+		}
+	}
+	for nickname, id := range room.NickNames {
+		if id == userID {
+			update += ", nicknames.#nickname"
+			names["#nickname"] = nickname
+			break
+		}
+	}
+	if len(names) == 0 {
+		names = nil
+	}
+
+	// ユーザーの削除は常に最後に置く (RemovePlayer が失敗の理由を見分ける)
+	items = append([]types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName: aws.String(cfg.RoomTableName),
+			Key: map[string]types.AttributeValue{
+				"room_id": &types.AttributeValueMemberS{Value: room.RoomID},
+			},
+			UpdateExpression:          aws.String(update),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		},
+	}}, items...)
+	_, err := db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	return err
+}
+
+func getMembership(ctx context.Context, db DB, cfg appconfig.Config, roomID string) (membership, bool, error) {
+	response, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(cfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || response.Item == nil {
+		return membership{}, false, err
+	}
+	var room membership
+	err = attributevalue.UnmarshalMap(response.Item, &room)
+	return room, true, err
+}
@@ -0,0 +1,147 @@
+package game
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRemovePlayer(t *testing.T) {
+	room, err := attributevalue.MarshalMap(membership{
+		RoomID:       "r",
+		HostID:       "a",
+		Participants: []string{"a", "b"},
+		Devices:      map[string]string{"device-a": "a"},
+		NickNames:    map[string]string{"alice": "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &fakeDB{items: map[string]map[string]map[string]types.AttributeValue{
+		testCfg.RoomTableName: {"r": room},
+	}}
+	if err := RemovePlayer(context.Background(), db, testCfg, "r", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.transactions) != 1 || len(db.transactions[0]) != 2 {
+		t.Fatalf("transactions = %v", db.transactions)
+	}
+	update := db.transactions[0][0].Update
+	// ホストは次の参加者に引き継ぎ、端末 ID とニックネームを空ける
+	want := "SET host_id = :next REMOVE participants[0], devices.#device, nicknames.#nickname"
+	if got := aws.ToString(update.UpdateExpression); got != want {
+		t.Errorf("update = %q, want %q", got, want)
+	}
+	if !strings.Contains(aws.ToString(update.ConditionExpression), "host_id = :user_id") {
+		t.Errorf("condition = %q", aws.ToString(update.ConditionExpression))
+	}
+	if del := db.transactions[0][1].Delete; del == nil {
+		t.Errorf("the user is not deleted")
+	} else if got := aws.ToString(del.ConditionExpression); !strings.Contains(got, "room_id = :room_id") {
+		t.Errorf("delete condition = %q, want the user to belong to the room", got)
+	}
+}
+
+// 最初のトランザクションを、ユーザーの削除の条件で失敗させる
+type movedUserDB struct {
+	*fakeDB
+	failed bool
+}
+
+func (m *movedUserDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if !m.failed {
+		m.failed = true
+		reasons := make([]types.CancellationReason, len(params.TransactItems))
+		for i := range reasons {
+			reasons[i].Code = aws.String("None")
+		}
+		reasons[len(reasons)-1].Code = aws.String("ConditionalCheckFailed")
+		return nil, &types.TransactionCanceledException{CancellationReasons: reasons}
+	}
+	return m.fakeDB.TransactWriteItems(ctx, params, optFns...)
+}
+
+func TestRemovePlayerMovedToAnotherRoom(t *testing.T) {
+	room, err := attributevalue.MarshalMap(membership{RoomID: "r", HostID: "b", Participants: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &movedUserDB{fakeDB: &fakeDB{items: map[string]map[string]map[string]types.AttributeValue{
+		testCfg.RoomTableName: {"r": room},
+	}}}
+	if err := RemovePlayer(context.Background(), db, testCfg, "r", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// 別のルームのユーザーは消さず、参加者一覧からだけ外す
+	if len(db.transactions) != 1 || len(db.transactions[0]) != 1 || db.transactions[0][0].Update == nil {
+		t.Errorf("transactions = %v", db.transactions)
+	}
+}
+
+func TestRemovePlayerNotInRoom(t *testing.T) {
+	db := &fakeDB{}
+	if err := RemovePlayer(context.Background(), db, testCfg, "gone", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// ルームが無くてもユーザーだけは消す
+	if len(db.transactions) != 1 || len(db.transactions[0]) != 1 || db.transactions[0][0].Delete == nil {
+		t.Errorf("transactions = %v", db.transactions)
+	}
+}
+
+func TestJoinRoom(t *testing.T) {
+	db := &fakeDB{}
+	player := Joiner{UserID: "u", NickName: "Alice", NickNameKey: "alice", SessionTokenHash: "hash", DeviceHash: "device"}
+	now := time.Unix(1700000000, 0)
+	if err := JoinRoom(context.Background(), db, testCfg, "r", player, JoinOptions{Capacity: 4, InviteHash: "invite"}, now); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.updates) != 0 {
+		t.Errorf("updates = %d, want 0 for a room that already has the maps", len(db.updates))
+	}
+	if len(db.transactions) != 1 || len(db.transactions[0]) != 2 {
+		t.Fatalf("transactions = %v", db.transactions)
+	}
+	// クイック参加のプレイヤーは回答を空のリストで持つ
+	var user joiningUser
+	if err := attributevalue.UnmarshalMap(db.transactions[0][0].Put.Item, &user); err != nil {
+		t.Fatal(err)
+	}
+	if answers, ok := db.transactions[0][0].Put.Item["answers"].(*types.AttributeValueMemberL); !ok || len(answers.Value) != 0 {
+		t.Errorf("answers = %#v, want an empty list", db.transactions[0][0].Put.Item["answers"])
+	}
+	if user.RoomID != "r" || user.DeviceHash != "device" || user.LastSeen != now.Unix() {
+		t.Errorf("user = %+v", user)
+	}
+	update := db.transactions[0][1].Update
+	condition := aws.ToString(update.ConditionExpression)
+	for _, want := range []string{"attribute_not_exists(nicknames.#nickname)", "attribute_not_exists(devices.#device)", "size(participants) < :max", "invites.#invite.expires_at > :now"} {
+		if !strings.Contains(condition, want) {
+			t.Errorf("condition %q does not contain %q", condition, want)
+		}
+	}
+	if update.ExpressionAttributeNames["#nickname"] != "alice" {
+		t.Errorf("names = %v", update.ExpressionAttributeNames)
+	}
+}
+
+func TestJoinRoomCreatesMaps(t *testing.T) {
+	db := &fakeDB{}
+	player := Joiner{UserID: "u", NickName: "Alice", NickNameKey: "alice", Answers: []Answer{{QuestionID: 1, Answer: true}}}
+	if err := JoinRoom(context.Background(), db, testCfg, "r", player, JoinOptions{Capacity: 4, CreateMaps: true}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// 古いルームには先に devices と nicknames を作る
+	if len(db.updates) != 1 || !strings.Contains(aws.ToString(db.updates[0].UpdateExpression), "nicknames = if_not_exists(nicknames, :empty_map)") {
+		t.Errorf("updates = %v", db.updates)
+	}
+	if len(db.transactions) != 1 {
+		t.Errorf("transactions = %v", db.transactions)
+	}
+}
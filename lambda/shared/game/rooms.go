@@ -0,0 +1,113 @@
+package game
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"shared/appconfig"
+	"shared/question"
+)
+
+type RoomSettings struct {
+	QuestionCount int `json:"question_count" dynamodbav:"question_count"`
+	// ゲームを始めるのに必要な人数
+	MinPlayers int `json:"min_players" dynamodbav:"min_players"`
+	// 全員が準備完了になったら自動でゲームを始める
+	AutoStart bool `json:"auto_start" dynamodbav:"auto_start"`
+	Rounds    int  `json:"rounds" dynamodbav:"rounds"`
+	// ルームに入れる人数
+	MaxPlayers int `json:"max_players" dynamodbav:"max_players"`
+	// 公開ルームは GET /rooms に載り、クイック参加の対象になる
+	Public   bool   `json:"public" dynamodbav:"public"`
+	Language string `json:"language" dynamodbav:"language"`
+}
+
+// 作成時に書き込むルームの項目。ルームの作成とクイック参加が同じ形で作る
+type RoomItem struct {
+	RoomID string `json:"room_id" dynamodbav:"room_id"`
+	// 招待のプレビューに表示する名前
+	Name         string                  `json:"name,omitempty" dynamodbav:"name,omitempty"`
+	Status       string                  `json:"status" dynamodbav:"status"`
+	Participants []string                `json:"participants" dynamodbav:"participants"`
+	PackID       string                  `json:"pack_id,omitempty" dynamodbav:"pack_id,omitempty"`
+	Settings     RoomSettings            `json:"settings" dynamodbav:"settings"`
+	Questions    []question.RoomQuestion `json:"questions" dynamodbav:"questions"`
+	// 進行中のラウンド (1 始まり)
+	Round int `json:"round" dynamodbav:"round"`
+	// 作成日時 (UNIX 秒)
+	CreatedAt int64 `json:"created_at" dynamodbav:"created_at"`
+	// ロビーの締め切り (UNIX 秒)。投票の締め切りはゲーム開始時に決まる
+	LobbyDeadline int64 `json:"lobby_deadline" dynamodbav:"lobby_deadline"`
+	// finalizer が DeadlineIndex で探す今のフェーズの締め切り。ロビーでは lobby_deadline と同じ
+	Deadline int64 `json:"-" dynamodbav:"deadline"`
+	// 端末 ID のハッシュ -> user_id。再接続に使う
+	Devices map[string]string `json:"-" dynamodbav:"devices"`
+	// 正規化したニックネーム -> user_id。ルーム内の重複を防ぐ
+	NickNames map[string]string `json:"-" dynamodbav:"nicknames"`
+	// 公開ルームだけが持つ。公開ルーム一覧のインデックスのキー
+	PublicLanguage string `json:"-" dynamodbav:"public_language,omitempty"`
+	// プライベートルームの合言葉の bcrypt のハッシュ (passcode.Lock と同じ属性)
+	PasscodeHash string `json:"-" dynamodbav:"passcode_hash,omitempty"`
+	TTL          int64  `json:"-" dynamodbav:"TTL"`
+}
+
+// ルームの作成に使う DynamoDB の操作
+type RoomCreator interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// 紛らわしい文字を除いたルームコード
+const roomIDAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// 再戦やクイック参加のように room_id を指定されずに作るルームのコード
+func NewRoomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = roomIDAlphabet[int(b[i])%len(roomIDAlphabet)]
+	}
+	return string(b), nil
+}
+
+// まだ誰もいないロビーのルームを組み立てる。締め切りと TTL は now から数える
+func NewRoomItem(cfg appconfig.Config, roomID string, settings RoomSettings, questions []question.RoomQuestion, now time.Time) RoomItem {
+	room := RoomItem{
+		RoomID:        roomID,
+		Status:        StatusLobby,
+		Participants:  []string{},
+		Settings:      settings,
+		Questions:     questions,
+		Round:         1,
+		CreatedAt:     now.Unix(),
+		LobbyDeadline: now.Add(cfg.LobbyTimeout).Unix(),
+		Deadline:      now.Add(cfg.LobbyTimeout).Unix(),
+		Devices:       map[string]string{},
+		NickNames:     map[string]string{},
+		TTL:           now.Add(cfg.RoomTTL).Unix(),
+	}
+	if settings.Public {
+		room.PublicLanguage = settings.Language
+	}
+	return room
+}
+
+// 同じ room_id のルームが既にあれば ConditionalCheckFailedException を返す
+func CreateRoom(ctx context.Context, db RoomCreator, cfg appconfig.Config, room RoomItem) error {
+	item, err := attributevalue.MarshalMap(room)
+	if err != nil {
+		return err
+	}
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(cfg.RoomTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(room_id)"),
+	})
+	return err
+}
@@ -0,0 +1,75 @@
+package game
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"shared/question"
+)
+
+type putDB struct {
+	puts []*dynamodb.PutItemInput
+}
+
+func (p *putDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	p.puts = append(p.puts, params)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestCreateRoom(t *testing.T) {
+	cfg := testCfg
+	cfg.LobbyTimeout = 10 * time.Minute
+	cfg.RoomTTL = 12 * time.Hour
+	now := time.Unix(1700000000, 0)
+	settings := RoomSettings{QuestionCount: 1, MinPlayers: 3, MaxPlayers: 8, Rounds: 1, Public: true, Language: "ja"}
+	room := NewRoomItem(cfg, "r", settings, []question.RoomQuestion{{QuestionID: 1, Statement: "one"}}, now)
+	if room.Status != StatusLobby || room.Round != 1 || room.PublicLanguage != "ja" {
+		t.Errorf("room = %+v", room)
+	}
+	if room.Deadline != now.Add(cfg.LobbyTimeout).Unix() || room.LobbyDeadline != room.Deadline || room.TTL != now.Add(cfg.RoomTTL).Unix() {
+		t.Errorf("deadlines = %d, %d, TTL %d", room.LobbyDeadline, room.Deadline, room.TTL)
+	}
+
+	db := &putDB{}
+	if err := CreateRoom(context.Background(), db, cfg, room); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.puts) != 1 || aws.ToString(db.puts[0].ConditionExpression) != "attribute_not_exists(room_id)" {
+		t.Fatalf("puts = %v", db.puts)
+	}
+	// 参加で使う空のマップを最初から持たせる
+	var written RoomItem
+	if err := attributevalue.UnmarshalMap(db.puts[0].Item, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Devices == nil || written.NickNames == nil || written.Participants == nil {
+		t.Errorf("written = %+v", written)
+	}
+	if _, ok := db.puts[0].Item["passcode_hash"]; ok {
+		t.Errorf("passcode_hash is written for a room without a passcode")
+	}
+}
+
+func TestNewRoomItemPrivate(t *testing.T) {
+	room := NewRoomItem(testCfg, "r", RoomSettings{Language: "ja"}, nil, time.Now())
+	// 公開しないルームは公開ルーム一覧のインデックスに載せない
+	if room.PublicLanguage != "" {
+		t.Errorf("public_language = %q", room.PublicLanguage)
+	}
+}
+
+func TestNewRoomID(t *testing.T) {
+	id, err := NewRoomID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != 8 || strings.Trim(id, roomIDAlphabet) != "" {
+		t.Errorf("NewRoomID = %q", id)
+	}
+}
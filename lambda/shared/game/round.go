@@ -0,0 +1,516 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+var (
+	// ゲームが終わったルームのラウンドは始められない
+	ErrGameOver = errors.New("the game is over")
+	// StartGame を呼んだときには別の呼び出しでゲームが始まっていた
+	ErrAlreadyStarted = errors.New("the game has already started")
+	// 回答の状況からサンタか質問を選べない
+	ErrNoCandidates = errors.New("unable to choose the santa and question from the answers")
+	// クイック参加したまま回答を送っていない参加者がいて、ゲームを始められない
+	ErrUnansweredPlayers = errors.New("some players have not answered the questions yet")
+)
+
+// 同時に別のプレイヤーがサンタを固定して条件が外れたときの再試行回数
+const maxLockAttempts = 3
+
+type Question struct {
+	QuestionID     int               `json:"question_id" dynamodbav:"question_id"`
+	Statement      string            `json:"statement" dynamodbav:"statement"`
+	Statements     map[string]string `json:"statements" dynamodbav:"statements"`
+	Category       string            `json:"category" dynamodbav:"category"`
+	Tags           []string          `json:"tags" dynamodbav:"tags"`
+	Enabled        *bool             `json:"enabled" dynamodbav:"enabled"`
+	AudienceRating string            `json:"audience_rating" dynamodbav:"audience_rating"`
+	DeletedAt      int64             `json:"-" dynamodbav:"deleted_at,omitempty"`
+}
+
+// enabled が未設定の古い質問は有効として扱う。論理削除された質問は無効
+func (q Question) IsEnabled() bool {
+	return (q.Enabled == nil || *q.Enabled) && q.DeletedAt == 0
+}
+
+// 固定したラウンドと、そのラウンドの質問
+type LockedRound struct {
+	Room     Room
+	Question Question
+}
+
+type answer struct {
+	QuestionID string `dynamodbav:"question_id"`
+	Answer     bool   `dynamodbav:"answer"`
+}
+
+type answeringPlayer struct {
+	UserID  string   `dynamodbav:"user_id"`
+	Answers []answer `dynamodbav:"answers"`
+}
+
+// サンタと質問の選択に使うルームの項目
+type lockingRoom struct {
+	Room
+	PackID string `dynamodbav:"pack_id"`
+	// ルーム作成時に固定された質問
+	Questions []Question `dynamodbav:"questions"`
+}
+
+// ロビーのルームを始め、1ラウンド目を固定する。既に始まっていれば ErrAlreadyStarted を返す。
+// 投票の締め切りは now から数える
+func StartGame(ctx context.Context, db DB, cfg appconfig.Config, roomID string, now time.Time) (LockedRound, error) {
+	return lockRound(ctx, db, cfg, roomID, true, now)
+}
+
+// 今のラウンドのサンタ・質問・投票の締め切りを固定する。ロビーのルームならゲームを始める。
+// 何人が呼んでも最初に固定されたものを返す
+func LockRound(ctx context.Context, db DB, cfg appconfig.Config, roomID string, now time.Time) (LockedRound, error) {
+	return lockRound(ctx, db, cfg, roomID, false, now)
+}
+
+func lockRound(ctx context.Context, db DB, cfg appconfig.Config, roomID string, lobbyOnly bool, now time.Time) (LockedRound, error) {
+	for attempt := 1; ; attempt++ {
+		room, err := getLockingRoom(ctx, db, cfg, roomID)
+		if err != nil {
+			return LockedRound{}, err
+		}
+		switch {
+		case room.Status == StatusFinished:
+			return LockedRound{}, ErrGameOver
+		case lobbyOnly && room.Status != "" && room.Status != StatusLobby:
+			return LockedRound{}, ErrAlreadyStarted
+		case room.SantaID != "" && room.QuestionID != 0 && room.VotingDeadline != 0:
+			question, err := findQuestion(ctx, db, cfg, room, nil, room.QuestionID, now)
+			return LockedRound{Room: room.Room, Question: question}, err
+		}
+
+		santaID, candidates, err := chooseRound(ctx, db, cfg, room, now)
+		if err != nil {
+			return LockedRound{}, err
+		}
+		locked, err := writeLock(ctx, db, cfg, room, santaID, candidates[rand.Intn(len(candidates))].QuestionID, lobbyOnly, now)
+		var failed *types.ConditionalCheckFailedException
+		if errors.As(err, &failed) && attempt < maxLockAttempts {
+			// 読み直して、先に固定されたサンタで選び直す
+			continue
+		}
+		if err != nil {
+			return LockedRound{}, err
+		}
+		question, err := findQuestion(ctx, db, cfg, room, candidates, locked.QuestionID, now)
+		return LockedRound{Room: locked, Question: question}, err
+	}
+}
+
+// サンタと、そのサンタで出せる質問の候補を選ぶ。サンタが固定済みならそのサンタを使う
+func chooseRound(ctx context.Context, db DB, cfg appconfig.Config, room lockingRoom, now time.Time) (string, []Question, error) {
+	players, err := getAnsweringPlayers(ctx, db, cfg, room.Participants)
+	if err != nil {
+		return "", nil, err
+	}
+	if room.Status == "" || room.Status == StatusLobby {
+		for _, p := range players {
+			if len(p.Answers) == 0 {
+				return "", nil, ErrUnansweredPlayers
+			}
+		}
+	}
+	if len(room.Questions) > 0 {
+		var questionIDs []int
+		for _, q := range room.Questions {
+			questionIDs = append(questionIDs, q.QuestionID)
+		}
+		players = restrictAnswersToQuestions(players, questionIDs)
+	} else if room.PackID != "" {
+		pack, err := getQuestionPackIDs(ctx, db, cfg, room.PackID)
+		if err != nil {
+			return "", nil, err
+		}
+		players = restrictAnswersToQuestions(players, pack)
+	}
+
+	santaID := room.SantaID
+	if santaID == "" {
+		santaID = chooseSanta(players, room.RoundResults)
+		if santaID == "" {
+			return "", nil, ErrNoCandidates
+		}
+	}
+
+	questionIDs := santaOnlyFalseQuestions(players, santaID, cfg.Game.MinTrueAnswers)
+	candidates, err := getCandidateQuestions(ctx, db, cfg, room, questionIDs, now)
+	if err != nil {
+		return "", nil, err
+	}
+	candidates = excludeUsedQuestions(candidates, room.RoundResults)
+	if len(candidates) == 0 {
+		return "", nil, ErrNoCandidates
+	}
+	return santaID, candidates, nil
+}
+
+// サンタ・質問・投票の締め切りを1回の書き込みで固定する。
+// 別の呼び出しが違うサンタを先に固定していれば条件で失敗させ、読み直させる
+func writeLock(ctx context.Context, db DB, cfg appconfig.Config, room lockingRoom, santaID string, questionID int, lobbyOnly bool, now time.Time) (Room, error) {
+	values := map[string]types.AttributeValue{
+		":playing":  &types.AttributeValueMemberS{Value: StatusPlaying},
+		":santa":    &types.AttributeValueMemberS{Value: santaID},
+		":question": &types.AttributeValueMemberN{Value: strconv.Itoa(questionID)},
+		":deadline": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(cfg.VotingTimeout).Unix(), 10)},
+	}
+	condition := "(attribute_not_exists(#status) OR #status <> :finished) AND (attribute_not_exists(santa_id) OR santa_id = :santa)"
+	values[":finished"] = &types.AttributeValueMemberS{Value: StatusFinished}
+	if lobbyOnly {
+		condition = "attribute_not_exists(#status) OR #status = :lobby"
+		delete(values, ":finished")
+		values[":lobby"] = &types.AttributeValueMemberS{Value: StatusLobby}
+	}
+	response, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(cfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: room.RoomID},
+		},
+		UpdateExpression: aws.String("SET #status = :playing, santa_id = if_not_exists(santa_id, :santa), " +
+			"question_id = if_not_exists(question_id, :question), voting_deadline = if_not_exists(voting_deadline, :deadline), " +
+			"deadline = if_not_exists(voting_deadline, :deadline)"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return Room{}, err
+	}
+	var locked Room
+	err = attributevalue.UnmarshalMap(response.Attributes, &locked)
+	return locked, err
+}
+
+// 前のラウンドまでにサンタになったプレイヤーは、他に候補がいる限り選ばない
+func chooseSanta(players []answeringPlayer, previous []RoundResult) string {
+	wasSanta := make(map[string]bool)
+	for _, r := range previous {
+		wasSanta[r.SantaID] = true
+	}
+	var fresh []answeringPlayer
+	for _, p := range players {
+		if !wasSanta[p.UserID] {
+			fresh = append(fresh, p)
+		}
+	}
+	if len(fresh) > 0 && len(fresh) < len(players) {
+		if santaID := decideSanta(santaCandidates(fresh), players); santaID != "" {
+			return santaID
+		}
+	}
+	return decideSanta(santaCandidates(players), players)
+}
+
+// false の回答が最も多いプレイヤー
+func santaCandidates(players []answeringPlayer) []answeringPlayer {
+	var candidates []answeringPlayer
+	maxFalseCount := -1
+	for _, p := range players {
+		falseCount := 0
+		for _, ans := range p.Answers {
+			if !ans.Answer {
+				falseCount++
+			}
+		}
+		if falseCount > maxFalseCount {
+			candidates = []answeringPlayer{p}
+			maxFalseCount = falseCount
+		} else if falseCount == maxFalseCount {
+			candidates = append(candidates, p)
+		}
+	}
+	return candidates
+}
+
+// 候補のうち、多くのプレイヤーが true と答えた質問に false と答えたプレイヤーをサンタにする
+func decideSanta(candidates []answeringPlayer, players []answeringPlayer) string {
+	trueCounts := countTrueAnswers(players)
+	maxTrueCount := -1
+	var santaID string
+	for _, p := range candidates {
+		for _, ans := range p.Answers {
+			trueCount := trueCounts[ans.QuestionID]
+			if trueCount == 0 {
+				continue
+			}
+			if !ans.Answer && trueCount > maxTrueCount {
+				maxTrueCount = trueCount
+				santaID = p.UserID
+			}
+		}
+	}
+	return santaID
+}
+
+func countTrueAnswers(players []answeringPlayer) map[string]int {
+	counts := make(map[string]int)
+	for _, p := range players {
+		for _, ans := range p.Answers {
+			if ans.Answer {
+				counts[ans.QuestionID]++
+			}
+		}
+	}
+	return counts
+}
+
+// サンタが false と答え、minTrueAnswers 人以上が true と答えた質問
+func santaOnlyFalseQuestions(players []answeringPlayer, santaID string, minTrueAnswers int) []string {
+	trueCounts := countTrueAnswers(players)
+	var questionIDs []string
+	for _, p := range players {
+		if p.UserID != santaID {
+			continue
+		}
+		for _, ans := range p.Answers {
+			if !ans.Answer && trueCounts[ans.QuestionID] >= minTrueAnswers {
+				questionIDs = append(questionIDs, ans.QuestionID)
+			}
+		}
+	}
+	return questionIDs
+}
+
+// パックやルームの質問に含まれない質問への回答はサンタ選びにも出題にも使わない
+func restrictAnswersToQuestions(players []answeringPlayer, questionIDs []int) []answeringPlayer {
+	allowed := make(map[string]bool)
+	for _, id := range questionIDs {
+		allowed[strconv.Itoa(id)] = true
+	}
+	restricted := make([]answeringPlayer, 0, len(players))
+	for _, p := range players {
+		var answers []answer
+		for _, ans := range p.Answers {
+			if allowed[ans.QuestionID] {
+				answers = append(answers, ans)
+			}
+		}
+		p.Answers = answers
+		restricted = append(restricted, p)
+	}
+	return restricted
+}
+
+// 前のラウンドで出た質問は、他に候補がある限り出さない
+func excludeUsedQuestions(questions []Question, previous []RoundResult) []Question {
+	used := make(map[int]bool)
+	for _, r := range previous {
+		used[r.QuestionID] = true
+	}
+	var fresh []Question
+	for _, q := range questions {
+		if !used[q.QuestionID] {
+			fresh = append(fresh, q)
+		}
+	}
+	if len(fresh) == 0 {
+		return questions
+	}
+	return fresh
+}
+
+// ルームに固定された質問があればそこから選ぶ。後から質問が編集・無効化されても進行中のゲームは変わらない
+func getCandidateQuestions(ctx context.Context, db DB, cfg appconfig.Config, room lockingRoom, questionIDs []string, now time.Time) ([]Question, error) {
+	if len(room.Questions) > 0 {
+		byID := make(map[string]Question, len(room.Questions))
+		for _, q := range room.Questions {
+			byID[strconv.Itoa(q.QuestionID)] = q
+		}
+		var candidates []Question
+		for _, id := range questionIDs {
+			if q, ok := byID[id]; ok {
+				candidates = append(candidates, q)
+			}
+		}
+		return candidates, nil
+	}
+
+	// 質問が固定される前に作られた古いルームは、テーブルにあって有効な質問を使う
+	var intIDs []int
+	for _, id := range questionIDs {
+		intID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, err
+		}
+		intIDs = append(intIDs, intID)
+	}
+	questions, err := getQuestions(ctx, db, cfg, intIDs, now)
+	if err != nil {
+		return nil, err
+	}
+	var enabled []Question
+	for _, id := range intIDs {
+		if q, ok := questions[id]; ok && q.IsEnabled() {
+			enabled = append(enabled, q)
+		}
+	}
+	return enabled, nil
+}
+
+// 固定された質問を候補かルームの質問から探す
+func findQuestion(ctx context.Context, db DB, cfg appconfig.Config, room lockingRoom, candidates []Question, questionID int, now time.Time) (Question, error) {
+	for _, q := range candidates {
+		if q.QuestionID == questionID {
+			return q, nil
+		}
+	}
+	for _, q := range room.Questions {
+		if q.QuestionID == questionID {
+			return q, nil
+		}
+	}
+	questions, err := getQuestions(ctx, db, cfg, []int{questionID}, now)
+	if err != nil {
+		return Question{}, err
+	}
+	q, ok := questions[questionID]
+	if !ok {
+		return Question{}, fmt.Errorf("question %d of the round was not found", questionID)
+	}
+	return q, nil
+}
+
+type cachedQuestion struct {
+	question  Question
+	expiresAt time.Time
+}
+
+// ウォームなコンテナでは質問をキャッシュして DynamoDB への問い合わせを減らす
+var questionCache = map[int]cachedQuestion{}
+
+// question_id をキーに質問をまとめて取得する (キャッシュにないものだけ BatchGetItem)
+func getQuestions(ctx context.Context, db DB, cfg appconfig.Config, questionIDs []int, now time.Time) (map[int]Question, error) {
+	found := make(map[int]Question)
+	seen := make(map[int]bool)
+	var missing []int
+	for _, id := range questionIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if c, ok := questionCache[id]; ok && now.Before(c.expiresAt) {
+			found[id] = c.question
+			continue
+		}
+		missing = append(missing, id)
+	}
+
+	// BatchGetItem は1回あたり100件まで
+	for len(missing) > 0 {
+		n := min(len(missing), 100)
+		var keys []map[string]types.AttributeValue
+		for _, id := range missing[:n] {
+			keys = append(keys, map[string]types.AttributeValue{
+				"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(id)},
+			})
+		}
+		missing = missing[n:]
+
+		requestItems := map[string]types.KeysAndAttributes{
+			cfg.QuestionTableName: {Keys: keys},
+		}
+		for len(requestItems) > 0 {
+			response, err := db.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return nil, err
+			}
+			var questions []Question
+			if err = attributevalue.UnmarshalListOfMaps(response.Responses[cfg.QuestionTableName], &questions); err != nil {
+				return nil, err
+			}
+			for _, q := range questions {
+				found[q.QuestionID] = q
+				questionCache[q.QuestionID] = cachedQuestion{question: q, expiresAt: now.Add(cfg.QuestionCacheTTL)}
+			}
+			requestItems = response.UnprocessedKeys
+		}
+	}
+	return found, nil
+}
+
+func getQuestionPackIDs(ctx context.Context, db DB, cfg appconfig.Config, packID string) ([]int, error) {
+	response, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(cfg.PackTableName),
+		Key: map[string]types.AttributeValue{
+			"pack_id": &types.AttributeValueMemberS{Value: packID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Item == nil {
+		return nil, fmt.Errorf("question pack %s of the room was not found", packID)
+	}
+	var pack struct {
+		QuestionIDs []int `dynamodbav:"question_ids"`
+	}
+	err = attributevalue.UnmarshalMap(response.Item, &pack)
+	return pack.QuestionIDs, err
+}
+
+func getLockingRoom(ctx context.Context, db DB, cfg appconfig.Config, roomID string) (lockingRoom, error) {
+	response, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(cfg.RoomTableName),
+		Key: map[string]types.AttributeValue{
+			"room_id": &types.AttributeValueMemberS{Value: roomID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return lockingRoom{}, err
+	}
+	if response.Item == nil {
+		return lockingRoom{}, fmt.Errorf("room %s not found", roomID)
+	}
+	var room lockingRoom
+	err = attributevalue.UnmarshalMap(response.Item, &room)
+	return room, err
+}
+
+// 途中で抜けたプレイヤーはサンタ選びに使わない
+func getAnsweringPlayers(ctx context.Context, db DB, cfg appconfig.Config, participants []string) ([]answeringPlayer, error) {
+	var players []answeringPlayer
+	for _, participant := range participants {
+		response, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(cfg.UserTableName),
+			Key: map[string]types.AttributeValue{
+				"user_id": &types.AttributeValueMemberS{Value: participant},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if response.Item == nil {
+			continue
+		}
+		var p answeringPlayer
+		if err = attributevalue.UnmarshalMap(response.Item, &p); err != nil {
+			return nil, err
+		}
+		players = append(players, p)
+	}
+	return players, nil
+}
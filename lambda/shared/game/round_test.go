@@ -0,0 +1,158 @@
+package game
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func answers(values ...bool) []answer {
+	var as []answer
+	for i, v := range values {
+		as = append(as, answer{QuestionID: string(rune('1' + i)), Answer: v})
+	}
+	return as
+}
+
+func TestChooseSanta(t *testing.T) {
+	players := []answeringPlayer{
+		{UserID: "a", Answers: answers(false, false, true)},
+		{UserID: "b", Answers: answers(true, false, true)},
+		{UserID: "c", Answers: answers(true, true, true)},
+	}
+	if got := chooseSanta(players, nil); got != "a" {
+		t.Errorf("chooseSanta = %q, want a", got)
+	}
+	// 前のラウンドのサンタは他に候補がいれば選ばない
+	if got := chooseSanta(players, []RoundResult{{SantaID: "a"}}); got != "b" {
+		t.Errorf("chooseSanta after a = %q, want b", got)
+	}
+	if got := santaOnlyFalseQuestions(players, "a", 2); len(got) != 1 || got[0] != "1" {
+		t.Errorf("santaOnlyFalseQuestions = %v, want [1]", got)
+	}
+}
+
+func lockTestDB(t *testing.T, room lockingRoom) *fakeDB {
+	t.Helper()
+	roomItem, err := attributevalue.MarshalMap(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]map[string]types.AttributeValue{}
+	for id, as := range map[string][]answer{
+		"a": answers(false, false),
+		"b": answers(true, true),
+		"c": answers(true, true),
+	} {
+		item, err := attributevalue.MarshalMap(answeringPlayer{UserID: id, Answers: as})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[id] = item
+	}
+	return &fakeDB{items: map[string]map[string]map[string]types.AttributeValue{
+		testCfg.RoomTableName: {room.RoomID: roomItem},
+		testCfg.UserTableName: users,
+	}}
+}
+
+func TestLockRound(t *testing.T) {
+	questions := []Question{{QuestionID: 1, Statement: "one"}, {QuestionID: 2, Statement: "two"}}
+	lobby := lockingRoom{Room: Room{RoomID: "r", Status: StatusLobby, Participants: []string{"a", "b", "c"}}, Questions: questions}
+	now := time.Unix(1700000000, 0)
+
+	t.Run("start locks santa, question and deadline together", func(t *testing.T) {
+		db := lockTestDB(t, lobby)
+		locked, err := StartGame(context.Background(), db, testCfg, "r", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 締め切りは呼び出し側の時計から数える
+		if locked.Room.SantaID != "a" || locked.Room.Status != StatusPlaying || locked.Room.VotingDeadline != now.Add(testCfg.VotingTimeout).Unix() {
+			t.Errorf("room = %+v", locked.Room)
+		}
+		if locked.Question.Statement == "" || locked.Question.QuestionID != locked.Room.QuestionID {
+			t.Errorf("question = %+v", locked.Question)
+		}
+		if len(db.updates) != 1 {
+			t.Fatalf("updates = %d, want 1", len(db.updates))
+		}
+		update := db.updates[0]
+		for _, attr := range []string{"santa_id", "question_id", "voting_deadline"} {
+			if !strings.Contains(aws.ToString(update.UpdateExpression), attr) {
+				t.Errorf("update %q does not set %s", aws.ToString(update.UpdateExpression), attr)
+			}
+		}
+		// 使わない値を渡すと DynamoDB が拒否する
+		if _, ok := update.ExpressionAttributeValues[":finished"]; ok {
+			t.Errorf("unused :finished in %v", update.ExpressionAttributeValues)
+		}
+	})
+
+	t.Run("start on a playing room", func(t *testing.T) {
+		playing := lobby
+		playing.Status = StatusPlaying
+		db := lockTestDB(t, playing)
+		if _, err := StartGame(context.Background(), db, testCfg, "r", now); !errors.Is(err, ErrAlreadyStarted) {
+			t.Errorf("err = %v, want ErrAlreadyStarted", err)
+		}
+		if len(db.updates) != 0 {
+			t.Errorf("updates = %d, want 0", len(db.updates))
+		}
+	})
+
+	t.Run("a locked round is returned as is", func(t *testing.T) {
+		playing := lobby
+		playing.Status = StatusPlaying
+		playing.SantaID, playing.QuestionID, playing.VotingDeadline = "b", 2, 100
+		db := lockTestDB(t, playing)
+		locked, err := LockRound(context.Background(), db, testCfg, "r", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked.Room.SantaID != "b" || locked.Question.Statement != "two" || len(db.updates) != 0 {
+			t.Errorf("locked = %+v, updates = %d", locked, len(db.updates))
+		}
+	})
+
+	t.Run("a quick-joined player has not answered yet", func(t *testing.T) {
+		db := lockTestDB(t, lobby)
+		unanswered, err := attributevalue.MarshalMap(answeringPlayer{UserID: "c", Answers: []answer{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.items[testCfg.UserTableName]["c"] = unanswered
+		if _, err := StartGame(context.Background(), db, testCfg, "r", now); !errors.Is(err, ErrUnansweredPlayers) {
+			t.Errorf("err = %v, want ErrUnansweredPlayers", err)
+		}
+		if len(db.updates) != 0 {
+			t.Errorf("updates = %d, want 0", len(db.updates))
+		}
+	})
+
+	t.Run("finished room", func(t *testing.T) {
+		finished := lobby
+		finished.Status = StatusFinished
+		db := lockTestDB(t, finished)
+		if _, err := LockRound(context.Background(), db, testCfg, "r", now); !errors.Is(err, ErrGameOver) {
+			t.Errorf("err = %v, want ErrGameOver", err)
+		}
+	})
+
+	t.Run("lost the race to another caller", func(t *testing.T) {
+		db := lockTestDB(t, lobby)
+		db.updateErr = &types.ConditionalCheckFailedException{}
+		if _, err := LockRound(context.Background(), db, testCfg, "r", now); err == nil {
+			t.Fatal("err = nil")
+		}
+		if len(db.updates) != maxLockAttempts {
+			t.Errorf("updates = %d, want %d", len(db.updates), maxLockAttempts)
+		}
+	})
+}
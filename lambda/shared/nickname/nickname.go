@@ -0,0 +1,42 @@
+// nickname はプレイヤーのニックネームを正規化し、ルーム内の重複と禁止語を判定する。参加とクイック参加で同じ規則を使う
+package nickname
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NFKC で正規化し、前後の空白を除いたニックネームを返す。全角英数字と半角カナもここで揃う
+func Normalize(nickname string, maxLength int) (string, error) {
+	nickname = strings.TrimSpace(norm.NFKC.String(nickname))
+	if nickname == "" {
+		return "", errors.New("nickname is empty")
+	}
+	if strings.IndexFunc(nickname, unicode.IsControl) >= 0 {
+		return "", errors.New("nickname contains control characters")
+	}
+	if utf8.RuneCountInString(nickname) > maxLength {
+		return "", fmt.Errorf("nickname must be at most %d characters", maxLength)
+	}
+	return nickname, nil
+}
+
+// 重複の判定に使うキー。大文字と小文字は区別しない
+func Key(nickname string) string {
+	return strings.ToLower(norm.NFKC.String(nickname))
+}
+
+func ContainsBlockedWord(nickname string, blocklist []string) bool {
+	key := Key(nickname)
+	for _, w := range blocklist {
+		if strings.Contains(key, Key(w)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package nickname
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "  Ａｌｉｃｅ ", want: "Alice"},
+		{in: "ｻﾝﾀ", want: "サンタ"},
+		{in: "   ", wantErr: true},
+		{in: "a\tb", wantErr: true},
+		{in: "abcdefghijk", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.in, 10)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	if Key("ＡＬＩＣＥ") != Key("alice") {
+		t.Errorf("Key(%q) = %q, want %q", "ＡＬＩＣＥ", Key("ＡＬＩＣＥ"), Key("alice"))
+	}
+}
+
+func TestContainsBlockedWord(t *testing.T) {
+	blocklist := []string{"ＢＡＤ"}
+	if !ContainsBlockedWord("notbadatall", blocklist) {
+		t.Error("blocked word is not found")
+	}
+	if ContainsBlockedWord("alice", blocklist) {
+		t.Error("alice is blocked")
+	}
+}
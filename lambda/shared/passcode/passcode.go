@@ -0,0 +1,204 @@
+// passcode はプライベートルームの合言葉を扱う。ハッシュは bcrypt で作り、間違えた回数は接続元ごととルーム全体で数える
+package passcode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/crypto/bcrypt"
+
+	"shared/appconfig"
+)
+
+const (
+	MinLength = 6
+	MaxLength = 64
+	// bcrypt はこれより長いバイト列を扱えない
+	maxBytes = 72
+)
+
+// 合言葉を付けられるか確かめる。長さは rune で数える
+func Validate(passcode string) error {
+	if n := utf8.RuneCountInString(passcode); n < MinLength || n > MaxLength {
+		return fmt.Errorf("passcode must be %d to %d characters", MinLength, MaxLength)
+	}
+	if len(passcode) > maxBytes {
+		return fmt.Errorf("passcode must be at most %d bytes", maxBytes)
+	}
+	return nil
+}
+
+// ルームに保存する bcrypt のハッシュを返す
+func Hash(passcode string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// ルームが持つ合言葉の bcrypt のハッシュ。ハッシュがなければ誰でも参加できる
+type Lock struct {
+	PasscodeHash string `json:"-" dynamodbav:"passcode_hash,omitempty"`
+}
+
+func (l Lock) matches(passcode string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(l.PasscodeHash), []byte(passcode)) == nil
+}
+
+type Result int
+
+const (
+	OK Result = iota
+	Required
+	Invalid
+	Locked
+)
+
+// Check が使う DynamoDB の操作。テストでは差し替えられる
+type DB interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// 接続元ごと、またはルーム全体の失敗回数
+type attempt struct {
+	Failures    int   `dynamodbav:"failures"`
+	WindowEnd   int64 `dynamodbav:"window_end"`
+	LockedUntil int64 `dynamodbav:"locked_until"`
+}
+
+// 合言葉を確かめる。間違えた回数はルームと接続元 (client) の組ごとに数え、
+// 上限に達した接続元からはしばらく確かめもしない。ほかの参加者は締め出さない。
+// 接続元を変えながらの総当たりに備えてルーム全体でも数え、PasscodeRoomMaxAttempts に
+// 達したらしばらくは誰の合言葉も確かめない
+func Check(ctx context.Context, db DB, cfg appconfig.Config, roomID, client string, lock Lock, passcode string, now time.Time) (Result, error) {
+	if lock.PasscodeHash == "" {
+		return OK, nil
+	}
+	clientKey, roomKey := attemptKey(roomID, client), roomAttemptKey(roomID)
+	for _, key := range []map[string]types.AttributeValue{clientKey, roomKey} {
+		locked, err := isLocked(ctx, db, cfg, key, now)
+		if err != nil {
+			return Invalid, err
+		}
+		if locked {
+			return Locked, nil
+		}
+	}
+	if passcode == "" {
+		return Required, nil
+	}
+	if lock.matches(passcode) {
+		return OK, nil
+	}
+	if err := recordFailure(ctx, db, cfg, clientKey, cfg.PasscodeMaxAttempts, now); err != nil {
+		return Invalid, err
+	}
+	if err := recordFailure(ctx, db, cfg, roomKey, cfg.PasscodeRoomMaxAttempts, now); err != nil {
+		return Invalid, err
+	}
+	return Invalid, nil
+}
+
+func isLocked(ctx context.Context, db DB, cfg appconfig.Config, key map[string]types.AttributeValue, now time.Time) (bool, error) {
+	response, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(cfg.PasscodeAttemptTableName),
+		Key:       key,
+	})
+	if err != nil {
+		return false, err
+	}
+	var current attempt
+	if err = attributevalue.UnmarshalMap(response.Item, &current); err != nil {
+		return false, err
+	}
+	return current.LockedUntil > now.Unix(), nil
+}
+
+// 接続元はハッシュにしてから保存する
+func attemptKey(roomID, client string) map[string]types.AttributeValue {
+	sum := sha256.Sum256([]byte(client))
+	return map[string]types.AttributeValue{
+		"attempt_id": &types.AttributeValueMemberS{Value: roomID + "#" + hex.EncodeToString(sum[:])},
+	}
+}
+
+// ルーム全体の失敗回数。接続元のハッシュ (16進) とは重ならない
+func roomAttemptKey(roomID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"attempt_id": &types.AttributeValueMemberS{Value: roomID + "#room"},
+	}
+}
+
+// PasscodeLockout の窓ごとに失敗を数え、limit に達したらロックする。
+// 項目は窓かロックが終われば TTL で消える
+func recordFailure(ctx context.Context, db DB, cfg appconfig.Config, key map[string]types.AttributeValue, limit int, now time.Time) error {
+	until := &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(cfg.PasscodeLockout).Unix(), 10)}
+
+	// 前の窓が終わっていれば数え直す
+	_, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(cfg.PasscodeAttemptTableName),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET failures = :one, window_end = :until, #ttl = :until"),
+		ConditionExpression: aws.String("attribute_not_exists(window_end) OR window_end < :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl": "TTL",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":   &types.AttributeValueMemberN{Value: "1"},
+			":until": until,
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if !errors.As(err, &failed) {
+		if err != nil || limit > 1 {
+			return err
+		}
+		return lockOut(ctx, db, cfg, key, until)
+	}
+
+	out, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(cfg.PasscodeAttemptTableName),
+		Key:              key,
+		UpdateExpression: aws.String("ADD failures :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return err
+	}
+	var counter attempt
+	if err = attributevalue.UnmarshalMap(out.Attributes, &counter); err != nil {
+		return err
+	}
+	if counter.Failures < limit {
+		return nil
+	}
+	return lockOut(ctx, db, cfg, key, until)
+}
+
+func lockOut(ctx context.Context, db DB, cfg appconfig.Config, key map[string]types.AttributeValue, until types.AttributeValue) error {
+	_, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(cfg.PasscodeAttemptTableName),
+		Key:              key,
+		UpdateExpression: aws.String("SET locked_until = :until, #ttl = :until"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl": "TTL",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":until": until,
+		},
+	})
+	return err
+}
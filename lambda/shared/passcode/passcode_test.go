@@ -0,0 +1,179 @@
+package passcode
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+// attempt_id -> 失敗回数などの項目
+type fakeDB struct {
+	items map[string]attempt
+}
+
+func (f *fakeDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	item, ok := f.items[attemptID(params.Key)]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: map[string]types.AttributeValue{
+		"failures":     number(int64(item.Failures)),
+		"window_end":   number(item.WindowEnd),
+		"locked_until": number(item.LockedUntil),
+	}}, nil
+}
+
+func (f *fakeDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	id := attemptID(params.Key)
+	item := f.items[id]
+	values := params.ExpressionAttributeValues
+	switch update := aws.ToString(params.UpdateExpression); {
+	case strings.HasPrefix(update, "SET failures"):
+		if _, ok := f.items[id]; ok && item.WindowEnd >= intValue(values[":now"]) {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+		item.Failures = 1
+		item.WindowEnd = intValue(values[":until"])
+	case strings.HasPrefix(update, "ADD failures"):
+		item.Failures++
+	case strings.HasPrefix(update, "SET locked_until"):
+		item.LockedUntil = intValue(values[":until"])
+	}
+	f.items[id] = item
+	return &dynamodb.UpdateItemOutput{Attributes: map[string]types.AttributeValue{
+		"failures": number(int64(item.Failures)),
+	}}, nil
+}
+
+func attemptID(key map[string]types.AttributeValue) string {
+	return key["attempt_id"].(*types.AttributeValueMemberS).Value
+}
+
+func number(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+func intValue(v types.AttributeValue) int64 {
+	n, _ := strconv.ParseInt(v.(*types.AttributeValueMemberN).Value, 10, 64)
+	return n
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		passcode string
+		wantErr  bool
+	}{
+		{passcode: "12345", wantErr: true},
+		{passcode: "123456"},
+		{passcode: "ろうそくの火"},
+		{passcode: strings.Repeat("a", MaxLength)},
+		{passcode: strings.Repeat("a", MaxLength+1), wantErr: true},
+		// 文字数は足りていても bcrypt が扱えない長さ
+		{passcode: strings.Repeat("火", 30), wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.passcode); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q) = %v, wantErr %v", tt.passcode, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLockMatches(t *testing.T) {
+	hash, err := Hash("candle-night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := Lock{PasscodeHash: hash}
+	if !lock.matches("candle-night") {
+		t.Error("bcrypt hash does not match its passcode")
+	}
+	if lock.matches("candle-nite") {
+		t.Error("bcrypt hash matches a wrong passcode")
+	}
+
+}
+
+func TestCheckLocksOutOnlyTheClient(t *testing.T) {
+	cfg := appconfig.Config{PasscodeAttemptTableName: "attempts", PasscodeMaxAttempts: 3, PasscodeRoomMaxAttempts: 10, PasscodeLockout: 5 * time.Minute}
+	hash, err := Hash("candle-night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := Lock{PasscodeHash: hash}
+	db := &fakeDB{items: map[string]attempt{}}
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	check := func(client, passcode string, at time.Time) Result {
+		t.Helper()
+		result, err := Check(ctx, db, cfg, "room", client, lock, passcode, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if got := check("198.51.100.1", "", now); got != Required {
+		t.Errorf("empty passcode = %v, want Required", got)
+	}
+	for i := 0; i < cfg.PasscodeMaxAttempts; i++ {
+		if got := check("198.51.100.1", "guess", now); got != Invalid {
+			t.Fatalf("attempt %d = %v, want Invalid", i+1, got)
+		}
+	}
+	if got := check("198.51.100.1", "candle-night", now); got != Locked {
+		t.Errorf("locked client with the right passcode = %v, want Locked", got)
+	}
+	if got := check("203.0.113.7", "candle-night", now); got != OK {
+		t.Errorf("another client = %v, want OK", got)
+	}
+	if got := check("198.51.100.1", "candle-night", now.Add(cfg.PasscodeLockout+time.Second)); got != OK {
+		t.Errorf("after the lockout = %v, want OK", got)
+	}
+}
+
+func TestCheckLocksOutTheRoom(t *testing.T) {
+	cfg := appconfig.Config{PasscodeAttemptTableName: "attempts", PasscodeMaxAttempts: 3, PasscodeRoomMaxAttempts: 5, PasscodeLockout: 5 * time.Minute}
+	hash, err := Hash("candle-night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := Lock{PasscodeHash: hash}
+	db := &fakeDB{items: map[string]attempt{}}
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	// 接続元を変えながら間違え続ける
+	for i := 0; i < cfg.PasscodeRoomMaxAttempts; i++ {
+		client := fmt.Sprintf("198.51.100.%d", i)
+		if got, err := Check(ctx, db, cfg, "room", client, lock, "guess", now); err != nil || got != Invalid {
+			t.Fatalf("attempt %d = %v, %v, want Invalid", i+1, got, err)
+		}
+	}
+	if got, err := Check(ctx, db, cfg, "room", "203.0.113.7", lock, "candle-night", now); err != nil || got != Locked {
+		t.Errorf("new client after the room-wide limit = %v, %v, want Locked", got, err)
+	}
+	if got, err := Check(ctx, db, cfg, "other", "203.0.113.7", lock, "candle-night", now); err != nil || got != OK {
+		t.Errorf("another room = %v, %v, want OK", got, err)
+	}
+	if got, err := Check(ctx, db, cfg, "room", "203.0.113.7", lock, "candle-night", now.Add(cfg.PasscodeLockout+time.Second)); err != nil || got != OK {
+		t.Errorf("after the lockout = %v, %v, want OK", got, err)
+	}
+}
+
+func TestCheckWithoutPasscode(t *testing.T) {
+	db := &fakeDB{items: map[string]attempt{}}
+	result, err := Check(context.Background(), db, appconfig.Config{}, "room", "198.51.100.1", Lock{}, "", time.Now())
+	if err != nil || result != OK {
+		t.Errorf("Check = %v, %v, want OK", result, err)
+	}
+}
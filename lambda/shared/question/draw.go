@@ -0,0 +1,193 @@
+// question は質問テーブルの質問を扱う。新しいルームに出す質問はここで選ぶ
+package question
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+var (
+	// 指定されたパックがない
+	ErrPackNotFound = errors.New("question pack not found")
+	// 有効な質問が1つもない
+	ErrNoQuestions = errors.New("no enabled questions to draw from")
+)
+
+// 質問テーブルの項目のうち、出題に使うもの
+type Question struct {
+	QuestionID int               `json:"question_id" dynamodbav:"question_id"`
+	Statement  string            `json:"statement" dynamodbav:"statement"`
+	Statements map[string]string `json:"statements" dynamodbav:"statements"`
+	Enabled    *bool             `json:"enabled" dynamodbav:"enabled"`
+	// 論理削除された日時。削除された質問は新しいルームに出さない
+	DeletedAt int64 `json:"-" dynamodbav:"deleted_at,omitempty"`
+}
+
+// enabled が未設定の古い質問は有効として扱う。論理削除された質問は無効
+func (q Question) IsEnabled() bool {
+	return (q.Enabled == nil || *q.Enabled) && q.DeletedAt == 0
+}
+
+// ルーム作成時に固定される質問 (後から質問を編集してもゲーム中の内容は変わらない)
+type RoomQuestion struct {
+	QuestionID int               `json:"question_id" dynamodbav:"question_id"`
+	Statement  string            `json:"statement" dynamodbav:"statement"`
+	Statements map[string]string `json:"statements" dynamodbav:"statements"`
+}
+
+// Draw が使う DynamoDB の操作。テストでは差し替えられる
+type DB interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// 新しいルームに出す質問を count 個まで選ぶ。パックを指定すればパックの順に、
+// しなければ質問テーブル全体からランダムに選んで question_id 順に並べる。
+// ルームの作成、再戦、クイック参加が同じ選び方をする
+func Draw(ctx context.Context, db DB, cfg appconfig.Config, packID string, count int) ([]RoomQuestion, error) {
+	var questions []RoomQuestion
+	if packID != "" {
+		pack, found, err := getPack(ctx, db, cfg, packID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, ErrPackNotFound
+		}
+		candidates, err := getQuestionsByID(ctx, db, cfg, pack.QuestionIDs)
+		if err != nil {
+			return nil, err
+		}
+		questions = pickQuestions(candidates, count)
+	} else {
+		candidates, err := scanQuestions(ctx, db, cfg)
+		if err != nil {
+			return nil, err
+		}
+		questions = drawQuestions(candidates, count)
+	}
+	if len(questions) == 0 {
+		return nil, ErrNoQuestions
+	}
+	return questions, nil
+}
+
+type questionPack struct {
+	PackID      string `dynamodbav:"pack_id"`
+	QuestionIDs []int  `dynamodbav:"question_ids"`
+}
+
+func getPack(ctx context.Context, db DB, cfg appconfig.Config, packID string) (questionPack, bool, error) {
+	resp, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(cfg.PackTableName),
+		Key: map[string]types.AttributeValue{
+			"pack_id": &types.AttributeValueMemberS{Value: packID},
+		},
+	})
+	if err != nil || resp.Item == nil {
+		return questionPack{}, false, err
+	}
+	var pack questionPack
+	err = attributevalue.UnmarshalMap(resp.Item, &pack)
+	return pack, true, err
+}
+
+// questionIDs の順に質問を返す。見つからない ID は飛ばす
+func getQuestionsByID(ctx context.Context, db DB, cfg appconfig.Config, questionIDs []int) ([]Question, error) {
+	found := make(map[int]Question, len(questionIDs))
+	// BatchGetItem は1回あたり100件まで
+	for start := 0; start < len(questionIDs); start += 100 {
+		var keys []map[string]types.AttributeValue
+		for _, id := range questionIDs[start:min(start+100, len(questionIDs))] {
+			keys = append(keys, map[string]types.AttributeValue{
+				"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(id)},
+			})
+		}
+		requestItems := map[string]types.KeysAndAttributes{
+			cfg.QuestionTableName: {Keys: keys},
+		}
+		for len(requestItems) > 0 {
+			resp, err := db.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return nil, err
+			}
+			var page []Question
+			if err = attributevalue.UnmarshalListOfMaps(resp.Responses[cfg.QuestionTableName], &page); err != nil {
+				return nil, err
+			}
+			for _, q := range page {
+				found[q.QuestionID] = q
+			}
+			requestItems = resp.UnprocessedKeys
+		}
+	}
+	// BatchGetItem は順番を保たないので並べ直す
+	var questions []Question
+	for _, id := range questionIDs {
+		if q, ok := found[id]; ok {
+			questions = append(questions, q)
+		}
+	}
+	return questions, nil
+}
+
+func scanQuestions(ctx context.Context, db DB, cfg appconfig.Config) ([]Question, error) {
+	var questions []Question
+	paginator := dynamodb.NewScanPaginator(db, &dynamodb.ScanInput{
+		TableName: aws.String(cfg.QuestionTableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var items []Question
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		questions = append(questions, items...)
+	}
+	return questions, nil
+}
+
+// 有効な質問から count 個をランダムに選び、question_id 順に並べる
+func drawQuestions(candidates []Question, count int) []RoomQuestion {
+	shuffled := slices.Clone(candidates)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	drawn := pickQuestions(shuffled, count)
+	sort.Slice(drawn, func(i, j int) bool { return drawn[i].QuestionID < drawn[j].QuestionID })
+	return drawn
+}
+
+// 無効な質問と削除された質問を除き、並び順のまま count 個まで選ぶ
+func pickQuestions(candidates []Question, count int) []RoomQuestion {
+	picked := make([]RoomQuestion, 0, min(len(candidates), count))
+	for _, q := range candidates {
+		if len(picked) == count {
+			break
+		}
+		if !q.IsEnabled() {
+			continue
+		}
+		picked = append(picked, RoomQuestion{
+			QuestionID: q.QuestionID,
+			Statement:  q.Statement,
+			Statements: q.Statements,
+		})
+	}
+	return picked
+}
@@ -0,0 +1,119 @@
+package question
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+var testCfg = appconfig.Config{QuestionTableName: "questions", PackTableName: "packs"}
+
+// 質問テーブルとパックテーブルの中身を返す
+type fakeDB struct {
+	questions []Question
+	packs     map[string][]int
+}
+
+func (f *fakeDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	id := params.Key["pack_id"].(*types.AttributeValueMemberS).Value
+	ids, ok := f.packs[id]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	item, err := attributevalue.MarshalMap(questionPack{PackID: id, QuestionIDs: ids})
+	return &dynamodb.GetItemOutput{Item: item}, err
+}
+
+func (f *fakeDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	want := map[int]bool{}
+	for _, key := range params.RequestItems[testCfg.QuestionTableName].Keys {
+		id, _ := strconv.Atoi(key["question_id"].(*types.AttributeValueMemberN).Value)
+		want[id] = true
+	}
+	var items []map[string]types.AttributeValue
+	// 並び順は保証されないので逆順で返す
+	for i := len(f.questions) - 1; i >= 0; i-- {
+		if q := f.questions[i]; want[q.QuestionID] {
+			item, err := attributevalue.MarshalMap(q)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	return &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]types.AttributeValue{testCfg.QuestionTableName: items}}, nil
+}
+
+func (f *fakeDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	var items []map[string]types.AttributeValue
+	for _, q := range f.questions {
+		item, err := attributevalue.MarshalMap(q)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return &dynamodb.ScanOutput{Items: items}, nil
+}
+
+func questionIDs(questions []RoomQuestion) []int {
+	var ids []int
+	for _, q := range questions {
+		ids = append(ids, q.QuestionID)
+	}
+	return ids
+}
+
+func TestDraw(t *testing.T) {
+	disabled := false
+	db := &fakeDB{
+		questions: []Question{
+			{QuestionID: 1, Statement: "one"},
+			{QuestionID: 2, Statement: "two", Enabled: &disabled},
+			{QuestionID: 3, Statement: "three", DeletedAt: 1700000000},
+			{QuestionID: 4, Statement: "four"},
+			{QuestionID: 5, Statement: "five"},
+		},
+		packs: map[string][]int{"p": {5, 2, 3, 1, 4}, "off": {2, 3}},
+	}
+	ctx := context.Background()
+
+	t.Run("pack keeps its order and skips disabled questions", func(t *testing.T) {
+		got, err := Draw(ctx, db, testCfg, "p", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := questionIDs(got); len(ids) != 2 || ids[0] != 5 || ids[1] != 1 {
+			t.Errorf("question ids = %v, want [5 1]", ids)
+		}
+	})
+
+	t.Run("without a pack questions are sorted by id", func(t *testing.T) {
+		got, err := Draw(ctx, db, testCfg, "", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := questionIDs(got); len(ids) != 3 || ids[0] != 1 || ids[1] != 4 || ids[2] != 5 {
+			t.Errorf("question ids = %v, want [1 4 5]", ids)
+		}
+	})
+
+	t.Run("missing pack", func(t *testing.T) {
+		if _, err := Draw(ctx, db, testCfg, "missing", 2); !errors.Is(err, ErrPackNotFound) {
+			t.Errorf("err = %v, want ErrPackNotFound", err)
+		}
+	})
+
+	t.Run("pack without enabled questions", func(t *testing.T) {
+		if _, err := Draw(ctx, db, testCfg, "off", 2); !errors.Is(err, ErrNoQuestions) {
+			t.Errorf("err = %v, want ErrNoQuestions", err)
+		}
+	})
+}
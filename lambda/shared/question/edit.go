@@ -0,0 +1,129 @@
+package question
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"shared/appconfig"
+)
+
+var (
+	// If-Match が ETag として読めない
+	ErrInvalidETag = errors.New("If-Match must be an ETag returned by this API")
+	// 弱い ETag は If-Match の強い比較で一致しない
+	ErrWeakETag = errors.New("If-Match must be a strong ETag")
+)
+
+// 管理 API で作成・編集する質問の項目
+type Content struct {
+	QuestionID     int               `json:"question_id" dynamodbav:"question_id"`
+	Statement      string            `json:"statement" dynamodbav:"statement"`
+	Statements     map[string]string `json:"statements" dynamodbav:"statements"`
+	Category       string            `json:"category" dynamodbav:"category"`
+	Tags           []string          `json:"tags" dynamodbav:"tags"`
+	Enabled        *bool             `json:"enabled" dynamodbav:"enabled"`
+	AudienceRating string            `json:"audience_rating" dynamodbav:"audience_rating"`
+}
+
+// 版と削除日時を含めた質問テーブルの項目
+type Versioned struct {
+	Content
+	// 書き込むたびに1つ増える版。ETag として返し、If-Match で照合する
+	Version int `json:"version" dynamodbav:"version"`
+	// 論理削除された日時。ルームは作成時に文面を固定しているので、削除後も進行中のゲームは続けられる
+	DeletedAt int64 `json:"deleted_at,omitempty" dynamodbav:"deleted_at,omitempty"`
+}
+
+// 対象年齢の区分 (all は誰にでも出してよい質問)
+var audienceRatings = map[string]bool{"all": true, "teen": true, "adult": true}
+
+// 省略された項目を既定値で埋め、不正な値があればエラーを返す。一括の作成と1件の編集が同じ規則を使う
+func (c *Content) Normalize(defaultLocale string) error {
+	if c.QuestionID <= 0 {
+		return fmt.Errorf("question_id must be positive: %d", c.QuestionID)
+	}
+	if strings.TrimSpace(c.Statement) == "" {
+		return fmt.Errorf("question %d has empty statement", c.QuestionID)
+	}
+	// statement は既定のロケールの文面として statements にも入れておく
+	statements := map[string]string{defaultLocale: c.Statement}
+	for locale, statement := range c.Statements {
+		if strings.TrimSpace(statement) == "" {
+			return fmt.Errorf("question %d has empty statement for locale %q", c.QuestionID, locale)
+		}
+		statements[strings.ToLower(locale)] = statement
+	}
+	c.Statements = statements
+	if c.Category == "" {
+		c.Category = "general"
+	}
+	if c.Tags == nil {
+		c.Tags = []string{}
+	}
+	if c.Enabled == nil {
+		enabled := true
+		c.Enabled = &enabled
+	}
+	if c.AudienceRating == "" {
+		c.AudienceRating = "all"
+	}
+	if !audienceRatings[c.AudienceRating] {
+		return fmt.Errorf("question %d has unknown audience_rating: %q", c.QuestionID, c.AudienceRating)
+	}
+	return nil
+}
+
+// 質問を版つきで読む。論理削除された質問も返す
+func Get(ctx context.Context, db DB, cfg appconfig.Config, questionID int) (Versioned, bool, error) {
+	result, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(cfg.QuestionTableName),
+		Key: map[string]types.AttributeValue{
+			"question_id": &types.AttributeValueMemberN{Value: strconv.Itoa(questionID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return Versioned{}, false, err
+	}
+	var q Versioned
+	err = attributevalue.UnmarshalMap(result.Item, &q)
+	return q, true, err
+}
+
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// If-Match の値から版を読む。* はどの版にも一致する。
+// 版は文面の変更をすべて表すので、W/ の付いた弱い ETag は受け付けない
+func ParseIfMatch(value string, current int) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return current, nil
+	}
+	if strings.HasPrefix(value, "W/") {
+		return 0, ErrWeakETag
+	}
+	version, err := strconv.Atoi(strings.Trim(value, `"`))
+	if err != nil || version < 0 {
+		return 0, ErrInvalidETag
+	}
+	return version, nil
+}
+
+// 期待した版のときだけ書き込む条件。#version と :expected を使う。
+// 版を持たない古い質問は版 0 として扱う
+func VersionCondition(version int) string {
+	if version == 0 {
+		return "attribute_not_exists(#version)"
+	}
+	return "#version = :expected"
+}
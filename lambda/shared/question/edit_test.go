@@ -0,0 +1,93 @@
+package question
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestNormalize(t *testing.T) {
+	c := Content{QuestionID: 1, Statement: "料理は好きですか？", Statements: map[string]string{"EN": "Do you like cooking?"}}
+	if err := c.Normalize("ja"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Statements["ja"] != c.Statement || c.Statements["en"] == "" {
+		t.Errorf("statements = %v", c.Statements)
+	}
+	if c.Category != "general" || c.Tags == nil || c.Enabled == nil || !*c.Enabled || c.AudienceRating != "all" {
+		t.Errorf("defaults = %+v", c)
+	}
+
+	for _, bad := range []Content{
+		{QuestionID: 0, Statement: "a"},
+		{QuestionID: 1, Statement: " "},
+		{QuestionID: 1, Statement: "a", Statements: map[string]string{"en": ""}},
+		{QuestionID: 1, Statement: "a", AudienceRating: "kids"},
+	} {
+		if err := bad.Normalize("ja"); err == nil {
+			t.Errorf("Normalize(%+v) = nil", bad)
+		}
+	}
+}
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr error
+	}{
+		{value: `"3"`, want: 3},
+		{value: `*`, want: 7},
+		{value: `W/"3"`, wantErr: ErrWeakETag},
+		{value: `"abc"`, wantErr: ErrInvalidETag},
+		{value: `"-1"`, wantErr: ErrInvalidETag},
+	}
+	for _, tt := range tests {
+		got, err := ParseIfMatch(tt.value, 7)
+		if !errors.Is(err, tt.wantErr) || (err == nil && got != tt.want) {
+			t.Errorf("ParseIfMatch(%q) = %d, %v, want %d, %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestVersionCondition(t *testing.T) {
+	if got := VersionCondition(0); got != "attribute_not_exists(#version)" {
+		t.Errorf("VersionCondition(0) = %q", got)
+	}
+	if got := VersionCondition(2); got != "#version = :expected" {
+		t.Errorf("VersionCondition(2) = %q", got)
+	}
+}
+
+// 質問テーブルの1件だけを返す
+type versionedDB struct {
+	fakeDB
+	item Versioned
+}
+
+func (v *versionedDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if v.item.QuestionID == 0 {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	item, err := attributevalue.MarshalMap(v.item)
+	return &dynamodb.GetItemOutput{Item: item}, err
+}
+
+func TestGet(t *testing.T) {
+	db := &versionedDB{item: Versioned{Content: Content{QuestionID: 4, Statement: "four"}, Version: 2, DeletedAt: 1700000000}}
+	q, found, err := Get(context.Background(), db, testCfg, 4)
+	if err != nil || !found {
+		t.Fatalf("Get = %v, %v", found, err)
+	}
+	// 論理削除された質問も版つきで返す
+	if q.Statement != "four" || q.Version != 2 || q.DeletedAt == 0 {
+		t.Errorf("q = %+v", q)
+	}
+
+	if _, found, err := Get(context.Background(), &versionedDB{}, testCfg, 5); err != nil || found {
+		t.Errorf("Get missing = %v, %v", found, err)
+	}
+}
@@ -4,17 +4,26 @@ import * as apigateway from 'aws-cdk-lib/aws-apigateway';
 import * as lambda from 'aws-cdk-lib/aws-lambda';
 import { DockerImage } from 'aws-cdk-lib';
 import * as cr from 'aws-cdk-lib/custom-resources';
+import * as events from 'aws-cdk-lib/aws-events';
+import * as targets from 'aws-cdk-lib/aws-events-targets';
 
 export class CandleBackendStack extends cdk.Stack {
   constructor(scope: Construct, id: string, props?: cdk.StackProps) {
     super(scope, id, props);
 
+    // CORSで許可するオリジン (cdk.json の context で上書き可能)
+    const corsAllowOrigins: string[] = this.node.tryGetContext('corsAllowOrigins') ?? apigateway.Cors.ALL_ORIGINS;
+
     // Create API Gateway
     const api = new apigateway.RestApi(this, 'CandleBackendApi', {
       restApiName: 'CandleBackendApi',
+      // QR コードの PNG をバイナリで返す。Accept が image/png のときだけ変換されるので、qr の Lambda は Accept を見て PNG を返す。
+      // */* にするとリクエストボディまで base64 になり、JSON を受け取る Lambda がすべて壊れる
+      binaryMediaTypes: ['image/png'],
       defaultCorsPreflightOptions: {
-        allowOrigins: apigateway.Cors.ALL_ORIGINS,
-        allowHeaders: apigateway.Cors.DEFAULT_HEADERS,
+        allowOrigins: corsAllowOrigins,
+        // 質問の編集で版を照合する If-Match も許可する
+        allowHeaders: [...apigateway.Cors.DEFAULT_HEADERS, 'If-Match'],
         allowMethods: apigateway.Cors.ALL_METHODS,
       },
     });
@@ -29,28 +38,87 @@ export class CandleBackendStack extends cdk.Stack {
       tableName: 'CandleBackendRoomTable',
       timeToLiveAttribute: 'TTL',
     });
+    // 公開ルームだけが public_language を持つ疎なインデックス
+    roomTable.addGlobalSecondaryIndex({
+      indexName: 'PublicRoomIndex',
+      partitionKey: { name: 'public_language', type: cdk.aws_dynamodb.AttributeType.STRING },
+    });
+    // finalizer が締め切りを過ぎたルームを探す。deadline を持つロビーと投票中のルームだけが入る
+    roomTable.addGlobalSecondaryIndex({
+      indexName: 'DeadlineIndex',
+      partitionKey: { name: 'status', type: cdk.aws_dynamodb.AttributeType.STRING },
+      sortKey: { name: 'deadline', type: cdk.aws_dynamodb.AttributeType.NUMBER },
+    });
 
     const userTable = new cdk.aws_dynamodb.Table(this, 'CandleBackendUserTable', {
       partitionKey: { name: 'user_id', type: cdk.aws_dynamodb.AttributeType.STRING },
       tableName: 'CandleBackendUserTable',
     });
 
+    const packTable = new cdk.aws_dynamodb.Table(this, 'CandleBackendQuestionPackTable', {
+      partitionKey: { name: 'pack_id', type: cdk.aws_dynamodb.AttributeType.STRING },
+      tableName: 'CandleBackendQuestionPackTable',
+    });
+
+    // 運営用の API キー。tools/apikey で発行し、secret はハッシュだけを保存する
+    const apiKeyTable = new cdk.aws_dynamodb.Table(this, 'CandleBackendApiKeyTable', {
+      partitionKey: { name: 'key_id', type: cdk.aws_dynamodb.AttributeType.STRING },
+      tableName: 'CandleBackendApiKeyTable',
+    });
+
+    // 合言葉を間違えた回数を「ルーム#接続元のハッシュ」ごとに数える。窓かロックが終われば TTL で消える
+    const passcodeAttemptTable = new cdk.aws_dynamodb.Table(this, 'CandleBackendPasscodeAttemptTable', {
+      partitionKey: { name: 'attempt_id', type: cdk.aws_dynamodb.AttributeType.STRING },
+      tableName: 'CandleBackendPasscodeAttemptTable',
+      timeToLiveAttribute: 'TTL',
+    });
+
+    // 全Lambdaで共通の設定 (lambda/shared/appconfig で検証される)
+    const commonEnvironment = {
+      ROOM_TTL: '12h',
+      CORS_ALLOW_ORIGINS: corsAllowOrigins.join(','),
+      // WebSocket サーバーの通知先 (例: http://<ALB>/publish)。未設定なら通知しない
+      NOTIFY_URL: this.node.tryGetContext('notifyUrl') ?? '',
+      NOTIFY_TOKEN: this.node.tryGetContext('notifyToken') ?? '',
+      // ニックネームに使えない語 (カンマ区切り)
+      NICKNAME_BLOCKLIST: this.node.tryGetContext('nicknameBlocklist') ?? '',
+      // QR コードに埋め込む参加ページの URL
+      JOIN_URL: this.node.tryGetContext('joinUrl') ?? '',
+    };
+    // テーブル名は各Lambdaが使うものだけを渡す。Lambda側は appconfig.Load に渡したテーブルだけを必須にする
+    const tableEnvironmentNames = new Map<cdk.aws_dynamodb.Table, string>([
+      [roomTable, 'ROOM_TABLE_NAME'],
+      [userTable, 'USER_TABLE_NAME'],
+      [questionTable, 'QUESTION_TABLE_NAME'],
+      [packTable, 'PACK_TABLE_NAME'],
+      [apiKeyTable, 'API_KEY_TABLE_NAME'],
+      [passcodeAttemptTable, 'PASSCODE_ATTEMPT_TABLE_NAME'],
+    ]);
+    const environmentWith = (...tables: cdk.aws_dynamodb.Table[]) => ({
+      ...commonEnvironment,
+      ...Object.fromEntries(tables.map((table) => [tableEnvironmentNames.get(table)!, table.tableName])),
+    });
 
     // Resolve requests with Lambda
     //bundlingの設定を書く必要があった
     //ref:https://iret.media/79515
-    const goLambdaBundleConfig ={
+    // 各Lambdaは lambda/shared を replace で参照するので、lambda ディレクトリごとマウントしてからビルドする
+    const goLambdaCode = (dir: string) => lambda.Code.fromAsset('lambda', {
+      // 手元で go build したバイナリをアセットに含めない (.gitignore と同じ規則)
+      ignoreMode: cdk.IgnoreMode.GIT,
+      exclude: ['*', '!*/', '!*.go', '!go.mod', '!go.sum'],
       bundling: {
         image: DockerImage.fromRegistry("golang:1.21"),
         command: [
           'bash', '-c', [
             "export GOCACHE=/tmp/go-cache",
             "export GOPATH=/tmp/go-path",
-            "GOARCH=amd64 CGO_ENABLED=0 GOOS=linux go build -tags lambda.norpc -o /asset-output/bootstrap main.go",
+            `cd '${dir}'`,
+            "GOARCH=amd64 CGO_ENABLED=0 GOOS=linux go build -tags lambda.norpc -o /asset-output/bootstrap .",
           ].join(" && "),
         ],
       },
-    }
+    });
 
     const questions = api.root.addResource('questions')
 
@@ -59,34 +127,65 @@ export class CandleBackendStack extends cdk.Stack {
         functionName: 'QuestionsGETHandler',
         runtime: lambda.Runtime.PROVIDED_AL2,
         handler: 'bootstrap',
-        code: lambda.Code.fromAsset('lambda/questions/GET', goLambdaBundleConfig),
-        environment: {
-          TABLE_NAME: questionTable.tableName,
-        },
+        code: goLambdaCode('questions/GET'),
+        environment: environmentWith(roomTable, questionTable),
     });
-    questionTable.grantReadWriteData(questionsGETHandler);
+    questionTable.grantReadData(questionsGETHandler);
+    roomTable.grantReadData(questionsGETHandler);
     questions.addMethod('GET', new apigateway.LambdaIntegration(questionsGETHandler));
     // questions:PUT
     const questionsPUTHandler = new lambda.Function(this, 'CandleBackendQuestionsPOSTHandler', {
         functionName: 'QuestionsPUTHandler',
         runtime: lambda.Runtime.PROVIDED_AL2,
         handler: 'bootstrap',
-        code: lambda.Code.fromAsset('lambda/questions/PUT', goLambdaBundleConfig),
-        environment: {
-          TABLE_NAME: questionTable.tableName,
-        },
+        code: goLambdaCode('questions/PUT'),
+        environment: environmentWith(questionTable, apiKeyTable),
     });
     questionTable.grantReadWriteData(questionsPUTHandler);
+    apiKeyTable.grantReadWriteData(questionsPUTHandler);
     questions.addMethod('PUT', new apigateway.LambdaIntegration(questionsPUTHandler));
 
+    const questionId = questions.addResource('{question_id}');
+
+    // questions/{question_id}:GET
+    const questionIdGETHandler = new lambda.Function(this, 'CandleBackendQuestionIdGETHandler', {
+        functionName: 'QuestionIdGETHandler',
+        runtime: lambda.Runtime.PROVIDED_AL2,
+        handler: 'bootstrap',
+        code: goLambdaCode('questions/{question_id}/GET'),
+        environment: environmentWith(questionTable),
+    });
+    questionTable.grantReadData(questionIdGETHandler);
+    questionId.addMethod('GET', new apigateway.LambdaIntegration(questionIdGETHandler));
+    // questions/{question_id}:PATCH
+    const questionIdPATCHHandler = new lambda.Function(this, 'CandleBackendQuestionIdPATCHHandler', {
+        functionName: 'QuestionIdPATCHHandler',
+        runtime: lambda.Runtime.PROVIDED_AL2,
+        handler: 'bootstrap',
+        code: goLambdaCode('questions/{question_id}/PATCH'),
+        environment: environmentWith(questionTable, apiKeyTable),
+    });
+    questionTable.grantReadWriteData(questionIdPATCHHandler);
+    apiKeyTable.grantReadWriteData(questionIdPATCHHandler);
+    questionId.addMethod('PATCH', new apigateway.LambdaIntegration(questionIdPATCHHandler));
+    // questions/{question_id}:DELETE
+    const questionIdDELETEHandler = new lambda.Function(this, 'CandleBackendQuestionIdDELETEHandler', {
+        functionName: 'QuestionIdDELETEHandler',
+        runtime: lambda.Runtime.PROVIDED_AL2,
+        handler: 'bootstrap',
+        code: goLambdaCode('questions/{question_id}/DELETE'),
+        environment: environmentWith(questionTable, apiKeyTable),
+    });
+    questionTable.grantReadWriteData(questionIdDELETEHandler);
+    apiKeyTable.grantReadWriteData(questionIdDELETEHandler);
+    questionId.addMethod('DELETE', new apigateway.LambdaIntegration(questionIdDELETEHandler));
+
     const seedDataLambda = new lambda.Function(this, 'CandleBackendSeedDataLambda', {
         functionName: 'SeedDataLambda',
         runtime: lambda.Runtime.PROVIDED_AL2,
         handler: 'bootstrap',
-        code: lambda.Code.fromAsset('lambda/questions/seed', goLambdaBundleConfig),
-        environment: {
-          TABLE_NAME: questionTable.tableName,
-        },
+        code: goLambdaCode('questions/seed'),
+        environment: environmentWith(questionTable),
     });
     questionTable.grantWriteData(seedDataLambda)
 
@@ -104,6 +203,146 @@ export class CandleBackendStack extends cdk.Stack {
     });
 
     seedDataLambda.grantInvoke(dbInitiateCR);
+
+    const packs = api.root.addResource('packs');
+
+    //packs:GET
+    const packsGETHandler = new lambda.Function(this, 'CandleBackendPacksGETHandler', {
+      functionName: 'PacksGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('packs/GET'),
+      environment: environmentWith(packTable),
+    });
+    packTable.grantReadData(packsGETHandler);
+    packs.addMethod('GET', new apigateway.LambdaIntegration(packsGETHandler))
+
+    //packs/{pack_id}:PUT
+    const packId = packs.addResource('{pack_id}');
+    const packIdPUTHandler = new lambda.Function(this, 'CandleBackendPackIdPUTHandler', {
+      functionName: 'PackIdPUTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('packs/{pack_id}/PUT'),
+      environment: environmentWith(questionTable, packTable, apiKeyTable),
+    });
+    packTable.grantReadWriteData(packIdPUTHandler);
+    questionTable.grantReadData(packIdPUTHandler);
+    apiKeyTable.grantReadWriteData(packIdPUTHandler);
+    packId.addMethod('PUT', new apigateway.LambdaIntegration(packIdPUTHandler))
+
+    //packs/{pack_id}:DELETE
+    const packIdDELETEHandler = new lambda.Function(this, 'CandleBackendPackIdDELETEHandler', {
+      functionName: 'PackIdDELETEHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('packs/{pack_id}/DELETE'),
+      environment: environmentWith(packTable, apiKeyTable),
+    });
+    packTable.grantReadWriteData(packIdDELETEHandler);
+    apiKeyTable.grantReadWriteData(packIdDELETEHandler);
+    packId.addMethod('DELETE', new apigateway.LambdaIntegration(packIdDELETEHandler))
+
+    //rooms:GET
+    const rooms = api.root.addResource('rooms');
+    const roomsGETHandler = new lambda.Function(this, 'CandleBackendRoomsGETHandler', {
+      functionName: 'RoomsGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('rooms/GET'),
+      environment: environmentWith(roomTable),
+    });
+    roomTable.grantReadData(roomsGETHandler);
+    rooms.addMethod('GET', new apigateway.LambdaIntegration(roomsGETHandler))
+
+    //matchmaking/quick-join:POST
+    const quickJoin = api.root.addResource('matchmaking').addResource('quick-join');
+    const quickJoinPOSTHandler = new lambda.Function(this, 'CandleBackendQuickJoinPOSTHandler', {
+      functionName: 'QuickJoinPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('matchmaking/quick-join/POST'),
+      environment: environmentWith(roomTable, userTable, questionTable),
+    });
+    roomTable.grantReadWriteData(quickJoinPOSTHandler);
+    userTable.grantReadWriteData(quickJoinPOSTHandler);
+    questionTable.grantReadData(quickJoinPOSTHandler);
+    quickJoin.addMethod('POST', new apigateway.LambdaIntegration(quickJoinPOSTHandler))
+
+    //admin/rooms:GET
+    const adminRooms = api.root.addResource('admin').addResource('rooms');
+    const adminRoomsGETHandler = new lambda.Function(this, 'CandleBackendAdminRoomsGETHandler', {
+      functionName: 'AdminRoomsGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('admin/rooms/GET'),
+      environment: environmentWith(roomTable, apiKeyTable),
+      // ルームテーブル全体を走査する
+      timeout: cdk.Duration.seconds(30),
+    });
+    roomTable.grantReadData(adminRoomsGETHandler);
+    apiKeyTable.grantReadWriteData(adminRoomsGETHandler);
+    adminRooms.addMethod('GET', new apigateway.LambdaIntegration(adminRoomsGETHandler))
+
+    //admin/rooms/{room_id}:GET
+    const adminRoomId = adminRooms.addResource('{room_id}');
+    const adminRoomIdGETHandler = new lambda.Function(this, 'CandleBackendAdminRoomIdGETHandler', {
+      functionName: 'AdminRoomIdGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('admin/rooms/{room_id}/GET'),
+      environment: environmentWith(roomTable, userTable, apiKeyTable),
+    });
+    roomTable.grantReadData(adminRoomIdGETHandler);
+    userTable.grantReadData(adminRoomIdGETHandler);
+    apiKeyTable.grantReadWriteData(adminRoomIdGETHandler);
+    adminRoomId.addMethod('GET', new apigateway.LambdaIntegration(adminRoomIdGETHandler))
+
+    //admin/rooms/{room_id}:DELETE
+    const adminRoomIdDELETEHandler = new lambda.Function(this, 'CandleBackendAdminRoomIdDELETEHandler', {
+      functionName: 'AdminRoomIdDELETEHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('admin/rooms/{room_id}/DELETE'),
+      environment: environmentWith(roomTable, userTable, apiKeyTable),
+      timeout: cdk.Duration.seconds(30),
+    });
+    roomTable.grantReadWriteData(adminRoomIdDELETEHandler);
+    userTable.grantReadWriteData(adminRoomIdDELETEHandler);
+    apiKeyTable.grantReadWriteData(adminRoomIdDELETEHandler);
+    adminRoomId.addMethod('DELETE', new apigateway.LambdaIntegration(adminRoomIdDELETEHandler))
+
+    //admin/rooms/{room_id}/transition:POST
+    const adminTransition = adminRoomId.addResource('transition');
+    const adminTransitionPOSTHandler = new lambda.Function(this, 'CandleBackendAdminTransitionPOSTHandler', {
+      functionName: 'AdminTransitionPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('admin/rooms/{room_id}/transition/POST'),
+      environment: environmentWith(roomTable, userTable, questionTable, packTable, apiKeyTable),
+      timeout: cdk.Duration.seconds(30),
+    });
+    roomTable.grantReadWriteData(adminTransitionPOSTHandler);
+    userTable.grantReadWriteData(adminTransitionPOSTHandler);
+    questionTable.grantReadData(adminTransitionPOSTHandler);
+    packTable.grantReadData(adminTransitionPOSTHandler);
+    apiKeyTable.grantReadWriteData(adminTransitionPOSTHandler);
+    adminTransition.addMethod('POST', new apigateway.LambdaIntegration(adminTransitionPOSTHandler))
+
+    //admin/rooms/{room_id}/players/{user_id}:DELETE
+    const adminPlayerId = adminRoomId.addResource('players').addResource('{user_id}');
+    const adminPlayerDELETEHandler = new lambda.Function(this, 'CandleBackendAdminPlayerDELETEHandler', {
+      functionName: 'AdminPlayerDELETEHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('admin/rooms/{room_id}/players/{user_id}/DELETE'),
+      environment: environmentWith(roomTable, userTable, apiKeyTable),
+    });
+    roomTable.grantReadWriteData(adminPlayerDELETEHandler);
+    userTable.grantReadWriteData(adminPlayerDELETEHandler);
+    apiKeyTable.grantReadWriteData(adminPlayerDELETEHandler);
+    adminPlayerId.addMethod('DELETE', new apigateway.LambdaIntegration(adminPlayerDELETEHandler))
+
     const room = api.root.addResource('room');
 
     //room:POST
@@ -111,9 +350,12 @@ export class CandleBackendStack extends cdk.Stack {
       functionName: 'RoomPOSTHandler',
       runtime: lambda.Runtime.PROVIDED_AL2,
       handler: 'bootstrap',
-      code: lambda.Code.fromAsset('lambda/room/POST',goLambdaBundleConfig),
+      code: goLambdaCode('room/POST'),
+      environment: environmentWith(roomTable, questionTable, packTable),
     });
     roomTable.grantReadWriteData(roomPOSTHandler);
+    packTable.grantReadData(roomPOSTHandler);
+    questionTable.grantReadData(roomPOSTHandler);
     room.addMethod('POST', new apigateway.LambdaIntegration(roomPOSTHandler))
 
     //room/{room_id}:POST
@@ -122,32 +364,233 @@ export class CandleBackendStack extends cdk.Stack {
       functionName: 'RoomIdPOSTHandler',
       runtime: lambda.Runtime.PROVIDED_AL2,
       handler: 'bootstrap',
-      code: lambda.Code.fromAsset('lambda/room/{room_id}/POST',goLambdaBundleConfig),
+      code: goLambdaCode('room/{room_id}/POST'),
+      environment: environmentWith(roomTable, userTable, passcodeAttemptTable),
     });
     roomTable.grantReadWriteData(roomIdPOSTHandler);
     userTable.grantReadWriteData(roomIdPOSTHandler);
+    passcodeAttemptTable.grantReadWriteData(roomIdPOSTHandler);
     roomId.addMethod('POST', new apigateway.LambdaIntegration(roomIdPOSTHandler))
 
+    //room/{room_id}/questions:GET
+    const roomIdQuestions = roomId.addResource('questions');
+    const roomIdQuestionsGETHandler = new lambda.Function(this, 'CandleBackendRoomIdQuestionsGETHandler', {
+      functionName: 'RoomIdQuestionsGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/questions/GET'),
+      environment: environmentWith(roomTable),
+    });
+    roomTable.grantReadData(roomIdQuestionsGETHandler);
+    roomIdQuestions.addMethod('GET', new apigateway.LambdaIntegration(roomIdQuestionsGETHandler))
+
+    //room/{room_id}/players/{user_id}/answers:PUT
+    const players = roomId.addResource('players');
+    const playerId = players.addResource('{user_id}');
+    const playerAnswers = playerId.addResource('answers');
+    const playerAnswersPUTHandler = new lambda.Function(this, 'CandleBackendPlayerAnswersPUTHandler', {
+      functionName: 'PlayerAnswersPUTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/players/{user_id}/answers/PUT'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadData(playerAnswersPUTHandler);
+    userTable.grantReadWriteData(playerAnswersPUTHandler);
+    playerAnswers.addMethod('PUT', new apigateway.LambdaIntegration(playerAnswersPUTHandler))
+
+    //room/{room_id}/players/{user_id}:DELETE
+    const playerDELETEHandler = new lambda.Function(this, 'CandleBackendPlayerDELETEHandler', {
+      functionName: 'PlayerDELETEHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/players/{user_id}/DELETE'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadWriteData(playerDELETEHandler);
+    userTable.grantReadWriteData(playerDELETEHandler);
+    playerId.addMethod('DELETE', new apigateway.LambdaIntegration(playerDELETEHandler))
+
+    //room/{room_id}/players/{user_id}/heartbeat:POST
+    const playerHeartbeat = playerId.addResource('heartbeat');
+    const playerHeartbeatPOSTHandler = new lambda.Function(this, 'CandleBackendPlayerHeartbeatPOSTHandler', {
+      functionName: 'PlayerHeartbeatPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/players/{user_id}/heartbeat/POST'),
+      environment: environmentWith(userTable),
+    });
+    userTable.grantReadWriteData(playerHeartbeatPOSTHandler);
+    playerHeartbeat.addMethod('POST', new apigateway.LambdaIntegration(playerHeartbeatPOSTHandler))
+
+    //room/{room_id}/lobby:GET
+    const lobby = roomId.addResource('lobby');
+    const roomIdLobbyGETHandler = new lambda.Function(this, 'CandleBackendRoomIdLobbyGETHandler', {
+      functionName: 'RoomIdLobbyGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/lobby/GET'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadData(roomIdLobbyGETHandler);
+    userTable.grantReadData(roomIdLobbyGETHandler);
+    lobby.addMethod('GET', new apigateway.LambdaIntegration(roomIdLobbyGETHandler))
+
+    //room/{room_id}/players/{user_id}/ready:PUT
+    const playerReady = playerId.addResource('ready');
+    const playerReadyPUTHandler = new lambda.Function(this, 'CandleBackendPlayerReadyPUTHandler', {
+      functionName: 'PlayerReadyPUTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/players/{user_id}/ready/PUT'),
+      environment: environmentWith(roomTable, userTable, questionTable, packTable),
+    });
+    roomTable.grantReadWriteData(playerReadyPUTHandler);
+    userTable.grantReadWriteData(playerReadyPUTHandler);
+    questionTable.grantReadData(playerReadyPUTHandler);
+    packTable.grantReadData(playerReadyPUTHandler);
+    playerReady.addMethod('PUT', new apigateway.LambdaIntegration(playerReadyPUTHandler))
+
     //room/{room_id}/start:POST
     const start = roomId.addResource('start');
     const roomIdStartPOSTHandler = new lambda.Function(this, 'CandleBackendRoomIdStartPOSTHandler', {
       functionName: 'RoomIdStartPOSTHandler',
       runtime: lambda.Runtime.PROVIDED_AL2,
       handler: 'bootstrap',
-      code: lambda.Code.fromAsset('lambda/room/{room_id}/start/POST',goLambdaBundleConfig),
+      code: goLambdaCode('room/{room_id}/start/POST'),
+      environment: environmentWith(roomTable, userTable, questionTable, packTable),
     });
     roomTable.grantReadWriteData(roomIdStartPOSTHandler);
     userTable.grantReadWriteData(roomIdStartPOSTHandler);
-    questionTable.grantReadWriteData(roomIdStartPOSTHandler);
+    questionTable.grantReadData(roomIdStartPOSTHandler);
+    packTable.grantReadData(roomIdStartPOSTHandler);
     start.addMethod('POST', new apigateway.LambdaIntegration(roomIdStartPOSTHandler))
 
+    //room/{room_id}/rounds:POST
+    const rounds = roomId.addResource('rounds');
+    const roomIdRoundsPOSTHandler = new lambda.Function(this, 'CandleBackendRoomIdRoundsPOSTHandler', {
+      functionName: 'RoomIdRoundsPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/rounds/POST'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadWriteData(roomIdRoundsPOSTHandler);
+    userTable.grantReadWriteData(roomIdRoundsPOSTHandler);
+    rounds.addMethod('POST', new apigateway.LambdaIntegration(roomIdRoundsPOSTHandler))
+
+    //room/{room_id}/standings:GET
+    const standings = roomId.addResource('standings');
+    const roomIdStandingsGETHandler = new lambda.Function(this, 'CandleBackendRoomIdStandingsGETHandler', {
+      functionName: 'RoomIdStandingsGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/standings/GET'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadData(roomIdStandingsGETHandler);
+    userTable.grantReadData(roomIdStandingsGETHandler);
+    standings.addMethod('GET', new apigateway.LambdaIntegration(roomIdStandingsGETHandler))
+
+    //room/{room_id}/rematch:POST
+    const rematch = roomId.addResource('rematch');
+    const roomIdRematchPOSTHandler = new lambda.Function(this, 'CandleBackendRoomIdRematchPOSTHandler', {
+      functionName: 'RoomIdRematchPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/rematch/POST'),
+      environment: environmentWith(roomTable, userTable, questionTable, packTable),
+    });
+    roomTable.grantReadWriteData(roomIdRematchPOSTHandler);
+    userTable.grantReadWriteData(roomIdRematchPOSTHandler);
+    questionTable.grantReadData(roomIdRematchPOSTHandler);
+    packTable.grantReadData(roomIdRematchPOSTHandler);
+    rematch.addMethod('POST', new apigateway.LambdaIntegration(roomIdRematchPOSTHandler))
+
+    //room/{room_id}/spectators:POST
+    const spectators = roomId.addResource('spectators');
+    const roomIdSpectatorsPOSTHandler = new lambda.Function(this, 'CandleBackendRoomIdSpectatorsPOSTHandler', {
+      functionName: 'RoomIdSpectatorsPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/spectators/POST'),
+      environment: environmentWith(roomTable, passcodeAttemptTable),
+    });
+    roomTable.grantReadWriteData(roomIdSpectatorsPOSTHandler);
+    passcodeAttemptTable.grantReadWriteData(roomIdSpectatorsPOSTHandler);
+    spectators.addMethod('POST', new apigateway.LambdaIntegration(roomIdSpectatorsPOSTHandler))
+
+    //room/{room_id}/spectate:GET
+    const spectate = roomId.addResource('spectate');
+    const roomIdSpectateGETHandler = new lambda.Function(this, 'CandleBackendRoomIdSpectateGETHandler', {
+      functionName: 'RoomIdSpectateGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/spectate/GET'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadData(roomIdSpectateGETHandler);
+    userTable.grantReadData(roomIdSpectateGETHandler);
+    spectate.addMethod('GET', new apigateway.LambdaIntegration(roomIdSpectateGETHandler))
+
+    //room/{room_id}/rejoin:POST
+    const rejoin = roomId.addResource('rejoin');
+    const roomIdRejoinPOSTHandler = new lambda.Function(this, 'CandleBackendRoomIdRejoinPOSTHandler', {
+      functionName: 'RoomIdRejoinPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/rejoin/POST'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadData(roomIdRejoinPOSTHandler);
+    userTable.grantReadWriteData(roomIdRejoinPOSTHandler);
+    rejoin.addMethod('POST', new apigateway.LambdaIntegration(roomIdRejoinPOSTHandler))
+
+    //room/{room_id}/invites:POST
+    const invites = roomId.addResource('invites');
+    const roomIdInvitesPOSTHandler = new lambda.Function(this, 'CandleBackendRoomIdInvitesPOSTHandler', {
+      functionName: 'RoomIdInvitesPOSTHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/invites/POST'),
+      environment: environmentWith(roomTable, userTable),
+    });
+    roomTable.grantReadWriteData(roomIdInvitesPOSTHandler);
+    userTable.grantReadData(roomIdInvitesPOSTHandler);
+    invites.addMethod('POST', new apigateway.LambdaIntegration(roomIdInvitesPOSTHandler))
+
+    //invites/{invite_token}:GET
+    const inviteToken = api.root.addResource('invites').addResource('{invite_token}');
+    const inviteTokenGETHandler = new lambda.Function(this, 'CandleBackendInviteTokenGETHandler', {
+      functionName: 'InviteTokenGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('invites/{invite_token}/GET'),
+      environment: environmentWith(roomTable),
+    });
+    roomTable.grantReadData(inviteTokenGETHandler);
+    inviteToken.addMethod('GET', new apigateway.LambdaIntegration(inviteTokenGETHandler))
+
+    //room/{room_id}/qr:GET
+    const qr = roomId.addResource('qr');
+    const roomIdQrGETHandler = new lambda.Function(this, 'CandleBackendRoomIdQrGETHandler', {
+      functionName: 'RoomIdQrGETHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('room/{room_id}/qr/GET'),
+      environment: environmentWith(roomTable),
+    });
+    roomTable.grantReadData(roomIdQrGETHandler);
+    qr.addMethod('GET', new apigateway.LambdaIntegration(roomIdQrGETHandler))
+
     //room/{room_id}/result:GET
     const result = roomId.addResource('result');
     const roomIdResultGETHandler = new lambda.Function(this, 'CandleBackendRoomIdResultGETHandler', {
       functionName: 'RoomIdResultGETHandler',
       runtime: lambda.Runtime.PROVIDED_AL2,
       handler: 'bootstrap',
-      code: lambda.Code.fromAsset('lambda/room/{room_id}/result/{user_id}/GET',goLambdaBundleConfig),
+      code: goLambdaCode('room/{room_id}/result/{user_id}/GET'),
+      environment: environmentWith(roomTable, userTable),
     });
     roomTable.grantReadWriteData(roomIdResultGETHandler);
     userTable.grantReadWriteData(roomIdResultGETHandler);
@@ -158,14 +601,34 @@ export class CandleBackendStack extends cdk.Stack {
       functionName: 'RoomIdResultPOSTHandler',
       runtime: lambda.Runtime.PROVIDED_AL2,
       handler: 'bootstrap',
-      code: lambda.Code.fromAsset('lambda/room/{room_id}/result/POST',goLambdaBundleConfig),
-      environment: {
-        TABLE_NAME: userTable.tableName,
-      },
+      code: goLambdaCode('room/{room_id}/result/POST'),
+      environment: environmentWith(roomTable, userTable),
     });
     roomTable.grantReadWriteData(roomIdResultPOSTHandler);
     userTable.grantReadWriteData(roomIdResultPOSTHandler);
     result.addMethod('POST', new apigateway.LambdaIntegration(roomIdResultPOSTHandler))
 
+    // 締め切りを過ぎたルームを進める finalizer を1分ごとに実行する
+    const finalizerHandler = new lambda.Function(this, 'CandleBackendFinalizerHandler', {
+      functionName: 'FinalizerHandler',
+      runtime: lambda.Runtime.PROVIDED_AL2,
+      handler: 'bootstrap',
+      code: goLambdaCode('finalizer'),
+      environment: {
+        ...environmentWith(roomTable, userTable, questionTable, packTable),
+        // ハートビートが途絶えたプレイヤーを離脱扱いにするまでの時間 (例: 5m)。未設定なら離脱扱いにしない
+        PLAYER_IDLE_TIMEOUT: this.node.tryGetContext('playerIdleTimeout') ?? '',
+      },
+      timeout: cdk.Duration.minutes(1),
+    });
+    roomTable.grantReadWriteData(finalizerHandler);
+    userTable.grantReadWriteData(finalizerHandler);
+    questionTable.grantReadData(finalizerHandler);
+    packTable.grantReadData(finalizerHandler);
+    new events.Rule(this, 'CandleBackendFinalizerSchedule', {
+      schedule: events.Schedule.rate(cdk.Duration.minutes(1)),
+      targets: [new targets.LambdaFunction(finalizerHandler)],
+    });
+
   }
 }
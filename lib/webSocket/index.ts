@@ -8,7 +8,52 @@ interface Client {
 
 const clients: Client[] = [];
 
-const wss = new WebSocketServer({ port: 80 });
+// バックエンドの Lambda からトピックに通知するための HTTP エンドポイント (POST /publish)
+const publishToken = process.env.PUBLISH_TOKEN ?? '';
+
+const server = http.createServer((req, res) => {
+  if (req.method !== 'POST' || req.url !== '/publish') {
+    res.statusCode = 404;
+    res.end();
+    return;
+  }
+  if (!publishToken || req.headers['authorization'] !== `Bearer ${publishToken}`) {
+    res.statusCode = 403;
+    res.end();
+    return;
+  }
+
+  let body = '';
+  req.on('data', (chunk) => {
+    body += chunk;
+  });
+  req.on('end', () => {
+    try {
+      const data = JSON.parse(body);
+      if (!data.topic || typeof data.message !== 'string') {
+        res.statusCode = 400;
+        res.end();
+        return;
+      }
+      let delivered = 0;
+      clients.forEach((c) => {
+        if (c.topic === data.topic) {
+          c.ws.send(data.message);
+          delivered++;
+        }
+      });
+      res.statusCode = 200;
+      res.setHeader('Content-Type', 'application/json');
+      res.end(JSON.stringify({ delivered }));
+    } catch (error) {
+      console.error('通知の解析に失敗:', error);
+      res.statusCode = 400;
+      res.end();
+    }
+  });
+});
+
+const wss = new WebSocketServer({ server });
 
 wss.on('connection', (ws: WebSocket) => {
   const client: Client = { ws, topic: '' };
@@ -51,5 +96,6 @@ const httpServer = http.createServer((req, res) => {
 });
 
 httpServer.listen(8000);
+server.listen(80);
 console.log('WebSocketサーバーがポート80で稼働中');
 
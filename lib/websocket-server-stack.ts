@@ -18,6 +18,10 @@ export class CandleBackendWebSocketServerStack extends cdk.Stack {
             taskImageOptions: {
                 image: wsImage,
                 containerPort: 80,
+                environment: {
+                    // バックエンドからの通知 (POST /publish) に必要なトークン
+                    PUBLISH_TOKEN: this.node.tryGetContext('notifyToken') ?? '',
+                },
             },
             memoryLimitMiB: 512,
             publicLoadBalancer: true,
@@ -0,0 +1,262 @@
+// apikey は運営用 API の API キーを発行・一覧・失効させる CLI
+//
+//	apikey create -name <名前> -scopes questions:write,rooms:admin
+//	apikey list
+//	apikey revoke <key_id>
+//
+// テーブル名は -table か API_KEY_TABLE_NAME で指定する。AWS の認証情報は通常の方法で読み込む
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// 発行できる権限
+var knownScopes = []string{"questions:write", "rooms:admin", "stats:read"}
+
+type APIKey struct {
+	KeyID      string   `dynamodbav:"key_id"`
+	Name       string   `dynamodbav:"name"`
+	SecretHash string   `dynamodbav:"secret_hash"`
+	Scopes     []string `dynamodbav:"scopes,stringset"`
+	CreatedAt  int64    `dynamodbav:"created_at"`
+	LastUsedAt int64    `dynamodbav:"last_used_at,omitempty"`
+	RevokedAt  int64    `dynamodbav:"revoked_at,omitempty"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "create":
+		err = runCreate(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "revoke":
+		err = runRevoke(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "apikey:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apikey create -name <name> -scopes <scope,...> | list | revoke <key_id>")
+	fmt.Fprintln(os.Stderr, "scopes:", strings.Join(knownScopes, ", "))
+	os.Exit(2)
+}
+
+// サブコマンド共通の -table を足して引数を読み、テーブル名と位置引数を返す。
+// flag は最初の位置引数で読むのをやめるので、revoke <key_id> -table X のように後ろに書いたフラグも読めるよう繰り返す
+func parseFlags(fs *flag.FlagSet, args []string) (string, []string, error) {
+	table := fs.String("table", os.Getenv("API_KEY_TABLE_NAME"), "API key table name")
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return "", nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if *table == "" {
+		return "", nil, errors.New("set -table or API_KEY_TABLE_NAME")
+	}
+	return *table, positional, nil
+}
+
+func newClient(ctx context.Context) (*dynamodb.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dynamodb.NewFromConfig(cfg), nil
+}
+
+func runCreate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	name := fs.String("name", "", "who or what the key is for")
+	scopeList := fs.String("scopes", "", "comma-separated scopes")
+	table, positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("create takes no arguments, got %q", positional)
+	}
+	if strings.TrimSpace(*name) == "" {
+		return errors.New("-name is required")
+	}
+	var scopes []string
+	for _, scope := range strings.Split(*scopeList, ",") {
+		if scope = strings.TrimSpace(scope); scope == "" || slices.Contains(scopes, scope) {
+			continue
+		}
+		if !slices.Contains(knownScopes, scope) {
+			return fmt.Errorf("unknown scope %q (known: %s)", scope, strings.Join(knownScopes, ", "))
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return errors.New("-scopes is required")
+	}
+
+	keyID, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(secret))
+	key := APIKey{
+		KeyID:      keyID,
+		Name:       strings.TrimSpace(*name),
+		SecretHash: hex.EncodeToString(sum[:]),
+		Scopes:     scopes,
+		CreatedAt:  time.Now().Unix(),
+	}
+	item, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(key_id)"),
+	})
+	if err != nil {
+		return err
+	}
+
+	// secret はここでしか表示しない
+	fmt.Fprintln(os.Stderr, "Store this key now. It cannot be shown again.")
+	fmt.Println(keyID + "." + secret)
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	table, positional, err := parseFlags(flag.NewFlagSet("list", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("list takes no arguments, got %q", positional)
+	}
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	var keys []APIKey
+	paginator := dynamodb.NewScanPaginator(svc, &dynamodb.ScanInput{TableName: aws.String(table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		var items []APIKey
+		if err = attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return err
+		}
+		keys = append(keys, items...)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt < keys[j].CreatedAt
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY_ID\tNAME\tSCOPES\tCREATED\tLAST_USED\tREVOKED")
+	for _, key := range keys {
+		sort.Strings(key.Scopes)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.KeyID, key.Name, strings.Join(key.Scopes, ","),
+			formatTime(key.CreatedAt), formatTime(key.LastUsedAt), formatTime(key.RevokedAt))
+	}
+	return w.Flush()
+}
+
+func runRevoke(ctx context.Context, args []string) error {
+	table, positional, err := parseFlags(flag.NewFlagSet("revoke", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("revoke takes exactly one key_id")
+	}
+	keyID := positional[0]
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 失効したキーも監査のために残しておく
+	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"key_id": &types.AttributeValueMemberS{Value: keyID},
+		},
+		UpdateExpression:    aws.String("SET revoked_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(key_id) AND attribute_not_exists(revoked_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return fmt.Errorf("key %s does not exist or is already revoked", keyID)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("revoked", keyID)
+	return nil
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+func formatTime(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Unix(unix, 0).Format(time.RFC3339)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		env            string
+		wantTable      string
+		wantPositional []string
+		wantErr        bool
+	}{
+		{name: "flag before key_id", args: []string{"-table", "keys", "abc"}, wantTable: "keys", wantPositional: []string{"abc"}},
+		{name: "flag after key_id", args: []string{"abc", "-table", "keys"}, wantTable: "keys", wantPositional: []string{"abc"}},
+		{name: "flag between arguments", args: []string{"abc", "-table=keys", "def"}, wantTable: "keys", wantPositional: []string{"abc", "def"}},
+		{name: "table from the environment", args: []string{"abc"}, env: "env-keys", wantTable: "env-keys", wantPositional: []string{"abc"}},
+		{name: "flag overrides the environment", args: []string{"abc", "-table", "keys"}, env: "env-keys", wantTable: "keys", wantPositional: []string{"abc"}},
+		{name: "no table", args: []string{"abc"}, wantErr: true},
+		{name: "unknown flag after key_id", args: []string{"abc", "-tabel", "keys"}, env: "env-keys", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_KEY_TABLE_NAME", tt.env)
+			fs := flag.NewFlagSet("revoke", flag.ContinueOnError)
+			fs.SetOutput(nilWriter{})
+			table, positional, err := parseFlags(fs, tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseFlags(%q) succeeded, want an error", tt.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table != tt.wantTable || !slices.Equal(positional, tt.wantPositional) {
+				t.Errorf("parseFlags(%q) = %q, %q; want %q, %q", tt.args, table, positional, tt.wantTable, tt.wantPositional)
+			}
+		})
+	}
+}
+
+type nilWriter struct{}
+
+func (nilWriter) Write(p []byte) (int, error) { return len(p), nil }